@@ -5,13 +5,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/src-d/gitbase-web/server"
+	"github.com/src-d/gitbase-web/server/bblfshpool"
 	"github.com/src-d/gitbase-web/server/handler"
+	"github.com/src-d/gitbase-web/server/memstore"
+	"github.com/src-d/gitbase-web/server/scheduler"
+	"github.com/src-d/gitbase-web/server/service"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"gopkg.in/src-d/go-cli.v0"
 	"gopkg.in/src-d/go-log.v1"
 )
@@ -32,23 +37,50 @@ var app = cli.New(name, version, build, "gitbase web client")
 // The next release should make this parameter optional for us:
 // https://github.com/go-sql-driver/mysql/pull/680
 type ServeCommand struct {
-	cli.PlainCommand `name:"serve" short-description:"serve the app" long-description:"starts serving the application"`
-	cli.LogOptions   `group:"Log Options"`
-	Host             string `long:"host" env:"GITBASEPG_HOST" default:"0.0.0.0" description:"IP address to bind the HTTP server"`
-	Port             int    `long:"port" env:"GITBASEPG_PORT" default:"8080" description:"Port to bind the HTTP server"`
-	ServerURL        string `long:"server" env:"GITBASEPG_SERVER_URL" description:"URL used to access the application in the form 'HOSTNAME[:PORT]'. Leave it unset to allow connections from any proxy or public address"`
-	DBConn           string `long:"db" env:"GITBASEPG_DB_CONNECTION" default:"root@tcp(localhost:3306)/none?maxAllowedPacket=4194304" description:"gitbase connection string. Use the DSN (Data Source Name) format described in the Go MySQL Driver docs: https://github.com/go-sql-driver/mysql#dsn-data-source-name"`
-	ConnMaxLifetime  int    `long:"conn-max-lifetime" env:"GITBASEPG_CONN_MAX_LIFETIME" default:"30" description:"Connections max life time since their creation in seconds"`
-	SelectLimit      int    `long:"select-limit" env:"GITBASEPG_SELECT_LIMIT" default:"100" description:"Default 'LIMIT' forced on all the SQL queries done from the UI. Set it to 0 to remove any limit"`
-	BblfshServerURL  string `long:"bblfsh" env:"GITBASEPG_BBLFSH_SERVER_URL" default:"127.0.0.1:9432" description:"Address where bblfsh server is listening"`
-	FooterHTML       string `long:"footer" env:"GITBASEPG_FOOTER_HTML" description:"Allows to add any custom html to the page footer. It must be a string encoded in base64. Use it, for example, to add your analytics tracking code snippet"`
+	cli.PlainCommand    `name:"serve" short-description:"serve the app" long-description:"starts serving the application"`
+	cli.LogOptions      `group:"Log Options"`
+	Host                string `long:"host" env:"GITBASEPG_HOST" default:"0.0.0.0" description:"IP address to bind the HTTP server"`
+	Port                int    `long:"port" env:"GITBASEPG_PORT" default:"8080" description:"Port to bind the HTTP server"`
+	ServerURL           string `long:"server" env:"GITBASEPG_SERVER_URL" description:"URL used to access the application in the form 'HOSTNAME[:PORT]'. Leave it unset to allow connections from any proxy or public address"`
+	DBConn              string `long:"db" env:"GITBASEPG_DB_CONNECTION" default:"root@tcp(localhost:3306)/none?maxAllowedPacket=4194304" description:"gitbase connection string. Use the DSN (Data Source Name) format described in the Go MySQL Driver docs: https://github.com/go-sql-driver/mysql#dsn-data-source-name"`
+	ConnMaxLifetime     int    `long:"conn-max-lifetime" env:"GITBASEPG_CONN_MAX_LIFETIME" default:"30" description:"Connections max life time since their creation in seconds"`
+	SelectLimit         int    `long:"select-limit" env:"GITBASEPG_SELECT_LIMIT" default:"100" description:"Default 'LIMIT' forced on all the SQL queries done from the UI. Set it to 0 to remove any limit"`
+	BblfshServerURL     string `long:"bblfsh" env:"GITBASEPG_BBLFSH_SERVER_URL" default:"127.0.0.1:9432" description:"Address where bblfsh server is listening. Several comma-separated addresses can be given to spread the requests among them"`
+	BblfshEndpoints     string `long:"bblfsh-endpoints" env:"GITBASEPG_BBLFSH_ENDPOINTS" description:"Additional bblfsh servers that the requests can select by name, in the form 'NAME=ADDRESS[,ADDRESS...][;NAME=ADDRESS...]'"`
+	BblfshAllowURL      bool   `long:"bblfsh-allow-server-url" env:"GITBASEPG_BBLFSH_ALLOW_SERVER_URL" description:"Allow the /parse requests to use any bblfsh server address with the serverUrl parameter. Otherwise, only the configured addresses are accepted"`
+	BblfshCheckInterval int    `long:"bblfsh-check-interval" env:"GITBASEPG_BBLFSH_CHECK_INTERVAL" default:"30" description:"Time between the health checks of the bblfsh servers, in seconds"`
+	LanguagesTTL        int    `long:"languages-ttl" env:"GITBASEPG_LANGUAGES_TTL" default:"300" description:"Time the languages supported by bblfsh are cached, in seconds. They are refreshed in the background before they expire"`
+	ParseMaxBytes       int    `long:"parse-max-bytes" env:"GITBASEPG_PARSE_MAX_BYTES" default:"4194304" description:"Maximum size in bytes of the files parsed with bblfsh. Set it to 0 to remove the limit"`
+	ParseTimeout        int    `long:"parse-timeout" env:"GITBASEPG_PARSE_TIMEOUT" default:"30" description:"Maximum time to wait for bblfsh to parse a file, in seconds. Set it to 0 to remove the timeout"`
+	ParseWorkers        int    `long:"parse-workers" env:"GITBASEPG_PARSE_WORKERS" default:"4" description:"Number of files parsed concurrently by each /parse/batch request"`
+	ParseBatchMaxFiles  int    `long:"parse-batch-max-files" env:"GITBASEPG_PARSE_BATCH_MAX_FILES" default:"100" description:"Maximum number of files of each /parse/batch request. Set it to 0 to remove the limit"`
+	ExportMaxRows       int    `long:"export-max-rows" env:"GITBASEPG_EXPORT_MAX_ROWS" default:"0" description:"Maximum number of rows in the files returned by /export. Set it to 0 to remove the limit"`
+	ExportMaxBytes      int64  `long:"export-max-bytes" env:"GITBASEPG_EXPORT_MAX_BYTES" default:"0" description:"Maximum size in bytes of the values in the files returned by /export. Set it to 0 to remove the limit"`
+	SearchMaxMatches    int    `long:"search-max-matches" env:"GITBASEPG_SEARCH_MAX_MATCHES" default:"1000" description:"Maximum number of matches found by each /search request. Set it to 0 to remove the limit"`
+	UASTStoreMaxBytes   int64  `long:"uast-store-max-bytes" env:"GITBASEPG_UAST_STORE_MAX_BYTES" default:"268435456" description:"Maximum size in bytes of the UASTs returned by /query that are kept in memory, so /filter can use them by handle"`
+	UASTStoreTTL        int    `long:"uast-store-ttl" env:"GITBASEPG_UAST_STORE_TTL" default:"1800" description:"Time the UASTs returned by /query are kept in memory since they were last used, in seconds"`
+	CellMaxBytes        int    `long:"cell-max-bytes" env:"GITBASEPG_CELL_MAX_BYTES" default:"0" description:"Default maximum size in bytes of the text values returned by /query. The longer ones are truncated, and can be loaded with /cell. 0 means no limit"`
+	CellStoreMaxBytes   int64  `long:"cell-store-max-bytes" env:"GITBASEPG_CELL_STORE_MAX_BYTES" default:"268435456" description:"Maximum size in bytes of the full text values truncated by /query that are kept in memory, so /cell can return them"`
+	CellStoreTTL        int    `long:"cell-store-ttl" env:"GITBASEPG_CELL_STORE_TTL" default:"600" description:"Time the full text values truncated by /query are kept in memory since they were last used, in seconds"`
+	Mailmap             string `long:"mailmap" env:"GITBASEPG_MAILMAP" description:"Path of a mailmap file, in the git .mailmap format, used to merge the identities of the commit authors in /analytics/contributors"`
+	SchedulesDir        string `long:"schedules-dir" env:"GITBASEPG_SCHEDULES_DIR" description:"Directory where the scheduled queries and their result snapshots are stored. Leave it unset to disable the scheduled queries"`
+	ScheduleTimeout     int    `long:"schedule-timeout" env:"GITBASEPG_SCHEDULE_TIMEOUT" default:"300" description:"Default timeout for each run of a scheduled query, in seconds. Set it to 0 to remove the timeout"`
+	ScheduleMaxRuns     int    `long:"schedule-max-runs" env:"GITBASEPG_SCHEDULE_MAX_RUNS" default:"100" description:"Default number of runs kept for each scheduled query, with their snapshots. The older ones are deleted. Set it to 0 to keep all of them"`
+	ScheduleMaxRows     int    `long:"schedule-max-rows" env:"GITBASEPG_SCHEDULE_MAX_ROWS" default:"10000" description:"Maximum number of rows in the snapshots of the scheduled queries. Set it to 0 to remove the limit"`
+	ScheduleMaxBytes    int64  `long:"schedule-max-bytes" env:"GITBASEPG_SCHEDULE_MAX_BYTES" default:"67108864" description:"Maximum size in bytes of the values in the snapshots of the scheduled queries. Set it to 0 to remove the limit"`
+	FooterHTML          string `long:"footer" env:"GITBASEPG_FOOTER_HTML" description:"Allows to add any custom html to the page footer. It must be a string encoded in base64. Use it, for example, to add your analytics tracking code snippet"`
 }
 
 func (c *ServeCommand) Execute(args []string) error {
 	c.initLog()
 
 	// database
-	db, err := sql.Open("mysql", c.DBConn)
+	dsn, err := dbDSN(c.DBConn)
+	if err != nil {
+		return fmt.Errorf("error parsing the database connection string: %s", err.Error())
+	}
+
+	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		return fmt.Errorf("error opening the database: %s", err.Error())
 	}
@@ -58,8 +90,78 @@ func (c *ServeCommand) Execute(args []string) error {
 
 	static := handler.NewStatic("build/public", c.ServerURL, c.SelectLimit, c.FooterHTML)
 
+	// bblfsh
+	bblfshEndpoints, err := c.bblfshEndpoints()
+	if err != nil {
+		return fmt.Errorf("error configuring bblfsh: %s", err.Error())
+	}
+
+	bblfshEndpoints.Default.Start()
+	defer bblfshEndpoints.Default.Stop()
+
+	for _, pool := range bblfshEndpoints.Named {
+		pool.Start()
+		defer pool.Stop()
+	}
+
+	languages := handler.NewLanguagesCache(bblfshEndpoints.Default,
+		time.Duration(c.LanguagesTTL)*time.Second)
+	languages.Start()
+	defer languages.Stop()
+
+	queryOpts := handler.QueryOptions{
+		UASTs: memstore.New(memstore.Options{
+			MaxBytes: c.UASTStoreMaxBytes,
+			TTL:      time.Duration(c.UASTStoreTTL) * time.Second,
+		}),
+		Cells: memstore.New(memstore.Options{
+			MaxBytes: c.CellStoreMaxBytes,
+			TTL:      time.Duration(c.CellStoreTTL) * time.Second,
+		}),
+		CellMaxBytes: c.CellMaxBytes,
+	}
+
+	var mailmap *service.Mailmap
+	if c.Mailmap != "" {
+		mailmap, err = service.LoadMailmap(c.Mailmap)
+		if err != nil {
+			return fmt.Errorf("error loading the mailmap: %s", err.Error())
+		}
+	}
+
+	// scheduled queries
+	var sched *scheduler.Scheduler
+	if c.SchedulesDir != "" {
+		store, err := scheduler.NewStore(c.SchedulesDir)
+		if err != nil {
+			return fmt.Errorf("error opening the schedules directory: %s", err.Error())
+		}
+
+		query := handler.ScheduledQuery(db,
+			handler.ExportLimits{MaxRows: c.ScheduleMaxRows, MaxBytes: c.ScheduleMaxBytes})
+		sched, err = scheduler.New(store, query, scheduler.Options{
+			Timeout: time.Duration(c.ScheduleTimeout) * time.Second,
+			MaxRuns: c.ScheduleMaxRuns,
+		})
+		if err != nil {
+			return fmt.Errorf("error loading the scheduled queries: %s", err.Error())
+		}
+
+		sched.Start()
+		defer sched.Stop()
+	}
+
 	// start the router
-	router := server.Router(logrus.StandardLogger(), static, version, db, c.BblfshServerURL)
+	router := server.Router(logrus.StandardLogger(), static, version, db, server.RouterOptions{
+		Bblfsh:       bblfshEndpoints,
+		Languages:    languages,
+		ParseWorkers: c.ParseWorkers,
+		Export:       handler.ExportLimits{MaxRows: c.ExportMaxRows, MaxBytes: c.ExportMaxBytes},
+		Search:       handler.SearchLimits{MaxMatches: c.SearchMaxMatches},
+		Query:        queryOpts,
+		Mailmap:      mailmap,
+		Scheduler:    sched,
+	})
 
 	log.With(log.Fields{"version": version, "build": build}).
 		Infof("listening on %s:%d", c.Host, c.Port)
@@ -69,6 +171,67 @@ func (c *ServeCommand) Execute(args []string) error {
 	return err
 }
 
+// dbDSN returns the connection string with interpolateParams enabled, so the
+// driver replaces the placeholders of the queries with their escaped
+// arguments instead of using prepared statements
+func dbDSN(dsn string) (string, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", err
+	}
+
+	cfg.InterpolateParams = true
+	return cfg.FormatDSN(), nil
+}
+
+// bblfshEndpoints returns the default and named bblfsh endpoints
+func (c *ServeCommand) bblfshEndpoints() (*handler.BblfshEndpoints, error) {
+	opts := bblfshpool.Options{
+		CheckInterval: time.Duration(c.BblfshCheckInterval) * time.Second,
+	}
+
+	defaultPool, err := bblfshpool.New(strings.Split(c.BblfshServerURL, ","), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := &handler.BblfshEndpoints{
+		Default:        defaultPool,
+		Named:          make(map[string]*bblfshpool.Pool),
+		AllowServerURL: c.BblfshAllowURL,
+		Limits: handler.ParseLimits{
+			MaxContentBytes: c.ParseMaxBytes,
+			Timeout:         time.Duration(c.ParseTimeout) * time.Second,
+			MaxBatchFiles:   c.ParseBatchMaxFiles,
+		},
+	}
+
+	for _, endpoint := range strings.Split(c.BblfshEndpoints, ";") {
+		if strings.TrimSpace(endpoint) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(endpoint, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("invalid bblfsh endpoint %q, it must be NAME=ADDRESS", endpoint)
+		}
+
+		if _, ok := endpoints.Named[name]; ok || name == "default" {
+			return nil, fmt.Errorf("duplicated bblfsh endpoint name %q", name)
+		}
+
+		pool, err := bblfshpool.New(strings.Split(parts[1], ","), opts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bblfsh endpoint %q: %s", name, err)
+		}
+
+		endpoints.Named[name] = pool
+	}
+
+	return endpoints, nil
+}
+
 func (c *ServeCommand) initLog() {
 	if c.LogFields == "" {
 		bytes, err := json.Marshal(log.Fields{"app": name})
@@ -308,7 +308,7 @@ FROM ( SELECT MONTH(committer_when) as month,
     this.setState({ showModal: false, modalTitle: null, modalContent: null });
   }
 
-  showUAST(uast, protobufs) {
+  showUAST(uast, source) {
     this.setState({
       showModal: true,
       modalTitle: (
@@ -320,7 +320,7 @@ FROM ( SELECT MONTH(committer_when) as month,
           />
         </div>
       ),
-      modalContent: <UASTViewer uast={uast} protobufs={protobufs} />
+      modalContent: <UASTViewer uast={uast} source={source} />
     });
   }
 
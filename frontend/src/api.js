@@ -163,16 +163,26 @@ function getLanguages() {
   return apiCall(`/get-languages`).then(res => res.data);
 }
 
-function filterUAST(protobufs, filter) {
+// source is the UAST to filter, with the handle of a UAST kept by the
+// server, or with its protobufs
+function filterUAST(source, filter) {
   return apiCall('/filter', {
     method: 'POST',
     body: {
-      protobufs,
+      handle: source.handle,
+      protobufs: source.protobufs,
       filter
     }
   }).then(res => res.data);
 }
 
+// cell returns the full value of a text cell truncated by /query
+function cell(id) {
+  const params = new URLSearchParams();
+  params.append('id', id);
+  return apiCall(`/cell?${params.toString()}`).then(res => res.data);
+}
+
 function version() {
   return apiCall(`/version`).then(res => res.data);
 }
@@ -185,6 +195,7 @@ export default {
   parseCode,
   getLanguages,
   filterUAST,
+  cell,
   version,
   uastModes,
   defaultUastMode
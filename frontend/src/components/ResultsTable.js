@@ -2,10 +2,32 @@ import React, { Component, Fragment } from 'react';
 import PropTypes from 'prop-types';
 import ReactTable from 'react-table';
 import { Button } from 'react-bootstrap';
+import api from '../api';
 import 'react-table/react-table.css';
 import './ResultsTable.less';
 
 class ResultsTable extends Component {
+  // showTruncated loads the full value of a text cell truncated by the
+  // server, or shows the truncated value if the server did not keep it
+  showTruncated(cell) {
+    const { showCode } = this.props;
+
+    if (!cell.cell) {
+      showCode(cell.value);
+      return;
+    }
+
+    api
+      .cell(cell.cell)
+      .then(value => showCode(value))
+      .catch(err => {
+        // we don't have UI for this error
+        // eslint-disable-next-line no-console
+        console.error(`Can't load the full cell value: ${err}`);
+        showCode(cell.value);
+      });
+  }
+
   render() {
     const { showCode, showUAST } = this.props;
     const columns = this.props.response.meta.headers.map(col => ({
@@ -37,13 +59,31 @@ class ResultsTable extends Component {
             }
             return v;
           case 'object':
-            // UAST column
-            const protobufs = row[`__${col}-protobufs`];
+            // Long text truncated by the server
+            if (v && v.truncated) {
+              return (
+                <Button
+                  bsStyle="gbpl-tertiary"
+                  className="btn-compact"
+                  title={`${v.size} bytes`}
+                  onClick={() => this.showTruncated(v)}
+                >
+                  CODE
+                </Button>
+              );
+            }
+
+            // UAST column. The server keeps it and returns its handle, or
+            // returns its protobufs if it could not keep it
+            const source = {
+              handle: row[`__${col}-handle`],
+              protobufs: row[`__${col}-protobufs`]
+            };
             return (
               <Button
                 bsStyle="gbpl-tertiary"
                 className="btn-compact"
-                onClick={() => showUAST(v, protobufs)}
+                onClick={() => showUAST(v, source)}
               >
                 UAST
               </Button>
@@ -40,7 +40,7 @@ class UASTViewer extends Component {
     this.setState({ flatUast: null, error: null, loading: true });
 
     api
-      .filterUAST(this.props.protobufs, this.state.filter)
+      .filterUAST(this.props.source, this.state.filter)
       .then(uast => {
         this.setState({ flatUast: this.transform(uast) });
       })
@@ -99,7 +99,10 @@ class UASTViewer extends Component {
 
 UASTViewer.propTypes = {
   uast: PropTypes.array,
-  protobufs: PropTypes.string
+  source: PropTypes.shape({
+    handle: PropTypes.string,
+    protobufs: PropTypes.string
+  })
 };
 
 export default UASTViewer;
@@ -0,0 +1,359 @@
+// Package bblfshpool keeps long-lived connections to one or more bblfsh
+// servers, checking their health periodically and spreading the requests
+// among the healthy ones.
+package bblfshpool
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bblfsh "github.com/bblfsh/go-client"
+	errors "gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+var (
+	// ErrNoEndpoints is returned by New when no address is given
+	ErrNoEndpoints = errors.NewKind("at least one bblfsh server address is required")
+
+	// ErrUnavailable is returned when none of the bblfsh servers can be
+	// reached
+	ErrUnavailable = errors.NewKind("no bblfsh server is available: %s")
+)
+
+const (
+	// DefaultCheckInterval is the default time between health checks
+	DefaultCheckInterval = 30 * time.Second
+	// DefaultTimeout is the default timeout to connect to a bblfsh server and
+	// to run a health check
+	DefaultTimeout = 5 * time.Second
+)
+
+// Options configures a Pool
+type Options struct {
+	// CheckInterval is the time between health checks
+	CheckInterval time.Duration
+	// Timeout is used to connect to a server and to run each health check
+	Timeout time.Duration
+}
+
+// EndpointStatus describes the state of a bblfsh server in the Pool
+type EndpointStatus struct {
+	Address   string    `json:"address"`
+	Healthy   bool      `json:"healthy"`
+	LastCheck time.Time `json:"lastCheck"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Pool keeps one gRPC connection to each bblfsh server. gRPC multiplexes the
+// concurrent requests over each connection, so a single one is enough.
+// The connections are checked periodically once Start is called; the ones
+// that fail are discarded and dialed again in the following checks. A
+// discarded connection is closed once the last request using it is done
+type Pool struct {
+	opts      Options
+	endpoints []*endpoint
+	next      uint32
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	// dial, check and close are replaced in the tests
+	dial  func(ctx context.Context, addr string) (*bblfsh.Client, error)
+	check func(ctx context.Context, cli *bblfsh.Client) error
+	close func(cli *bblfsh.Client) error
+}
+
+type endpoint struct {
+	addr string
+
+	// connecting serializes the connections and health checks
+	connecting sync.Mutex
+
+	mu        sync.Mutex
+	conn      *conn
+	healthy   bool
+	lastCheck time.Time
+	lastErr   error
+}
+
+// conn is a connection shared by the requests. Its fields are guarded by the
+// mutex of the endpoint
+type conn struct {
+	cli       *bblfsh.Client
+	refs      int
+	discarded bool
+}
+
+// New returns a Pool for the given bblfsh server addresses. Empty addresses
+// are ignored. No connection is made until Start or Client are called
+func New(addrs []string, opts Options) (*Pool, error) {
+	if opts.CheckInterval <= 0 {
+		opts.CheckInterval = DefaultCheckInterval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	p := &Pool{
+		opts:  opts,
+		dial:  bblfsh.NewClientContext,
+		check: checkVersion,
+		close: func(cli *bblfsh.Client) error { return cli.Close() },
+	}
+
+	for _, addr := range addrs {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+
+		p.endpoints = append(p.endpoints, &endpoint{addr: addr})
+	}
+
+	if len(p.endpoints) == 0 {
+		return nil, ErrNoEndpoints.New()
+	}
+
+	return p, nil
+}
+
+func checkVersion(ctx context.Context, cli *bblfsh.Client) error {
+	_, err := cli.NewVersionRequest().Context(ctx).Do()
+	return err
+}
+
+// Start runs a first health check of all the servers, and keeps checking
+// them in the background until Stop is called
+func (p *Pool) Start() {
+	p.stop = make(chan struct{})
+	p.checkAll()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(p.opts.CheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.checkAll()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the health checks and closes all the connections, the ones in use
+// once their requests are done
+func (p *Pool) Stop() {
+	if p.stop != nil {
+		close(p.stop)
+		p.wg.Wait()
+		p.stop = nil
+	}
+
+	for _, e := range p.endpoints {
+		e.connecting.Lock()
+		e.mu.Lock()
+		p.disconnect(e)
+		e.mu.Unlock()
+		e.connecting.Unlock()
+	}
+}
+
+// Client returns a client for one of the healthy servers, going through them
+// in round-robin. If none is healthy, it tries to connect to each server
+// before returning ErrUnavailable. The returned done function must be called
+// once the client is not used anymore
+func (p *Pool) Client(ctx context.Context) (*bblfsh.Client, func(), error) {
+	start := int(atomic.AddUint32(&p.next, 1) - 1)
+	n := len(p.endpoints)
+
+	for i := 0; i < n; i++ {
+		if cli, done, ok := p.acquire(p.endpoints[(start+i)%n]); ok {
+			return cli, done, nil
+		}
+	}
+
+	var errs []string
+	for i := 0; i < n; i++ {
+		e := p.endpoints[(start+i)%n]
+
+		err := p.connect(ctx, e)
+		if err == nil {
+			// the endpoint may fail a concurrent check before it is acquired
+			if cli, done, ok := p.acquire(e); ok {
+				return cli, done, nil
+			}
+
+			continue
+		}
+
+		errs = append(errs, e.addr+": "+err.Error())
+	}
+
+	return nil, nil, ErrUnavailable.New(strings.Join(errs, "; "))
+}
+
+// acquire returns the client of the endpoint if it is healthy, and a function
+// to release it
+func (p *Pool) acquire(e *endpoint) (*bblfsh.Client, func(), bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.healthy || e.conn == nil {
+		return nil, nil, false
+	}
+
+	c := e.conn
+	c.refs++
+
+	var once sync.Once
+	return c.cli, func() {
+		once.Do(func() {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+
+			c.refs--
+			p.closeIfUnused(e, c)
+		})
+	}, true
+}
+
+// Addresses returns the addresses of the servers, in the order they were
+// given
+func (p *Pool) Addresses() []string {
+	addrs := make([]string, len(p.endpoints))
+	for i, e := range p.endpoints {
+		addrs[i] = e.addr
+	}
+
+	return addrs
+}
+
+// Status returns the state of each server, in the order they were given
+func (p *Pool) Status() []EndpointStatus {
+	status := make([]EndpointStatus, len(p.endpoints))
+	for i, e := range p.endpoints {
+		e.mu.Lock()
+		status[i] = EndpointStatus{
+			Address:   e.addr,
+			Healthy:   e.healthy,
+			LastCheck: e.lastCheck,
+		}
+		if e.lastErr != nil {
+			status[i].Error = e.lastErr.Error()
+		}
+		e.mu.Unlock()
+	}
+
+	return status
+}
+
+// checkAll checks the health of all the servers concurrently, and logs the
+// ones that changed their state
+func (p *Pool) checkAll() {
+	wasHealthy := make([]bool, len(p.endpoints))
+	errs := make([]error, len(p.endpoints))
+
+	var wg sync.WaitGroup
+	for i, e := range p.endpoints {
+		e.mu.Lock()
+		wasHealthy[i] = e.healthy
+		e.mu.Unlock()
+
+		wg.Add(1)
+		go func(i int, e *endpoint) {
+			defer wg.Done()
+			errs[i] = p.connect(context.Background(), e)
+		}(i, e)
+	}
+
+	wg.Wait()
+
+	for i, e := range p.endpoints {
+		logger := log.With(log.Fields{"bblfsh": e.addr})
+		switch {
+		case errs[i] != nil && wasHealthy[i]:
+			logger.Errorf(errs[i], "bblfsh server is not healthy")
+		case errs[i] == nil && !wasHealthy[i]:
+			logger.Infof("bblfsh server is healthy")
+		}
+	}
+}
+
+// connect dials the server if there is no connection yet, and checks it.
+// If the check fails the connection is discarded, to dial it again the next
+// time
+func (p *Pool) connect(ctx context.Context, e *endpoint) error {
+	e.connecting.Lock()
+	defer e.connecting.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, p.opts.Timeout)
+	defer cancel()
+
+	e.mu.Lock()
+	c := e.conn
+	e.mu.Unlock()
+
+	var err error
+	if c == nil {
+		var cli *bblfsh.Client
+		cli, err = p.dial(ctx, e.addr)
+		if err == nil {
+			c = &conn{cli: cli}
+		}
+	}
+	if err == nil {
+		err = p.check(ctx, c.cli)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.lastCheck = time.Now()
+	e.lastErr = err
+
+	if err != nil {
+		if c != nil {
+			e.conn = c
+		}
+		p.disconnect(e)
+		return err
+	}
+
+	e.conn = c
+	e.healthy = true
+	return nil
+}
+
+// disconnect discards the connection of the endpoint, closing it if no
+// request is using it. It must be called with the endpoint locked
+func (p *Pool) disconnect(e *endpoint) {
+	if e.conn != nil {
+		e.conn.discarded = true
+		p.closeIfUnused(e, e.conn)
+	}
+
+	e.conn = nil
+	e.healthy = false
+}
+
+// closeIfUnused closes a discarded connection once no request is using it.
+// It must be called with the endpoint locked
+func (p *Pool) closeIfUnused(e *endpoint, c *conn) {
+	if !c.discarded || c.refs > 0 {
+		return
+	}
+
+	if err := p.close(c.cli); err != nil {
+		log.With(log.Fields{"bblfsh": e.addr}).Warningf("error closing the connection: %s", err)
+	}
+}
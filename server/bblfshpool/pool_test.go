@@ -0,0 +1,225 @@
+package bblfshpool
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	bblfsh "github.com/bblfsh/go-client"
+	"github.com/stretchr/testify/suite"
+)
+
+type PoolSuite struct {
+	suite.Suite
+	pool *Pool
+
+	mu      sync.Mutex
+	down    map[string]bool
+	clients map[*bblfsh.Client]string
+	dials   map[string]int
+	closed  map[string]int
+}
+
+func TestPoolSuite(t *testing.T) {
+	suite.Run(t, new(PoolSuite))
+}
+
+func (s *PoolSuite) SetupTest() {
+	var err error
+	s.pool, err = New([]string{"a:9432", " ", "b:9432"}, Options{})
+	s.Require().NoError(err)
+
+	s.down = make(map[string]bool)
+	s.clients = make(map[*bblfsh.Client]string)
+	s.dials = make(map[string]int)
+	s.closed = make(map[string]int)
+
+	s.pool.dial = s.dial
+	s.pool.check = s.check
+	s.pool.close = s.close
+}
+
+func (s *PoolSuite) dial(ctx context.Context, addr string) (*bblfsh.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dials[addr]++
+	if s.down[addr] {
+		return nil, fmt.Errorf("connection refused")
+	}
+
+	cli := &bblfsh.Client{}
+	s.clients[cli] = addr
+	return cli, nil
+}
+
+func (s *PoolSuite) check(ctx context.Context, cli *bblfsh.Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.down[s.clients[cli]] {
+		return fmt.Errorf("unavailable")
+	}
+
+	return nil
+}
+
+func (s *PoolSuite) close(cli *bblfsh.Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed[s.clients[cli]]++
+	return nil
+}
+
+func (s *PoolSuite) setDown(addr string, down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.down[addr] = down
+}
+
+func (s *PoolSuite) addr(cli *bblfsh.Client) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.clients[cli]
+}
+
+func (s *PoolSuite) count(m map[string]int, addr string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return m[addr]
+}
+
+func (s *PoolSuite) TestNew() {
+	_, err := New(nil, Options{})
+	s.True(ErrNoEndpoints.Is(err))
+
+	_, err = New([]string{"", " "}, Options{})
+	s.True(ErrNoEndpoints.Is(err))
+
+	s.Len(s.pool.Status(), 2)
+}
+
+func (s *PoolSuite) TestRoundRobin() {
+	require := s.Require()
+
+	s.pool.checkAll()
+
+	var addrs []string
+	for i := 0; i < 4; i++ {
+		cli, done, err := s.pool.Client(context.Background())
+		require.NoError(err)
+		done()
+		addrs = append(addrs, s.addr(cli))
+	}
+
+	require.Equal([]string{"a:9432", "b:9432", "a:9432", "b:9432"}, addrs)
+
+	// the connections are reused
+	require.Equal(1, s.count(s.dials, "a:9432"))
+	require.Equal(1, s.count(s.dials, "b:9432"))
+}
+
+func (s *PoolSuite) TestUnhealthy() {
+	require := s.Require()
+
+	s.pool.checkAll()
+	s.setDown("a:9432", true)
+	s.pool.checkAll()
+
+	status := s.pool.Status()
+	require.False(status[0].Healthy)
+	require.Equal("unavailable", status[0].Error)
+	require.True(status[1].Healthy)
+	require.Equal(1, s.count(s.closed, "a:9432"))
+
+	for i := 0; i < 3; i++ {
+		cli, done, err := s.pool.Client(context.Background())
+		require.NoError(err)
+		done()
+		require.Equal("b:9432", s.addr(cli))
+	}
+
+	// reconnects once the server is back
+	s.setDown("a:9432", false)
+	s.pool.checkAll()
+
+	require.True(s.pool.Status()[0].Healthy)
+	require.Equal(2, s.count(s.dials, "a:9432"))
+}
+
+func (s *PoolSuite) TestUnhealthyInUse() {
+	require := s.Require()
+
+	s.pool.checkAll()
+	cli, done, err := s.pool.Client(context.Background())
+	require.NoError(err)
+	addr := s.addr(cli)
+
+	// the connection is not closed while a request uses it
+	s.setDown(addr, true)
+	s.pool.checkAll()
+	require.Equal(0, s.count(s.closed, addr))
+
+	done()
+	require.Equal(1, s.count(s.closed, addr))
+
+	// calling done again does not close it twice
+	done()
+	require.Equal(1, s.count(s.closed, addr))
+}
+
+func (s *PoolSuite) TestStopInUse() {
+	require := s.Require()
+
+	s.pool.Start()
+	cli, done, err := s.pool.Client(context.Background())
+	require.NoError(err)
+	addr := s.addr(cli)
+
+	s.pool.Stop()
+	require.Equal(0, s.count(s.closed, addr))
+
+	done()
+	require.Equal(1, s.count(s.closed, addr))
+}
+
+func (s *PoolSuite) TestConnectOnDemand() {
+	require := s.Require()
+
+	// without any check, Client connects to the first available server
+	s.setDown("a:9432", true)
+
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		cli, done, err := s.pool.Client(context.Background())
+		require.NoError(err)
+		done()
+		addrs = append(addrs, s.addr(cli))
+	}
+
+	require.Equal([]string{"b:9432", "b:9432"}, addrs)
+
+	s.setDown("b:9432", true)
+	s.pool.checkAll()
+
+	_, _, err := s.pool.Client(context.Background())
+	require.True(ErrUnavailable.Is(err))
+}
+
+func (s *PoolSuite) TestStartStop() {
+	require := s.Require()
+
+	s.pool.Start()
+	require.True(s.pool.Status()[0].Healthy)
+	require.True(s.pool.Status()[1].Healthy)
+
+	s.pool.Stop()
+	require.False(s.pool.Status()[0].Healthy)
+	require.Equal(1, s.count(s.closed, "a:9432"))
+	require.Equal(1, s.count(s.closed, "b:9432"))
+}
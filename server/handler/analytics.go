@@ -0,0 +1,364 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/src-d/gitbase-web/server/serializer"
+	"github.com/src-d/gitbase-web/server/service"
+)
+
+// maxContributorDirectories is the number of directories returned for each
+// contributor, the ones changed by more commits
+const maxContributorDirectories = 10
+
+// maxDirectoryCommits is the number of commits, the newest ones, compared to
+// their first parent to count the directories of the contributors
+const maxDirectoryCommits = 10000
+
+// rootDirectory is the path where the files of the root directory are
+// counted
+const rootDirectory = "/"
+
+// Contributors returns a function that returns the activity of each commit
+// author: the number of commits, the first and the last one, the commits by
+// week or month, and the top level directories they changed the most. The
+// authors are merged by the mailmap, that can be nil. The repo parameter
+// selects a repository, all of them by default. The commits are aggregated
+// while they are read, and only the newest maxDirectoryCommits are compared
+// to count the directories
+func Contributors(db service.SQLDB, mailmap *service.Mailmap) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		params := r.URL.Query()
+		bucket := params.Get("bucket")
+		if bucket == "" {
+			bucket = service.BucketWeek
+		}
+
+		if bucket != service.BucketWeek && bucket != service.BucketMonth {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf(`Bad Request. Invalid "bucket" %q; it must be %q or %q`,
+					bucket, service.BucketWeek, service.BucketMonth))
+		}
+
+		filter, err := newCommitFilter(params)
+		if err != nil {
+			return nil, err
+		}
+
+		// the authors are merged by the mailmap, so they are not selected by
+		// their identity in the commits
+		filter.author = ""
+
+		repo := params.Get("repo")
+		conds, args := filter.conds()
+		if repo != "" {
+			conds = append([]string{"c.repository_id = ?"}, conds...)
+			args = append([]interface{}{repo}, args...)
+		}
+
+		query := "SELECT " + commitColumns + " FROM commits c"
+		if len(conds) > 0 {
+			query += " WHERE " + strings.Join(conds, " AND ")
+		}
+		query += " ORDER BY c.commit_author_when DESC"
+
+		rows, err := db.QueryContext(r.Context(), query, args...)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		defer rows.Close()
+
+		activity := newContributorsActivity(mailmap, bucket)
+		var dirCommits []rootCommit
+		truncated := false
+
+		// the same commit can be in several repositories
+		seen := make(map[string]bool)
+		for rows.Next() {
+			c, err := scanCommit(rows)
+			if err != nil {
+				return nil, err
+			}
+
+			if seen[c.Hash] {
+				continue
+			}
+			seen[c.Hash] = true
+
+			author := activity.add(c)
+			if len(dirCommits) >= maxDirectoryCommits {
+				truncated = true
+				continue
+			}
+
+			rc := rootCommit{hash: c.Hash, author: author}
+			if len(c.Parents) > 0 {
+				rc.parent = c.Parents[0]
+			}
+			dirCommits = append(dirCommits, rc)
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, dbError(err)
+		}
+
+		err = touchedDirectories(r.Context(), db, repo, dirCommits,
+			func(c rootCommit, dirs []string) {
+				for _, d := range dirs {
+					c.author.dirs[d]++
+				}
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewContributorsResponse(activity.contributors(), truncated), nil
+	}
+}
+
+// rootCommit is a commit compared to its first parent to find the top level
+// directories it changed, with the activity of its author
+type rootCommit struct {
+	hash   string
+	parent string
+	author *authorActivity
+}
+
+// rootEntry is an entry of the root tree of a commit
+type rootEntry struct {
+	hash string
+	dir  bool
+}
+
+// touchedDirectories calls fn with the top level directories changed by each
+// commit, compared to its first parent. Only the root trees are compared, so
+// a directory is changed if its tree hash is different. The changed files of
+// the root directory are returned as rootDirectory. The commits are compared
+// in batches, so only the root trees of a batch are kept in memory
+func touchedDirectories(
+	ctx context.Context,
+	db service.SQLDB,
+	repo string,
+	commits []rootCommit,
+	fn func(c rootCommit, dirs []string),
+) error {
+	// each commit needs its tree and the one of its parent
+	size := maxInValues / 2
+	for len(commits) > 0 {
+		batch := commits
+		if len(batch) > size {
+			batch = batch[:size]
+		}
+		commits = commits[len(batch):]
+
+		trees, err := rootTrees(ctx, db, repo, batch)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range batch {
+			parent := trees[c.parent]
+
+			touched := make(map[string]bool)
+			for name, e := range trees[c.hash] {
+				if p, ok := parent[name]; !ok || p.hash != e.hash {
+					touched[entryDirectory(name, e)] = true
+				}
+			}
+
+			for name, p := range parent {
+				if _, ok := trees[c.hash][name]; !ok {
+					touched[entryDirectory(name, p)] = true
+				}
+			}
+
+			dirs := make([]string, 0, len(touched))
+			for d := range touched {
+				dirs = append(dirs, d)
+			}
+
+			fn(c, dirs)
+		}
+	}
+
+	return nil
+}
+
+// rootTrees returns the entries of the root trees of the commits and their
+// parents, by commit hash
+func rootTrees(
+	ctx context.Context,
+	db service.SQLDB,
+	repo string,
+	commits []rootCommit,
+) (map[string]map[string]rootEntry, error) {
+	var hashes []string
+	seen := make(map[string]bool)
+	for _, c := range commits {
+		for _, h := range []string{c.hash, c.parent} {
+			if h != "" && !seen[h] {
+				seen[h] = true
+				hashes = append(hashes, h)
+			}
+		}
+	}
+
+	trees := make(map[string]map[string]rootEntry)
+	err := inBatches(hashes, func(batch []string) error {
+		marks, args := inArgs(batch)
+		query := "SELECT c.commit_hash, t.tree_entry_name, t.blob_hash, t.tree_entry_mode" +
+			" FROM commits c INNER JOIN tree_entries t" +
+			" ON c.repository_id = t.repository_id AND c.tree_hash = t.tree_hash" +
+			" WHERE c.commit_hash IN (" + marks + ")"
+		if repo != "" {
+			query += " AND c.repository_id = ?"
+			args = append(args, repo)
+		}
+
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return dbError(err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var commit, name, hash, mode string
+			if err := rows.Scan(&commit, &name, &hash, &mode); err != nil {
+				return err
+			}
+
+			if trees[commit] == nil {
+				trees[commit] = make(map[string]rootEntry)
+			}
+
+			trees[commit][name] = rootEntry{hash: hash, dir: service.EntryType(mode) == service.EntryDir}
+		}
+
+		if err := rows.Err(); err != nil {
+			return dbError(err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return trees, nil
+}
+
+// entryDirectory returns the directory counted for a changed root entry
+func entryDirectory(name string, e rootEntry) string {
+	if e.dir {
+		return name
+	}
+
+	return rootDirectory
+}
+
+// authorActivity is the activity of an author, with the commits by time
+// bucket and by directory
+type authorActivity struct {
+	service.Contributor
+	buckets map[time.Time]int
+	dirs    map[string]int
+}
+
+// contributorsActivity aggregates the activity of the authors of the commits.
+// The authors with the same canonical email are merged, and they are named
+// after their last commit
+type contributorsActivity struct {
+	mailmap *service.Mailmap
+	bucket  string
+	authors []*authorActivity
+	byID    map[string]*authorActivity
+}
+
+func newContributorsActivity(mailmap *service.Mailmap, bucket string) *contributorsActivity {
+	return &contributorsActivity{
+		mailmap: mailmap,
+		bucket:  bucket,
+		byID:    make(map[string]*authorActivity),
+	}
+}
+
+// add counts a commit, and returns the activity of its author
+func (ca *contributorsActivity) add(c *service.Commit) *authorActivity {
+	name, email := ca.mailmap.Lookup(c.Author.Name, c.Author.Email)
+	id := strings.ToLower(email)
+	if id == "" {
+		id = name
+	}
+
+	a, ok := ca.byID[id]
+	if !ok {
+		a = &authorActivity{buckets: make(map[time.Time]int), dirs: make(map[string]int)}
+		ca.byID[id] = a
+		ca.authors = append(ca.authors, a)
+	}
+
+	when := c.Author.When
+	if a.Commits == 0 || when.Before(a.First) {
+		a.First = when
+	}
+
+	if a.Commits == 0 || !when.Before(a.Last) {
+		a.Last = when
+		a.Name, a.Email = name, email
+	}
+
+	a.Commits++
+	a.buckets[service.BucketStart(when, ca.bucket)]++
+	return a
+}
+
+// contributors returns the activity of each author, from the one with more
+// commits
+func (ca *contributorsActivity) contributors() []service.Contributor {
+	result := make([]service.Contributor, len(ca.authors))
+	for i, a := range ca.authors {
+		a.Buckets = make([]service.Bucket, 0, len(a.buckets))
+		for start, n := range a.buckets {
+			a.Buckets = append(a.Buckets, service.Bucket{Start: start, Commits: n})
+		}
+
+		sort.Slice(a.Buckets, func(i, j int) bool {
+			return a.Buckets[i].Start.Before(a.Buckets[j].Start)
+		})
+
+		a.Directories = make([]service.DirectoryUse, 0, len(a.dirs))
+		for path, n := range a.dirs {
+			a.Directories = append(a.Directories, service.DirectoryUse{Path: path, Commits: n})
+		}
+
+		sort.Slice(a.Directories, func(i, j int) bool {
+			di, dj := a.Directories[i], a.Directories[j]
+			if di.Commits != dj.Commits {
+				return di.Commits > dj.Commits
+			}
+
+			return di.Path < dj.Path
+		})
+
+		if len(a.Directories) > maxContributorDirectories {
+			a.Directories = a.Directories[:maxContributorDirectories]
+		}
+
+		result[i] = a.Contributor
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Commits != result[j].Commits {
+			return result[i].Commits > result[j].Commits
+		}
+
+		return result[i].Email < result[j].Email
+	})
+
+	return result
+}
@@ -0,0 +1,217 @@
+package handler_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/src-d/gitbase-web/server/handler"
+	"github.com/src-d/gitbase-web/server/service"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/suite"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+const rootEntriesQuery = `SELECT c.commit_hash, t.tree_entry_name, t.blob_hash, t.tree_entry_mode` +
+	` FROM commits c INNER JOIN tree_entries t` +
+	` ON c.repository_id = t.repository_id AND c.tree_hash = t.tree_hash`
+
+type AnalyticsSuite struct {
+	handler.RouterSuite
+}
+
+func TestAnalyticsSuite(t *testing.T) {
+	mailmap, err := service.ParseMailmap(strings.NewReader(
+		"# the work and home emails of Alice\n" +
+			"Alice Smith <alice@example.com>\n" +
+			"Alice Smith <alice@example.com> <asmith@example.com>\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suite.Run(t, &AnalyticsSuite{handler.RouterSuite{
+		Routes: func(r chi.Router, db service.SQLDB) {
+			r.Get("/analytics/contributors", handler.APIHandlerFunc(handler.Contributors(db, mailmap)))
+		},
+	}})
+}
+
+func (suite *AnalyticsSuite) get(url string) ([]service.Contributor, *httptest.ResponseRecorder) {
+	var contributors []service.Contributor
+	res := suite.Get(url, &contributors, nil)
+
+	return contributors, res
+}
+
+func (suite *AnalyticsSuite) TestContributors() {
+	day := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT `+commitColumns+` FROM commits c`+
+		` WHERE c.repository_id = ? AND c.commit_author_when >= ?`+
+		` ORDER BY c.commit_author_when DESC`)).
+		WithArgs("repo", day).
+		WillReturnRows(sqlmock.NewRows(commitRowColumns).
+			AddRow(commitRow("c3", "asmith", day.AddDate(0, 1, 1), `["c2"]`)...).
+			AddRow(commitRow("c2", "alice", day.AddDate(0, 0, 9), `["c1"]`)...).
+			AddRow(commitRow("c1", "bob", day.AddDate(0, 0, 2), `["c0"]`)...))
+
+	// c1 changes server, c2 adds docs and changes README.md, c3 changes server
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(rootEntriesQuery+
+		` WHERE c.commit_hash IN (?, ?, ?, ?) AND c.repository_id = ?`)).
+		WithArgs("c3", "c2", "c1", "c0", "repo").
+		WillReturnRows(sqlmock.NewRows([]string{"commit_hash", "tree_entry_name", "blob_hash", "tree_entry_mode"}).
+			AddRow("c0", "README.md", "readme0", "100644").
+			AddRow("c0", "server", "server0", "40000").
+			AddRow("c1", "README.md", "readme0", "100644").
+			AddRow("c1", "server", "server1", "40000").
+			AddRow("c2", "README.md", "readme1", "100644").
+			AddRow("c2", "docs", "docs1", "40000").
+			AddRow("c2", "server", "server1", "40000").
+			AddRow("c3", "README.md", "readme1", "100644").
+			AddRow("c3", "docs", "docs1", "40000").
+			AddRow("c3", "server", "server2", "40000"))
+
+	contributors, res := suite.get("/analytics/contributors?repo=repo&since=2018-10-01&bucket=month")
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	suite.Equal([]service.Contributor{
+		{
+			Name:    "Alice Smith",
+			Email:   "alice@example.com",
+			Commits: 2,
+			First:   day.AddDate(0, 0, 9),
+			Last:    day.AddDate(0, 1, 1),
+			Buckets: []service.Bucket{
+				{Start: day, Commits: 1},
+				{Start: day.AddDate(0, 1, 0), Commits: 1},
+			},
+			Directories: []service.DirectoryUse{
+				{Path: "/", Commits: 1},
+				{Path: "docs", Commits: 1},
+				{Path: "server", Commits: 1},
+			},
+		},
+		{
+			Name:        "bob",
+			Email:       "bob@example.com",
+			Commits:     1,
+			First:       day.AddDate(0, 0, 2),
+			Last:        day.AddDate(0, 0, 2),
+			Buckets:     []service.Bucket{{Start: day, Commits: 1}},
+			Directories: []service.DirectoryUse{{Path: "server", Commits: 1}},
+		},
+	}, contributors)
+}
+
+func (suite *AnalyticsSuite) TestContributorsByWeek() {
+	// a Sunday, counted in the week of the Monday before
+	sunday := time.Date(2018, 10, 7, 12, 0, 0, 0, time.UTC)
+
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT ` + commitColumns + ` FROM commits c` +
+		` ORDER BY c.commit_author_when DESC`)).
+		WillReturnRows(sqlmock.NewRows(commitRowColumns).
+			AddRow(commitRow("c1", "bob", sunday, `[]`)...).
+			AddRow(commitRow("c1", "bob", sunday, `[]`)...))
+
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(rootEntriesQuery + ` WHERE c.commit_hash IN (?)`)).
+		WithArgs("c1").
+		WillReturnRows(sqlmock.NewRows([]string{"commit_hash", "tree_entry_name", "blob_hash", "tree_entry_mode"}).
+			AddRow("c1", "README.md", "readme", "100644"))
+
+	contributors, res := suite.get("/analytics/contributors")
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	suite.Require().Len(contributors, 1)
+	suite.Equal(1, contributors[0].Commits)
+	suite.Equal([]service.Bucket{
+		{Start: time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC), Commits: 1},
+	}, contributors[0].Buckets)
+	suite.Equal([]service.DirectoryUse{{Path: "/", Commits: 1}}, contributors[0].Directories)
+}
+
+func (suite *AnalyticsSuite) TestDirectoriesBatches() {
+	day := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	// each batch has the trees of 50 commits and their parents
+	rows := sqlmock.NewRows(commitRowColumns)
+	treeRows := []*sqlmock.Rows{
+		sqlmock.NewRows([]string{"commit_hash", "tree_entry_name", "blob_hash", "tree_entry_mode"}),
+		sqlmock.NewRows([]string{"commit_hash", "tree_entry_name", "blob_hash", "tree_entry_mode"}),
+	}
+	for i := 0; i < 60; i++ {
+		hash := fmt.Sprintf("c%d", i)
+		rows.AddRow(commitRow(hash, "bob", day, `[]`)...)
+		treeRows[i/50].AddRow(hash, "server", hash, "40000")
+	}
+
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT ` + commitColumns + ` FROM commits c`)).
+		WillReturnRows(rows)
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(rootEntriesQuery + ` WHERE c.commit_hash IN (?, ?`)).
+		WillReturnRows(treeRows[0])
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(rootEntriesQuery + ` WHERE c.commit_hash IN (?, ?`)).
+		WillReturnRows(treeRows[1])
+
+	var contributors []service.Contributor
+	var meta struct {
+		DirectoriesTruncated bool `json:"directoriesTruncated"`
+	}
+	res := suite.Get("/analytics/contributors", &contributors, &meta)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	suite.Require().Len(contributors, 1)
+	suite.Equal(60, contributors[0].Commits)
+	suite.Equal([]service.DirectoryUse{{Path: "server", Commits: 60}}, contributors[0].Directories)
+	suite.False(meta.DirectoriesTruncated)
+}
+
+func (suite *AnalyticsSuite) TestBadRequest() {
+	urls := []string{
+		"/analytics/contributors?bucket=day",
+		"/analytics/contributors?since=last-week",
+	}
+
+	for _, url := range urls {
+		_, res := suite.get(url)
+		suite.Equal(http.StatusBadRequest, res.Code, url)
+	}
+}
+
+func TestParseMailmap(t *testing.T) {
+	mailmap, err := service.ParseMailmap(strings.NewReader(
+		"Alice <alice@example.com>\n" +
+			"Bob <bob@example.com> Robert <BOB@old.example.com> # renamed\n" +
+			"<carol@example.com> <carol@old.example.com>\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name, email         string
+		wantName, wantEmail string
+	}{
+		{"alice", "Alice@example.com", "Alice", "Alice@example.com"},
+		{"robert", "bob@old.example.com", "Bob", "bob@example.com"},
+		{"Bobby", "bob@old.example.com", "Bobby", "bob@old.example.com"},
+		{"Carol", "carol@old.example.com", "Carol", "carol@example.com"},
+		{"Dave", "dave@example.com", "Dave", "dave@example.com"},
+	}
+
+	for _, c := range cases {
+		name, email := mailmap.Lookup(c.name, c.email)
+		if name != c.wantName || email != c.wantEmail {
+			t.Errorf("Lookup(%q, %q) = %q, %q; want %q, %q",
+				c.name, c.email, name, email, c.wantName, c.wantEmail)
+		}
+	}
+
+	for _, bad := range []string{"<alice@example.com>", "Alice <alice@example.com", "Alice"} {
+		if _, err := service.ParseMailmap(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected an error parsing %q", bad)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/src-d/gitbase-web/server/bblfshpool"
+	"github.com/src-d/gitbase-web/server/serializer"
+
+	bblfsh "github.com/bblfsh/go-client"
+)
+
+// defaultEndpoint is the name that selects the default bblfsh endpoint
+const defaultEndpoint = "default"
+
+// BblfshEndpoints are the bblfsh servers the requests are allowed to use
+type BblfshEndpoints struct {
+	// Default is used when the request does not select any endpoint
+	Default *bblfshpool.Pool
+	// Named are the endpoints that the requests can select by name
+	Named map[string]*bblfshpool.Pool
+	// AllowServerURL allows the requests to give the address of any bblfsh
+	// server. Otherwise, only the addresses of the default and named
+	// endpoints are accepted
+	AllowServerURL bool
+	// Limits are applied to all the files parsed with any endpoint
+	Limits ParseLimits
+}
+
+// ParseLimits caps the files parsed with bblfsh. A value of 0 means no limit
+type ParseLimits struct {
+	// MaxContentBytes is the maximum size of the content of a file
+	MaxContentBytes int
+	// Timeout is the maximum time to wait for bblfsh to parse a file
+	Timeout time.Duration
+	// MaxBatchFiles is the maximum number of files of a batch request
+	MaxBatchFiles int
+}
+
+// checkContent returns a 413 error if the file content is too large
+func (l ParseLimits) checkContent(content string) error {
+	if l.MaxContentBytes > 0 && len(content) > l.MaxContentBytes {
+		return serializer.NewHTTPError(http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Request Entity Too Large. The file has %d bytes, the maximum is %d",
+				len(content), l.MaxContentBytes))
+	}
+
+	return nil
+}
+
+// maxBodyBytes returns the maximum size of a request body that sends the
+// given number of files with the maximum content size. JSON can escape each
+// byte of the content with up to 6 characters, and the rest of the fields of
+// each file get 1MiB. A number of files of 0 means no limit
+func (l ParseLimits) maxBodyBytes(files int) int64 {
+	if l.MaxContentBytes <= 0 || files <= 0 {
+		return 0
+	}
+
+	return int64(files) * (6*int64(l.MaxContentBytes) + 1<<20)
+}
+
+// checkBatch returns a 413 error if the batch has too many files
+func (l ParseLimits) checkBatch(files int) error {
+	if l.MaxBatchFiles > 0 && files > l.MaxBatchFiles {
+		return serializer.NewHTTPError(http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Request Entity Too Large. The batch has %d files, the maximum is %d",
+				files, l.MaxBatchFiles))
+	}
+
+	return nil
+}
+
+// client returns a bblfsh client for the endpoint selected by name, or by
+// address with serverURL. The returned function must be called once the
+// client is not needed anymore
+func (e *BblfshEndpoints) client(
+	ctx context.Context,
+	name, serverURL string,
+) (*bblfsh.Client, func(), error) {
+	if name != "" && serverURL != "" {
+		return nil, nil, serializer.NewHTTPError(http.StatusBadRequest,
+			`Bad Request. Only one of "endpoint" and "serverUrl" can be used`)
+	}
+
+	pool := e.Default
+	switch {
+	case name != "" && name != defaultEndpoint:
+		var ok bool
+		pool, ok = e.Named[name]
+		if !ok {
+			return nil, nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("Bad Request. Unknown bblfsh endpoint %q; it must be one of %q",
+					name, e.names()))
+		}
+	case serverURL != "":
+		pool = e.byAddress(serverURL)
+		if pool != nil {
+			break
+		}
+
+		if !e.AllowServerURL {
+			return nil, nil, serializer.NewHTTPError(http.StatusBadRequest,
+				`Bad Request. The "serverUrl" is not one of the allowed bblfsh servers; use "endpoint" to select one of them by name`)
+		}
+
+		dialCtx, cancel := context.WithTimeout(ctx, bblfshpool.DefaultTimeout)
+		defer cancel()
+
+		cli, err := bblfsh.NewClientContext(dialCtx, serverURL)
+		if err != nil {
+			return nil, nil, serializer.NewHTTPError(http.StatusServiceUnavailable,
+				fmt.Sprintf("could not connect to bblfsh server %q: %s", serverURL, err))
+		}
+
+		return cli, func() { cli.Close() }, nil
+	}
+
+	return bblfshClient(ctx, pool)
+}
+
+// names returns the names that can be used to select an endpoint
+func (e *BblfshEndpoints) names() []string {
+	names := []string{defaultEndpoint}
+	for name := range e.Named {
+		names = append(names, name)
+	}
+
+	sort.Strings(names[1:])
+	return names
+}
+
+// byAddress returns the pool of the endpoint that has the given address, or
+// nil if there is none
+func (e *BblfshEndpoints) byAddress(addr string) *bblfshpool.Pool {
+	pools := []*bblfshpool.Pool{e.Default}
+	for _, name := range e.names()[1:] {
+		pools = append(pools, e.Named[name])
+	}
+
+	for _, pool := range pools {
+		for _, a := range pool.Addresses() {
+			if a == addr {
+				return pool
+			}
+		}
+	}
+
+	return nil
+}
+
+// bblfshClient returns a client of the pool and the function to release it,
+// or a 503 error if no bblfsh server is available
+func bblfshClient(ctx context.Context, bblfshPool *bblfshpool.Pool) (*bblfsh.Client, func(), error) {
+	cli, done, err := bblfshPool.Client(ctx)
+	if bblfshpool.ErrUnavailable.Is(err) {
+		return nil, nil, serializer.NewHTTPError(http.StatusServiceUnavailable, err.Error())
+	}
+
+	return cli, done, err
+}
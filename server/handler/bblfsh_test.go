@@ -0,0 +1,104 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/src-d/gitbase-web/server/bblfshpool"
+	"github.com/src-d/gitbase-web/server/handler"
+
+	"github.com/pressly/lg"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEndpointBadRequest(t *testing.T) {
+	defaultPool, err := bblfshpool.New([]string{"127.0.0.1:9432"}, bblfshpool.Options{})
+	require.NoError(t, err)
+	otherPool, err := bblfshpool.New([]string{"bblfsh-other:9432"}, bblfshpool.Options{})
+	require.NoError(t, err)
+
+	endpoints := &handler.BblfshEndpoints{
+		Default: defaultPool,
+		Named:   map[string]*bblfshpool.Pool{"other": otherPool},
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	languages := handler.NewLanguagesCache(defaultPool, 0)
+	parse := lg.RequestLogger(logger)(handler.APIHandlerFunc(handler.Parse(nil, endpoints, languages)))
+	batch := lg.RequestLogger(logger)(handler.ParseBatch(endpoints, 1))
+
+	testCases := []struct {
+		handler http.Handler
+		body    string
+		err     string
+	}{
+		{parse, `{ "content": "a", "language": "go", "endpoint": "nope" }`,
+			`Unknown bblfsh endpoint \"nope\"; it must be one of [\"default\" \"other\"]`},
+		{parse, `{ "content": "a", "language": "go", "serverUrl": "10.0.0.1:22" }`,
+			`not one of the allowed bblfsh servers`},
+		{parse, `{ "content": "a", "language": "go", "serverUrl": "bblfsh-other:9432", "endpoint": "other" }`,
+			`Only one of \"endpoint\" and \"serverUrl\" can be used`},
+		{batch, `[{ "content": "a", "language": "go", "endpoint": "nope" }]`,
+			`Unknown bblfsh endpoint \"nope\"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.body, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/parse", strings.NewReader(tc.body))
+			res := httptest.NewRecorder()
+			tc.handler.ServeHTTP(res, req)
+
+			require.Contains(t, res.Body.String(), tc.err)
+		})
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	pool, err := bblfshpool.New([]string{"127.0.0.1:9432"}, bblfshpool.Options{})
+	require.NoError(t, err)
+
+	endpoints := &handler.BblfshEndpoints{
+		Default: pool,
+		Limits:  handler.ParseLimits{MaxContentBytes: 10, MaxBatchFiles: 2},
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	languages := handler.NewLanguagesCache(pool, 0)
+	parse := lg.RequestLogger(logger)(handler.APIHandlerFunc(handler.Parse(nil, endpoints, languages)))
+	batch := lg.RequestLogger(logger)(handler.ParseBatch(endpoints, 1))
+	at := lg.RequestLogger(logger)(handler.APIHandlerFunc(handler.UASTAt(nil, endpoints)))
+	diff := lg.RequestLogger(logger)(handler.APIHandlerFunc(handler.UASTDiff(nil, endpoints)))
+	stats := lg.RequestLogger(logger)(handler.APIHandlerFunc(handler.UASTStats(endpoints)))
+
+	large := strings.Repeat("a", 11)
+	largeBody := `{ "content": "a", "language": "go", "filename": "` + strings.Repeat("a", 3<<20) + `" }`
+	testCases := []struct {
+		name    string
+		handler http.Handler
+		body    string
+	}{
+		{"content", parse, `{ "content": "` + large + `", "language": "go" }`},
+		{"body", parse, `{ "content": "a", "language": "go", "filter": "` +
+			strings.Repeat("a", 2<<20) + `" }`},
+		{"batch", batch, `[{ "content": "` + large + `", "language": "go" }]`},
+		{"at body", at, largeBody},
+		{"diff body", diff, largeBody},
+		{"stats body", stats, largeBody},
+		{"batch files", batch, `[{ "content": "a" }, { "content": "b" }, { "content": "c" }]`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/parse", strings.NewReader(tc.body))
+			res := httptest.NewRecorder()
+			tc.handler.ServeHTTP(res, req)
+
+			require.Contains(t, res.Body.String(), `"status":413`)
+		})
+	}
+}
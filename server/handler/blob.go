@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/src-d/gitbase-web/server/serializer"
+	"github.com/src-d/gitbase-web/server/service"
+)
+
+var hashRegexp = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// blobContent returns the content of the blob with the given hash. If
+// repositoryID is not empty, the blob is only searched in that repository
+func blobContent(ctx context.Context, db service.SQLDB, repositoryID, hash string) (string, error) {
+	if !hashRegexp.MatchString(hash) {
+		return "", serializer.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("Bad Request. Invalid blob hash %q", hash))
+	}
+
+	query := "SELECT blob_content FROM blobs WHERE blob_hash = ?"
+	args := []interface{}{hash}
+	if repositoryID != "" {
+		query += " AND repository_id = ?"
+		args = append(args, repositoryID)
+	}
+	query += " LIMIT 1"
+
+	return queryContent(ctx, db, fmt.Sprintf("Blob %s not found", hash), query, args...)
+}
+
+// fileContent returns the content of the file with the given path in the
+// commit a reference points to
+func fileContent(ctx context.Context, db service.SQLDB, repositoryID, ref, path string) (string, error) {
+	query := "SELECT blob_content FROM refs NATURAL JOIN commit_files NATURAL JOIN files" +
+		" WHERE repository_id = ? AND ref_name = ? AND file_path = ? LIMIT 1"
+
+	return queryContent(ctx, db,
+		fmt.Sprintf("File %s not found in %s of repository %s", path, ref, repositoryID),
+		query, repositoryID, ref, path)
+}
+
+// queryContent runs a query that selects a blob content, and returns a not
+// found error with the given message if there are no rows
+func queryContent(
+	ctx context.Context,
+	db service.SQLDB,
+	notFound, query string,
+	args ...interface{},
+) (string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return "", dbError(err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return "", dbError(err)
+		}
+
+		return "", serializer.NewHTTPError(http.StatusNotFound, notFound)
+	}
+
+	var content string
+	if err := rows.Scan(&content); err != nil {
+		return "", err
+	}
+
+	return content, nil
+}
@@ -0,0 +1,110 @@
+package handler_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/src-d/gitbase-web/server/bblfshpool"
+	"github.com/src-d/gitbase-web/server/handler"
+
+	"github.com/pressly/lg"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+type ParseContentSuite struct {
+	suite.Suite
+	mock    sqlmock.Sqlmock
+	handler http.Handler
+}
+
+func TestParseContentSuite(t *testing.T) {
+	suite.Run(t, new(ParseContentSuite))
+}
+
+func (suite *ParseContentSuite) SetupTest() {
+	db, mock, err := sqlmock.New()
+	suite.Require().NoError(err)
+	suite.mock = mock
+
+	pool, err := bblfshpool.New([]string{"127.0.0.1:9432"}, bblfshpool.Options{})
+	suite.Require().NoError(err)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	languages := handler.NewLanguagesCache(pool, 0)
+	suite.handler = lg.RequestLogger(logger)(handler.APIHandlerFunc(
+		handler.Parse(db, &handler.BblfshEndpoints{Default: pool}, languages)))
+}
+
+func (suite *ParseContentSuite) TestBadRequest() {
+	hash := strings.Repeat("a", 40)
+	testCases := []string{
+		fmt.Sprintf(`{ "content": "a", "blobHash": "%s" }`, hash),
+		fmt.Sprintf(`{ "blobHash": "%s", "repositoryId": "repo", "path": "main.go" }`, hash),
+		`{ "path": "main.go" }`,
+		`{ "blobHash": "../abc" }`,
+	}
+
+	for _, tc := range testCases {
+		suite.T().Run(tc, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/parse", strings.NewReader(tc))
+			res := httptest.NewRecorder()
+			suite.handler.ServeHTTP(res, req)
+
+			suite.Equal(http.StatusBadRequest, res.Code, res.Body.String())
+		})
+	}
+}
+
+func (suite *ParseContentSuite) TestBlobNotFound() {
+	hash := strings.Repeat("a", 40)
+	suite.mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT blob_content FROM blobs WHERE blob_hash = ? AND repository_id = ? LIMIT 1`)).
+		WithArgs(hash, "repo").
+		WillReturnRows(sqlmock.NewRows([]string{"blob_content"}))
+
+	body := fmt.Sprintf(`{ "repositoryId": "repo", "blobHash": "%s" }`, hash)
+	req, _ := http.NewRequest("POST", "/parse", strings.NewReader(body))
+	res := httptest.NewRecorder()
+	suite.handler.ServeHTTP(res, req)
+
+	suite.Equal(http.StatusNotFound, res.Code, res.Body.String())
+	suite.NoError(suite.mock.ExpectationsWereMet())
+}
+
+func (suite *ParseContentSuite) TestFileNotFound() {
+	suite.mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT blob_content FROM refs NATURAL JOIN commit_files NATURAL JOIN files`+
+			` WHERE repository_id = ? AND ref_name = ? AND file_path = ? LIMIT 1`)).
+		WithArgs("repo", "HEAD", "it's.go").
+		WillReturnRows(sqlmock.NewRows([]string{"blob_content"}))
+
+	body := `{ "repositoryId": "repo", "path": "it's.go" }`
+	req, _ := http.NewRequest("POST", "/parse", strings.NewReader(body))
+	res := httptest.NewRecorder()
+	suite.handler.ServeHTTP(res, req)
+
+	suite.Equal(http.StatusNotFound, res.Code, res.Body.String())
+	suite.NoError(suite.mock.ExpectationsWereMet())
+}
+
+func (suite *ParseContentSuite) TestUnknownLanguage() {
+	hash := strings.Repeat("a", 40)
+	suite.mock.ExpectQuery(`SELECT blob_content FROM blobs`).
+		WillReturnRows(sqlmock.NewRows([]string{"blob_content"}).AddRow("some text"))
+
+	body := fmt.Sprintf(`{ "blobHash": "%s" }`, hash)
+	req, _ := http.NewRequest("POST", "/parse", strings.NewReader(body))
+	res := httptest.NewRecorder()
+	suite.handler.ServeHTTP(res, req)
+
+	suite.Equal(http.StatusBadRequest, res.Code, res.Body.String())
+	suite.Contains(res.Body.String(), "could not be detected")
+	suite.NoError(suite.mock.ExpectationsWereMet())
+}
@@ -0,0 +1,483 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/src-d/gitbase-web/server/serializer"
+	"github.com/src-d/gitbase-web/server/service"
+
+	"github.com/go-chi/chi"
+	"github.com/go-sql-driver/mysql"
+)
+
+// commitColumns are the columns of the commits table, aliased as c, scanned
+// by scanCommit
+const commitColumns = "c.commit_hash, c.commit_author_name, c.commit_author_email," +
+	" c.commit_author_when, c.committer_name, c.committer_email, c.committer_when," +
+	" c.commit_message, c.commit_parents"
+
+// logFrom joins the commits of a reference with their data
+const logFrom = " FROM ref_commits r INNER JOIN commits c" +
+	" ON r.repository_id = c.repository_id AND r.commit_hash = c.commit_hash"
+
+const (
+	// maxFileHistory is the maximum number of commits of a reference with a
+	// file that are compared to list the ones that changed it
+	maxFileHistory = 10000
+
+	// maxLineCountFiles and maxLineCountBytes cap the blobs loaded to count
+	// the lines of the changed files. The lines of the rest are unknown
+	maxLineCountFiles = 1000
+	maxLineCountBytes = 64 << 20
+)
+
+// commitFilter selects the commits of a log by author and date, read from
+// the query parameters
+type commitFilter struct {
+	author string
+	since  time.Time
+	until  time.Time
+}
+
+// newCommitFilter returns the filter requested with the query parameters.
+// The dates can be given as RFC 3339 timestamps or as YYYY-MM-DD days
+func newCommitFilter(params url.Values) (commitFilter, error) {
+	f := commitFilter{author: params.Get("author")}
+
+	dates := []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"since", &f.since},
+		{"until", &f.until},
+	}
+
+	for _, d := range dates {
+		v := params.Get(d.name)
+		if v == "" {
+			continue
+		}
+
+		t, err := parseDate(v)
+		if err != nil {
+			return f, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf(`Bad Request. Invalid %q %q; it must be a date like 2006-01-02 or 2006-01-02T15:04:05Z`,
+					d.name, v))
+		}
+
+		*d.dst = t
+	}
+
+	return f, nil
+}
+
+// parseDate parses an RFC 3339 timestamp or a YYYY-MM-DD day, in UTC
+func parseDate(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+
+	return time.Parse("2006-01-02", v)
+}
+
+// conds returns the SQL conditions of the filter, and their arguments. The
+// author matches the name or the email
+func (f commitFilter) conds() ([]string, []interface{}) {
+	var conds []string
+	var args []interface{}
+
+	if f.author != "" {
+		conds = append(conds, "(c.commit_author_name = ? OR c.commit_author_email = ?)")
+		args = append(args, f.author, f.author)
+	}
+
+	if !f.since.IsZero() {
+		conds = append(conds, "c.commit_author_when >= ?")
+		args = append(args, f.since)
+	}
+
+	if !f.until.IsZero() {
+		conds = append(conds, "c.commit_author_when < ?")
+		args = append(args, f.until)
+	}
+
+	return conds, args
+}
+
+// match returns whether the commit is selected by the filter, like conds
+func (f commitFilter) match(c service.Commit) bool {
+	if f.author != "" && c.Author.Name != f.author && c.Author.Email != f.author {
+		return false
+	}
+
+	if !f.since.IsZero() && c.Author.When.Before(f.since) {
+		return false
+	}
+
+	if !f.until.IsZero() && !c.Author.When.Before(f.until) {
+		return false
+	}
+
+	return true
+}
+
+// ListCommits returns a function that lists the commits of a reference of a
+// repository, from the newest. The ref parameter is HEAD by default. With a
+// path parameter, only the commits that added or modified that file are
+// listed
+func ListCommits(db service.SQLDB) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		repo, err := repositoryID(r)
+		if err != nil {
+			return nil, err
+		}
+
+		params := r.URL.Query()
+		p, err := newPage(params)
+		if err != nil {
+			return nil, err
+		}
+
+		filter, err := newCommitFilter(params)
+		if err != nil {
+			return nil, err
+		}
+
+		ref := refParam(params)
+		filePath := cleanPath(params.Get("path"))
+		if filePath != "" {
+			commits, err := fileCommits(r.Context(), db, repo, ref, filePath, filter)
+			if err != nil {
+				return nil, err
+			}
+
+			start, end, next := searchPage(len(commits), p.offset, p.limit)
+
+			return serializer.NewCommitsResponse(commits[start:end], p.offset, next), nil
+		}
+
+		conds, args := filter.conds()
+		conds = append([]string{"r.repository_id = ?", "r.ref_name = ?"}, conds...)
+		args = append([]interface{}{repo, ref}, args...)
+
+		rows, err := db.QueryContext(r.Context(),
+			"SELECT "+commitColumns+logFrom+
+				" WHERE "+strings.Join(conds, " AND ")+
+				" ORDER BY r.history_index"+p.sql(), args...)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		defer rows.Close()
+
+		commits := []service.Commit{}
+		for rows.Next() {
+			c, err := scanCommit(rows)
+			if err != nil {
+				return nil, err
+			}
+
+			commits = append(commits, *c)
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, dbError(err)
+		}
+
+		n, next := p.next(len(commits))
+
+		return serializer.NewCommitsResponse(commits[:n], p.offset, next), nil
+	}
+}
+
+// fileCommits returns the commits of the reference that added or modified
+// the file, selected by the filter. A commit changed the file if its blob is
+// different from the one of each parent. The filter is applied after that,
+// since the parents may not match it. Only the newest maxFileHistory commits
+// with the file are compared
+func fileCommits(
+	ctx context.Context,
+	db service.SQLDB,
+	repo, ref, filePath string,
+	filter commitFilter,
+) ([]service.Commit, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT "+commitColumns+", f.blob_hash"+logFrom+
+			" INNER JOIN commit_files f"+
+			" ON c.repository_id = f.repository_id AND c.commit_hash = f.commit_hash"+
+			" WHERE r.repository_id = ? AND r.ref_name = ? AND f.file_path = ?"+
+			" ORDER BY r.history_index LIMIT ?", repo, ref, filePath, maxFileHistory)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	var all []service.Commit
+	blobs := make(map[string]string)
+	for rows.Next() {
+		var blob string
+		c, err := scanCommit(rows, &blob)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, *c)
+		blobs[c.Hash] = blob
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, dbError(err)
+	}
+
+	commits := []service.Commit{}
+	for _, c := range all {
+		changed := true
+		for _, parent := range c.Parents {
+			if blob, ok := blobs[parent]; ok && blob == blobs[c.Hash] {
+				changed = false
+				break
+			}
+		}
+
+		if changed && filter.match(c) {
+			commits = append(commits, c)
+		}
+	}
+
+	return commits, nil
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanCommit scans the commitColumns, followed by the extra columns
+func scanCommit(row scanner, extra ...interface{}) (*service.Commit, error) {
+	var c service.Commit
+	var authorWhen, committerWhen mysql.NullTime
+	var parents []byte
+
+	dest := append([]interface{}{
+		&c.Hash, &c.Author.Name, &c.Author.Email, &authorWhen,
+		&c.Committer.Name, &c.Committer.Email, &committerWhen,
+		&c.Message, &parents,
+	}, extra...)
+
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	c.Author.When = authorWhen.Time
+	c.Committer.When = committerWhen.Time
+
+	c.Parents = []string{}
+	if len(parents) > 0 {
+		if err := json.Unmarshal(parents, &c.Parents); err != nil {
+			return nil, err
+		}
+	}
+
+	return &c, nil
+}
+
+// GetCommit returns a function that returns a commit of a repository, with
+// the files it changed or renamed compared to its first parent, and their
+// number of added and removed lines, as long as they are within the
+// maxLineCountFiles and maxLineCountBytes caps
+func GetCommit(db service.SQLDB) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		repo, err := repositoryID(r)
+		if err != nil {
+			return nil, err
+		}
+
+		hash := chi.URLParam(r, "hash")
+		c, err := scanCommit(db.QueryRowContext(r.Context(),
+			"SELECT "+commitColumns+" FROM commits c"+
+				" WHERE c.repository_id = ? AND c.commit_hash = ?", repo, hash))
+		if err == sql.ErrNoRows {
+			return nil, serializer.NewHTTPError(http.StatusNotFound,
+				fmt.Sprintf("Commit %q not found in the repository %q", hash, repo))
+		}
+		if err != nil {
+			return nil, dbError(err)
+		}
+
+		files, err := commitFiles(r.Context(), db, repo, c.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		parentFiles := map[string]service.TreeFile{}
+		if len(c.Parents) > 0 {
+			parentFiles, err = commitFiles(r.Context(), db, repo, c.Parents[0])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		changes := service.DetectRenames(service.ChangedFiles(parentFiles, files))
+
+		contents, err := changeContents(r.Context(), db, repo, changes)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, ch := range changes {
+			if !ch.LinesUnknown {
+				service.CountLines(&changes[i], contents[ch.OldHash], contents[ch.NewHash])
+			}
+		}
+
+		return serializer.NewCommitResponse(service.CommitDetail{Commit: *c, Files: changes}), nil
+	}
+}
+
+// commitFiles returns the blob hash and the mode of each file of the commit
+// tree, by path
+func commitFiles(ctx context.Context, db service.SQLDB, repo, hash string) (map[string]service.TreeFile, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT file_path, blob_hash, tree_entry_mode FROM commit_files NATURAL JOIN files"+
+			" WHERE repository_id = ? AND commit_hash = ?", repo, hash)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	files := make(map[string]service.TreeFile)
+	for rows.Next() {
+		var path string
+		var f service.TreeFile
+		if err := rows.Scan(&path, &f.Hash, &f.Mode); err != nil {
+			return nil, err
+		}
+
+		files[path] = f
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, dbError(err)
+	}
+
+	return files, nil
+}
+
+// changeContents returns the content of the blobs of the changes, by hash.
+// The changes that do not fit in the maxLineCountFiles and maxLineCountBytes
+// caps are marked with LinesUnknown, and their blobs are not loaded
+func changeContents(
+	ctx context.Context,
+	db service.SQLDB,
+	repo string,
+	changes []service.FileChange,
+) (map[string]string, error) {
+	sizes, err := blobSizes(ctx, db, repo, changeHashes(changes))
+	if err != nil {
+		return nil, err
+	}
+
+	var loaded []service.FileChange
+	var files int
+	var bytes int64
+	for i := range changes {
+		size := sizes[changes[i].OldHash] + sizes[changes[i].NewHash]
+		if files >= maxLineCountFiles || bytes+size > maxLineCountBytes {
+			changes[i].LinesUnknown = true
+			continue
+		}
+
+		files++
+		bytes += size
+		loaded = append(loaded, changes[i])
+	}
+
+	return blobContents(ctx, db, repo, changeHashes(loaded))
+}
+
+// changeHashes returns the blob hashes of the changes
+func changeHashes(changes []service.FileChange) []string {
+	var hashes []string
+	for _, c := range changes {
+		for _, h := range []string{c.OldHash, c.NewHash} {
+			if h != "" {
+				hashes = append(hashes, h)
+			}
+		}
+	}
+
+	return hashes
+}
+
+// blobSizes returns the size in bytes of the blobs, by hash
+func blobSizes(ctx context.Context, db service.SQLDB, repo string, hashes []string) (map[string]int64, error) {
+	sizes := make(map[string]int64, len(hashes))
+	err := inBatches(hashes, func(batch []string) error {
+		marks, args := inArgs(batch)
+		rows, err := db.QueryContext(ctx,
+			"SELECT blob_hash, blob_size FROM blobs"+
+				" WHERE repository_id = ? AND blob_hash IN ("+marks+")",
+			append([]interface{}{repo}, args...)...)
+		if err != nil {
+			return dbError(err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var hash string
+			var size int64
+			if err := rows.Scan(&hash, &size); err != nil {
+				return err
+			}
+
+			sizes[hash] = size
+		}
+
+		if err := rows.Err(); err != nil {
+			return dbError(err)
+		}
+
+		return nil
+	})
+
+	return sizes, err
+}
+
+// blobContents returns the content of the blobs, by hash
+func blobContents(ctx context.Context, db service.SQLDB, repo string, hashes []string) (map[string]string, error) {
+	contents := make(map[string]string, len(hashes))
+	err := inBatches(hashes, func(batch []string) error {
+		marks, args := inArgs(batch)
+		rows, err := db.QueryContext(ctx,
+			"SELECT blob_hash, blob_content FROM blobs"+
+				" WHERE repository_id = ? AND blob_hash IN ("+marks+")",
+			append([]interface{}{repo}, args...)...)
+		if err != nil {
+			return dbError(err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var hash, content string
+			if err := rows.Scan(&hash, &content); err != nil {
+				return err
+			}
+
+			contents[hash] = content
+		}
+
+		if err := rows.Err(); err != nil {
+			return dbError(err)
+		}
+
+		return nil
+	})
+
+	return contents, err
+}
@@ -0,0 +1,205 @@
+package handler_test
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/src-d/gitbase-web/server/handler"
+	"github.com/src-d/gitbase-web/server/service"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/suite"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+const commitColumns = `c.commit_hash, c.commit_author_name, c.commit_author_email,` +
+	` c.commit_author_when, c.committer_name, c.committer_email, c.committer_when,` +
+	` c.commit_message, c.commit_parents`
+
+const logFrom = ` FROM ref_commits r INNER JOIN commits c` +
+	` ON r.repository_id = c.repository_id AND r.commit_hash = c.commit_hash`
+
+type CommitsSuite struct {
+	handler.RouterSuite
+}
+
+func TestCommitsSuite(t *testing.T) {
+	suite.Run(t, &CommitsSuite{handler.RouterSuite{
+		Routes: func(r chi.Router, db service.SQLDB) {
+			r.Get("/repos/{id}/commits", handler.APIHandlerFunc(handler.ListCommits(db)))
+			r.Get("/repos/{id}/commits/{hash}", handler.APIHandlerFunc(handler.GetCommit(db)))
+		},
+	}})
+}
+
+var commitRowColumns = []string{
+	"commit_hash", "commit_author_name", "commit_author_email", "commit_author_when",
+	"committer_name", "committer_email", "committer_when", "commit_message", "commit_parents",
+}
+
+func commitRow(hash, author string, when time.Time, parents string) []driver.Value {
+	return []driver.Value{
+		hash, author, author + "@example.com", when,
+		author, author + "@example.com", when, "message of " + hash, []byte(parents),
+	}
+}
+
+func (suite *CommitsSuite) TestListCommits() {
+	day := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT `+commitColumns+logFrom+
+		` WHERE r.repository_id = ? AND r.ref_name = ?`+
+		` AND (c.commit_author_name = ? OR c.commit_author_email = ?)`+
+		` AND c.commit_author_when >= ?`+
+		` ORDER BY r.history_index LIMIT 2 OFFSET 0`)).
+		WithArgs("repo", "HEAD", "alice", "alice", day).
+		WillReturnRows(sqlmock.NewRows(commitRowColumns).
+			AddRow(commitRow("c2", "alice", day.Add(48*time.Hour), `["c1"]`)...).
+			AddRow(commitRow("c1", "alice", day.Add(24*time.Hour), `[]`)...))
+
+	var commits []service.Commit
+	var meta pageMeta
+	res := suite.Get("/repos/repo/commits?author=alice&since=2018-10-01&limit=1", &commits, &meta)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	suite.Require().Len(commits, 1)
+	suite.Equal("c2", commits[0].Hash)
+	suite.Equal("alice@example.com", commits[0].Author.Email)
+	suite.Equal([]string{"c1"}, commits[0].Parents)
+	suite.Equal(pageMeta{Offset: 0, Next: 1}, meta)
+}
+
+func (suite *CommitsSuite) TestListCommitsPath() {
+	day := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+	columns := append(append([]string{}, commitRowColumns...), "blob_hash")
+
+	// c3 keeps the blob of c2, which changed the one of c1
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT `+commitColumns+`, f.blob_hash`+logFrom+
+		` INNER JOIN commit_files f ON c.repository_id = f.repository_id AND c.commit_hash = f.commit_hash`+
+		` WHERE r.repository_id = ? AND r.ref_name = ? AND f.file_path = ?`+
+		` ORDER BY r.history_index LIMIT ?`)).
+		WithArgs("repo", "refs/heads/dev", "server/router.go", 10000).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(append(commitRow("c3", "bob", day.Add(72*time.Hour), `["c2"]`), "b2")...).
+			AddRow(append(commitRow("c2", "alice", day.Add(48*time.Hour), `["c1"]`), "b2")...).
+			AddRow(append(commitRow("c1", "bob", day.Add(24*time.Hour), `[]`), "b1")...))
+
+	var commits []service.Commit
+	res := suite.Get("/repos/repo/commits?ref=refs/heads/dev&path=server/router.go", &commits, nil)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	suite.Require().Len(commits, 2)
+	suite.Equal("c2", commits[0].Hash)
+	suite.Equal("c1", commits[1].Hash)
+}
+
+func (suite *CommitsSuite) TestBadDate() {
+	res := suite.Get("/repos/repo/commits?until=yesterday", nil, nil)
+	suite.Equal(http.StatusBadRequest, res.Code, res.Body.String())
+}
+
+func (suite *CommitsSuite) TestGetCommit() {
+	day := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT `+commitColumns+` FROM commits c`+
+		` WHERE c.repository_id = ? AND c.commit_hash = ?`)).
+		WithArgs("repo", "c2").
+		WillReturnRows(sqlmock.NewRows(commitRowColumns).
+			AddRow(commitRow("c2", "alice", day, `["c1"]`)...))
+
+	filesQuery := regexp.QuoteMeta(`SELECT file_path, blob_hash, tree_entry_mode FROM commit_files NATURAL JOIN files` +
+		` WHERE repository_id = ? AND commit_hash = ?`)
+	suite.Mock.ExpectQuery(filesQuery).
+		WithArgs("repo", "c2").
+		WillReturnRows(sqlmock.NewRows([]string{"file_path", "blob_hash", "tree_entry_mode"}).
+			AddRow("README.md", "readme", "100644").
+			AddRow("main.go", "main2", "100644").
+			AddRow("new.go", "new", "100644"))
+	suite.Mock.ExpectQuery(filesQuery).
+		WithArgs("repo", "c1").
+		WillReturnRows(sqlmock.NewRows([]string{"file_path", "blob_hash", "tree_entry_mode"}).
+			AddRow("README.md", "readme", "100644").
+			AddRow("main.go", "main1", "100644").
+			AddRow("old.go", "old", "100644"))
+
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT blob_hash, blob_size FROM blobs`+
+		` WHERE repository_id = ? AND blob_hash IN (?, ?, ?, ?)`)).
+		WithArgs("repo", "main1", "main2", "new", "old").
+		WillReturnRows(sqlmock.NewRows([]string{"blob_hash", "blob_size"}).
+			AddRow("main1", 30).
+			AddRow("main2", 40).
+			AddRow("new", 13).
+			AddRow("old", 24))
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT blob_hash, blob_content FROM blobs`+
+		` WHERE repository_id = ? AND blob_hash IN (?, ?, ?, ?)`)).
+		WithArgs("repo", "main1", "main2", "new", "old").
+		WillReturnRows(sqlmock.NewRows([]string{"blob_hash", "blob_content"}).
+			AddRow("main1", "package main\n\nfunc main() {\n}\n").
+			AddRow("main2", "package main\n\nfunc main() {\n\tprintln()\n}\n").
+			AddRow("new", "package main\n").
+			AddRow("old", "package old\n\nvar a int\n"))
+
+	var commit service.CommitDetail
+	res := suite.Get("/repos/repo/commits/c2", &commit, nil)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	suite.Equal("message of c2", commit.Message)
+	suite.Equal([]service.FileChange{
+		{Path: "main.go", Status: service.FileModified, OldHash: "main1", NewHash: "main2",
+			OldMode: "100644", NewMode: "100644", Added: 1},
+		{Path: "new.go", Status: service.FileAdded, NewHash: "new", NewMode: "100644", Added: 1},
+		{Path: "old.go", Status: service.FileDeleted, OldHash: "old", OldMode: "100644", Removed: 3},
+	}, commit.Files)
+}
+
+func (suite *CommitsSuite) TestGetCommitLinesUnknown() {
+	day := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT `+commitColumns+` FROM commits c`+
+		` WHERE c.repository_id = ? AND c.commit_hash = ?`)).
+		WithArgs("repo", "c1").
+		WillReturnRows(sqlmock.NewRows(commitRowColumns).
+			AddRow(commitRow("c1", "alice", day, `[]`)...))
+
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT file_path, blob_hash, tree_entry_mode FROM commit_files NATURAL JOIN files`+
+		` WHERE repository_id = ? AND commit_hash = ?`)).
+		WithArgs("repo", "c1").
+		WillReturnRows(sqlmock.NewRows([]string{"file_path", "blob_hash", "tree_entry_mode"}).
+			AddRow("data.bin", "large", "100644").
+			AddRow("main.go", "main", "100644"))
+
+	// the large blob does not fit in the cap, so only main is loaded
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT blob_hash, blob_size FROM blobs`+
+		` WHERE repository_id = ? AND blob_hash IN (?, ?)`)).
+		WithArgs("repo", "large", "main").
+		WillReturnRows(sqlmock.NewRows([]string{"blob_hash", "blob_size"}).
+			AddRow("large", 1<<30).
+			AddRow("main", 13))
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT blob_hash, blob_content FROM blobs`+
+		` WHERE repository_id = ? AND blob_hash IN (?)`)).
+		WithArgs("repo", "main").
+		WillReturnRows(sqlmock.NewRows([]string{"blob_hash", "blob_content"}).
+			AddRow("main", "package main\n"))
+
+	var commit service.CommitDetail
+	res := suite.Get("/repos/repo/commits/c1", &commit, nil)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	suite.Equal([]service.FileChange{
+		{Path: "data.bin", Status: service.FileAdded, NewHash: "large", NewMode: "100644", LinesUnknown: true},
+		{Path: "main.go", Status: service.FileAdded, NewHash: "main", NewMode: "100644", Added: 1},
+	}, commit.Files)
+}
+
+func (suite *CommitsSuite) TestGetCommitNotFound() {
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT `+commitColumns+` FROM commits c`+
+		` WHERE c.repository_id = ? AND c.commit_hash = ?`)).
+		WithArgs("repo", "missing").
+		WillReturnRows(sqlmock.NewRows(commitRowColumns))
+
+	res := suite.Get("/repos/repo/commits/missing", nil, nil)
+	suite.Equal(http.StatusNotFound, res.Code, res.Body.String())
+}
@@ -6,11 +6,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 
+	"github.com/src-d/gitbase-web/server/bblfshpool"
 	"github.com/src-d/gitbase-web/server/handler"
 	"github.com/src-d/gitbase-web/server/serializer"
 	"github.com/src-d/gitbase-web/server/service"
 	testingTools "github.com/src-d/gitbase-web/server/testing"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/pressly/lg"
 	"github.com/sirupsen/logrus"
@@ -59,7 +61,14 @@ func getDB() (service.SQLDB, error) {
 	envconfig.MustProcess("GITBASEPG", &conf)
 
 	if conf.IsIntegration {
-		return sql.Open("mysql", conf.DBConn)
+		// interpolate the placeholders of the queries, like the server does
+		cfg, err := mysql.ParseDSN(conf.DBConn)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.InterpolateParams = true
+		return sql.Open("mysql", cfg.FormatDSN())
 	}
 
 	return &testingTools.MockDB{}, nil
@@ -109,3 +118,16 @@ func bblfshServerURL() string {
 
 	return conf.BblfshServerURL
 }
+
+func bblfshPool() *bblfshpool.Pool {
+	pool, err := bblfshpool.New([]string{bblfshServerURL()}, bblfshpool.Options{})
+	if err != nil {
+		panic(err)
+	}
+
+	return pool
+}
+
+func bblfshEndpoints() *handler.BblfshEndpoints {
+	return &handler.BblfshEndpoints{Default: bblfshPool()}
+}
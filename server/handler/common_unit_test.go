@@ -1,11 +1,15 @@
 package handler
 
 import (
+	"database/sql"
+	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 
 	"github.com/src-d/gitbase-web/server/service"
 	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
 
+	"github.com/go-chi/chi"
 	"github.com/pressly/lg"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/suite"
@@ -45,3 +49,53 @@ func (suite *HandlerUnitSuite) TearDownTest() {
 		suite.FailNowf("there were unfulfilled expectations:", err.Error())
 	}
 }
+
+// RouterSuite tests the handlers mounted by Routes in a router, with a mock
+// DB that is closed after each test
+type RouterSuite struct {
+	suite.Suite
+	Mock sqlmock.Sqlmock
+	// Routes mounts the tested handlers, that use the mock DB
+	Routes func(r chi.Router, db service.SQLDB)
+
+	db     *sql.DB
+	router http.Handler
+}
+
+func (suite *RouterSuite) SetupTest() {
+	var err error
+	suite.db, suite.Mock, err = sqlmock.New()
+	suite.Require().NoError(err)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+
+	r := chi.NewRouter()
+	r.Use(lg.RequestLogger(logger))
+	suite.Routes(r, suite.db)
+	suite.router = r
+}
+
+func (suite *RouterSuite) TearDownTest() {
+	defer suite.db.Close()
+
+	suite.NoError(suite.Mock.ExpectationsWereMet())
+}
+
+// Get requests the url, and decodes the data and meta of the response into
+// the given values when it is successful. They can be nil
+func (suite *RouterSuite) Get(url string, data interface{}, meta interface{}) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", url, nil)
+	res := httptest.NewRecorder()
+	suite.router.ServeHTTP(res, req)
+
+	if res.Code == http.StatusOK && (data != nil || meta != nil) {
+		resp := struct {
+			Data interface{} `json:"data"`
+			Meta interface{} `json:"meta"`
+		}{data, meta}
+		suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &resp))
+	}
+
+	return res
+}
@@ -2,12 +2,21 @@ package handler
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 
-	"gopkg.in/src-d/enry.v1"
-
 	"github.com/src-d/gitbase-web/server/serializer"
+	"github.com/src-d/gitbase-web/server/service"
+)
+
+const (
+	// maxDetectLangBatchFiles is the maximum number of files of a batch
+	// request
+	maxDetectLangBatchFiles = 1000
+	// maxDetectLangBatchBytes is the maximum size of the body of a batch
+	// request
+	maxDetectLangBatchBytes = 32 << 20
 )
 
 type detectLangRequest struct {
@@ -15,7 +24,8 @@ type detectLangRequest struct {
 	Filename string `json:"filename"`
 }
 
-// DetectLanguage returns a function that detects language by filename and content
+// DetectLanguage returns a function that detects language by filename and
+// content, and returns the ranked candidates and the file classification
 func DetectLanguage() RequestProcessFunc {
 	return func(r *http.Request) (res *serializer.Response, err error) {
 		var req detectLangRequest
@@ -29,8 +39,38 @@ func DetectLanguage() RequestProcessFunc {
 			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
 
-		lang := enry.GetLanguage(req.Filename, []byte(req.Content))
-		langType := enry.GetLanguageType(lang)
-		return serializer.NewDetectLangResponse(lang, langType), nil
+		detection := service.DetectLanguage(req.Filename, []byte(req.Content))
+		return serializer.NewDetectLangResponse(detection), nil
+	}
+}
+
+// DetectLanguageBatch returns a function that detects the language of
+// several files, and returns the results in the same order. The number of
+// files and the size of the request are capped
+func DetectLanguageBatch() RequestProcessFunc {
+	return func(r *http.Request) (res *serializer.Response, err error) {
+		var reqs []detectLangRequest
+		body, err := readBody(r, maxDetectLangBatchBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(body, &reqs)
+		if err != nil {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		if len(reqs) > maxDetectLangBatchFiles {
+			return nil, serializer.NewHTTPError(http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("Request Entity Too Large. The batch can not have more than %d files",
+					maxDetectLangBatchFiles))
+		}
+
+		detections := make([]service.LangDetection, len(reqs))
+		for i, req := range reqs {
+			detections[i] = service.DetectLanguage(req.Filename, []byte(req.Content))
+		}
+
+		return serializer.NewDetectLangBatchResponse(detections), nil
 	}
 }
@@ -7,6 +7,8 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/src-d/gitbase-web/server/service"
+
 	"github.com/pressly/lg"
 	"github.com/stretchr/testify/suite"
 	enry "gopkg.in/src-d/enry.v1"
@@ -88,6 +90,115 @@ func (suite *DetectLangSuite) TestUnknownContent() {
 	suite.Equal(enry.Unknown, langType)
 }
 
+func (suite *DetectLangSuite) TestCandidates() {
+	body := `{"filename": "foo.m", "content": "x_0=linspace(0,100,101);"}`
+	req, _ := http.NewRequest("POST", "/detect-lang", strings.NewReader(body))
+
+	res := httptest.NewRecorder()
+	suite.handler.ServeHTTP(res, req)
+
+	suite.Equal(http.StatusOK, res.Code)
+
+	var resBody struct {
+		Data service.LangDetection `json:"data"`
+	}
+	suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &resBody))
+
+	// the extension candidates, ranked by the classifier
+	candidates := resBody.Data.Candidates
+	suite.Require().Len(candidates, 7)
+	suite.Equal(resBody.Data.Language, candidates[0].Language)
+	suite.Equal("classifier", candidates[0].Strategy)
+	suite.Contains(candidates, service.LangCandidate{Language: "MATLAB", Strategy: "classifier"})
+}
+
+func (suite *DetectLangSuite) TestCandidatesFilename() {
+	body := `{"filename": "Dockerfile", "content": "FROM alpine"}`
+	req, _ := http.NewRequest("POST", "/detect-lang", strings.NewReader(body))
+
+	res := httptest.NewRecorder()
+	suite.handler.ServeHTTP(res, req)
+
+	suite.Equal(http.StatusOK, res.Code)
+
+	var resBody struct {
+		Data service.LangDetection `json:"data"`
+	}
+	suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &resBody))
+
+	suite.Equal([]service.LangCandidate{{Language: "Dockerfile", Strategy: "filename"}},
+		resBody.Data.Candidates)
+}
+
+func (suite *DetectLangSuite) TestFlags() {
+	testCases := []struct {
+		body  string
+		check func(service.LangDetection) bool
+	}{
+		{`{"filename": "vendor/jquery.js"}`, func(d service.LangDetection) bool { return d.Vendor }},
+		{`{"filename": "docs/README.md"}`, func(d service.LangDetection) bool { return d.Documentation }},
+		{`{"filename": "config.yml"}`, func(d service.LangDetection) bool { return d.Configuration }},
+		{`{"filename": ".gitignore"}`, func(d service.LangDetection) bool { return d.DotFile }},
+		{`{"filename": "logo.png"}`, func(d service.LangDetection) bool { return d.Image }},
+		{`{"content": "a\u0000b"}`, func(d service.LangDetection) bool { return d.Binary && d.Language == "" }},
+	}
+
+	for _, tc := range testCases {
+		req, _ := http.NewRequest("POST", "/detect-lang", strings.NewReader(tc.body))
+
+		res := httptest.NewRecorder()
+		suite.handler.ServeHTTP(res, req)
+
+		suite.Equal(http.StatusOK, res.Code)
+
+		var resBody struct {
+			Data service.LangDetection `json:"data"`
+		}
+		suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &resBody))
+		suite.True(tc.check(resBody.Data), tc.body)
+	}
+}
+
+func (suite *DetectLangSuite) TestBatch() {
+	h := lg.RequestLogger(suite.logger)(APIHandlerFunc(DetectLanguageBatch()))
+
+	body := `[{"filename": "index.js"}, {"filename": "main.go", "content": "package main"}]`
+	req, _ := http.NewRequest("POST", "/detect-lang/batch", strings.NewReader(body))
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	suite.Equal(http.StatusOK, res.Code)
+
+	var resBody struct {
+		Data []service.LangDetection `json:"data"`
+	}
+	suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &resBody))
+
+	suite.Require().Len(resBody.Data, 2)
+	suite.Equal("JavaScript", resBody.Data[0].Language)
+	suite.Equal("Go", resBody.Data[1].Language)
+	suite.Equal("extension", resBody.Data[1].Candidates[0].Strategy)
+}
+
+func (suite *DetectLangSuite) TestBatchTooLarge() {
+	h := lg.RequestLogger(suite.logger)(APIHandlerFunc(DetectLanguageBatch()))
+
+	files := strings.Repeat(`{"filename": "main.go"},`, maxDetectLangBatchFiles)
+	bodies := []string{
+		`[` + files + `{"filename": "index.js"}]`,
+		`[{"filename": "main.go", "content": "` + strings.Repeat("a", maxDetectLangBatchBytes) + `"}]`,
+	}
+
+	for _, body := range bodies {
+		req, _ := http.NewRequest("POST", "/detect-lang/batch", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+
+		suite.Equal(http.StatusRequestEntityTooLarge, res.Code)
+	}
+}
+
 func langResponse(b []byte) (string, enry.Type) {
 	var resBody struct {
 		Data struct {
@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/src-d/gitbase-web/server/serializer"
+	"github.com/src-d/gitbase-web/server/service"
+
+	"github.com/pressly/lg"
+)
+
+const (
+	defaultDiffContext = 3
+	maxDiffContext     = 10
+)
+
+// revision is a commit or a blob of a repository. Only one of its hashes is
+// set
+type revision struct {
+	commit string
+	blob   string
+}
+
+// Diff returns a function that returns the diff between two commits or two
+// blobs of a repository. The commits can be given by reference name or by
+// hash. The files of both trees are paired by path, and the renamed files by
+// blob hash. The diff is returned as JSON hunks by file, or as a patch with
+// the format=patch query parameter. A patch can not leave out the content of
+// the files beyond the caps, so it is a 413 error instead
+func Diff(db service.SQLDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		format := params.Get("format")
+
+		diffs, err := func() ([]service.FileDiff, error) {
+			if format != "" && format != "json" && format != "patch" {
+				return nil, serializer.NewHTTPError(http.StatusBadRequest,
+					fmt.Sprintf(`Bad Request. Invalid "format" %q; it must be "json" or "patch"`, format))
+			}
+
+			diffs, err := diffFiles(r, db)
+			if err != nil || format != "patch" {
+				return diffs, err
+			}
+
+			for _, d := range diffs {
+				if d.LinesUnknown {
+					return nil, serializer.NewHTTPError(http.StatusRequestEntityTooLarge,
+						fmt.Sprintf("Request Entity Too Large. The patch can not be larger than %d files "+
+							"and %d bytes of blobs; select fewer files with the \"path\" parameter",
+							maxLineCountFiles, maxLineCountBytes))
+				}
+			}
+
+			return diffs, nil
+		}()
+		if err == context.Canceled {
+			return
+		}
+
+		if err != nil || format != "patch" {
+			var response *serializer.Response
+			if err == nil {
+				response = serializer.NewDiffResponse(diffs)
+			}
+
+			write(w, r, response, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="diff.patch"`)
+		if err := service.WritePatch(w, diffs); err != nil {
+			lg.RequestLog(r).Error(fmt.Sprintf("error writing the patch: %s", err))
+		}
+	}
+}
+
+// diffFiles returns the diff of each file changed between the from and to
+// revisions of the request, from the repository of the URL. The to revision
+// is HEAD by default. The path parameter selects a file or a directory. The
+// files beyond the maxLineCountFiles and maxLineCountBytes caps have no hunks
+func diffFiles(r *http.Request, db service.SQLDB) ([]service.FileDiff, error) {
+	repo, err := repositoryID(r)
+	if err != nil {
+		return nil, err
+	}
+
+	params := r.URL.Query()
+	contextLines := defaultDiffContext
+	err = parseIntParams(params, []intParam{
+		{"context", &contextLines, 0, maxDiffContext},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Get("from") == "" {
+		return nil, serializer.NewHTTPError(http.StatusBadRequest,
+			`Bad Request. The "from" commit or blob is required`)
+	}
+
+	from, err := resolveRevision(r.Context(), db, repo, params.Get("from"))
+	if err != nil {
+		return nil, err
+	}
+
+	toRev := params.Get("to")
+	if toRev == "" {
+		toRev = "HEAD"
+	}
+
+	to, err := resolveRevision(r.Context(), db, repo, toRev)
+	if err != nil {
+		return nil, err
+	}
+
+	if (from.blob == "") != (to.blob == "") {
+		return nil, serializer.NewHTTPError(http.StatusBadRequest,
+			`Bad Request. "from" and "to" must be both commits or both blobs`)
+	}
+
+	filePath := cleanPath(params.Get("path"))
+
+	var changes []service.FileChange
+	if from.blob != "" {
+		// the blobs have no path nor mode of their own, they are named by
+		// the path parameter or by the hash of the new one
+		if filePath == "" {
+			filePath = to.blob
+		}
+
+		changes = service.ChangedFiles(
+			map[string]service.TreeFile{filePath: {Hash: from.blob}},
+			map[string]service.TreeFile{filePath: {Hash: to.blob}})
+	} else {
+		fromFiles, err := commitFiles(r.Context(), db, repo, from.commit)
+		if err != nil {
+			return nil, err
+		}
+
+		toFiles, err := commitFiles(r.Context(), db, repo, to.commit)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = service.DetectRenames(service.ChangedFiles(fromFiles, toFiles))
+		if filePath != "" {
+			changes = changesIn(changes, filePath)
+		}
+	}
+
+	contents, err := changeContents(r.Context(), db, repo, changes)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := make([]service.FileDiff, len(changes))
+	for i, c := range changes {
+		if c.LinesUnknown {
+			diffs[i] = service.FileDiff{FileChange: c, Hunks: []service.Hunk{}}
+			continue
+		}
+
+		diffs[i] = service.DiffFile(c, contents[c.OldHash], contents[c.NewHash], contextLines)
+	}
+
+	return diffs, nil
+}
+
+// changesIn returns the changes of the file, or of the files in the
+// directory, with the given path
+func changesIn(changes []service.FileChange, p string) []service.FileChange {
+	in := func(path string) bool {
+		return path == p || strings.HasPrefix(path, p+"/")
+	}
+
+	result := []service.FileChange{}
+	for _, c := range changes {
+		if in(c.Path) || (c.OldPath != "" && in(c.OldPath)) {
+			result = append(result, c)
+		}
+	}
+
+	return result
+}
+
+// resolveRevision returns the commit of a reference name or a commit hash,
+// or the blob of a blob hash
+func resolveRevision(ctx context.Context, db service.SQLDB, repo, rev string) (*revision, error) {
+	queries := []struct {
+		query string
+		blob  bool
+	}{
+		{"SELECT commit_hash FROM refs WHERE repository_id = ? AND ref_name = ?", false},
+		{"SELECT commit_hash FROM commits WHERE repository_id = ? AND commit_hash = ?", false},
+		{"SELECT blob_hash FROM blobs WHERE repository_id = ? AND blob_hash = ?", true},
+	}
+
+	for _, q := range queries {
+		var hash string
+		err := db.QueryRowContext(ctx, q.query, repo, rev).Scan(&hash)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, dbError(err)
+		}
+
+		if q.blob {
+			return &revision{blob: hash}, nil
+		}
+
+		return &revision{commit: hash}, nil
+	}
+
+	return nil, serializer.NewHTTPError(http.StatusNotFound,
+		fmt.Sprintf("Reference, commit or blob %q not found in the repository %q", rev, repo))
+}
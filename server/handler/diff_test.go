@@ -0,0 +1,241 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/src-d/gitbase-web/server/handler"
+	"github.com/src-d/gitbase-web/server/service"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/suite"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var (
+	refQuery    = regexp.QuoteMeta(`SELECT commit_hash FROM refs WHERE repository_id = ? AND ref_name = ?`)
+	commitQuery = regexp.QuoteMeta(`SELECT commit_hash FROM commits WHERE repository_id = ? AND commit_hash = ?`)
+	blobQuery   = regexp.QuoteMeta(`SELECT blob_hash FROM blobs WHERE repository_id = ? AND blob_hash = ?`)
+	filesQuery  = regexp.QuoteMeta(`SELECT file_path, blob_hash, tree_entry_mode FROM commit_files NATURAL JOIN files` +
+		` WHERE repository_id = ? AND commit_hash = ?`)
+)
+
+type DiffSuite struct {
+	handler.RouterSuite
+}
+
+func TestDiffSuite(t *testing.T) {
+	suite.Run(t, &DiffSuite{handler.RouterSuite{
+		Routes: func(r chi.Router, db service.SQLDB) {
+			r.Get("/repos/{id}/diff", handler.Diff(db))
+		},
+	}})
+}
+
+func (suite *DiffSuite) expectRevision(rev string, query string) {
+	for _, q := range []string{refQuery, commitQuery, blobQuery} {
+		rows := sqlmock.NewRows([]string{"hash"})
+		if q == query {
+			rows.AddRow(rev)
+		}
+
+		suite.Mock.ExpectQuery(q).WithArgs("repo", rev).WillReturnRows(rows)
+		if q == query {
+			return
+		}
+	}
+}
+
+func (suite *DiffSuite) TestCommits() {
+	suite.expectRevision("c1", commitQuery)
+	suite.Mock.ExpectQuery(refQuery).WithArgs("repo", "HEAD").
+		WillReturnRows(sqlmock.NewRows([]string{"commit_hash"}).AddRow("c2"))
+
+	suite.Mock.ExpectQuery(filesQuery).
+		WithArgs("repo", "c1").
+		WillReturnRows(sqlmock.NewRows([]string{"file_path", "blob_hash", "tree_entry_mode"}).
+			AddRow("README.md", "readme", "100644").
+			AddRow("logo.png", "img1", "100644").
+			AddRow("main.go", "main1", "100644").
+			AddRow("old.go", "old", "100644"))
+	suite.Mock.ExpectQuery(filesQuery).
+		WithArgs("repo", "c2").
+		WillReturnRows(sqlmock.NewRows([]string{"file_path", "blob_hash", "tree_entry_mode"}).
+			AddRow("README.md", "readme", "100644").
+			AddRow("logo.png", "img2", "100644").
+			AddRow("main.go", "main2", "100755").
+			AddRow("renamed.go", "old", "100644"))
+
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT blob_hash, blob_size FROM blobs`+
+		` WHERE repository_id = ? AND blob_hash IN (?, ?, ?, ?, ?, ?)`)).
+		WithArgs("repo", "img1", "img2", "main1", "main2", "old", "old").
+		WillReturnRows(sqlmock.NewRows([]string{"blob_hash", "blob_size"}).
+			AddRow("img1", 6).
+			AddRow("img2", 6).
+			AddRow("main1", 30).
+			AddRow("main2", 40).
+			AddRow("old", 12))
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT blob_hash, blob_content FROM blobs`+
+		` WHERE repository_id = ? AND blob_hash IN (?, ?, ?, ?, ?, ?)`)).
+		WithArgs("repo", "img1", "img2", "main1", "main2", "old", "old").
+		WillReturnRows(sqlmock.NewRows([]string{"blob_hash", "blob_content"}).
+			AddRow("img1", "\x89PNG\x00\x01").
+			AddRow("img2", "\x89PNG\x00\x02").
+			AddRow("main1", "package main\n\nfunc main() {\n}\n").
+			AddRow("main2", "package main\n\nfunc main() {\n\tprintln()\n}\n").
+			AddRow("old", "package old\n"))
+
+	res := suite.Get("/repos/repo/diff?from=c1&context=1", nil, nil)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	var resp struct {
+		Data []service.FileDiff `json:"data"`
+	}
+	suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &resp))
+
+	suite.Equal([]service.FileDiff{
+		{
+			FileChange: service.FileChange{Path: "logo.png", Status: service.FileModified,
+				OldHash: "img1", NewHash: "img2", OldMode: "100644", NewMode: "100644", Binary: true},
+			Hunks: []service.Hunk{},
+		},
+		{
+			FileChange: service.FileChange{Path: "main.go", Status: service.FileModified,
+				OldHash: "main1", NewHash: "main2", OldMode: "100644", NewMode: "100755", Added: 1},
+			Hunks: []service.Hunk{{
+				OldStart: 3, OldLines: 2, NewStart: 3, NewLines: 3,
+				Lines: []string{" func main() {", "+\tprintln()", " }"},
+			}},
+		},
+		{
+			FileChange: service.FileChange{Path: "renamed.go", OldPath: "old.go",
+				Status: service.FileRenamed, OldHash: "old", NewHash: "old",
+				OldMode: "100644", NewMode: "100644"},
+			Hunks: []service.Hunk{},
+		},
+	}, resp.Data)
+}
+
+func (suite *DiffSuite) TestBlobsPatch() {
+	suite.expectRevision("b1", blobQuery)
+	suite.expectRevision("b2", blobQuery)
+
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT blob_hash, blob_size FROM blobs`+
+		` WHERE repository_id = ? AND blob_hash IN (?, ?)`)).
+		WithArgs("repo", "b1", "b2").
+		WillReturnRows(sqlmock.NewRows([]string{"blob_hash", "blob_size"}).
+			AddRow("b1", 4).
+			AddRow("b2", 3))
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT blob_hash, blob_content FROM blobs`+
+		` WHERE repository_id = ? AND blob_hash IN (?, ?)`)).
+		WithArgs("repo", "b1", "b2").
+		WillReturnRows(sqlmock.NewRows([]string{"blob_hash", "blob_content"}).
+			AddRow("b1", "a\nb\n").
+			AddRow("b2", "a\nc"))
+
+	res := suite.Get("/repos/repo/diff?from=b1&to=b2&path=a.txt&format=patch", nil, nil)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	suite.Equal("text/x-diff; charset=utf-8", res.Header().Get("Content-Type"))
+	suite.Equal("diff --git a/a.txt b/a.txt\n"+
+		"--- a/a.txt\n"+
+		"+++ b/a.txt\n"+
+		"@@ -1,2 +1,2 @@\n"+
+		" a\n"+
+		"-b\n"+
+		"+c\n"+
+		"\\ No newline at end of file\n", res.Body.String())
+}
+
+func (suite *DiffSuite) TestCommitsPatch() {
+	suite.expectRevision("c1", commitQuery)
+	suite.expectRevision("c2", commitQuery)
+
+	suite.Mock.ExpectQuery(filesQuery).
+		WithArgs("repo", "c1").
+		WillReturnRows(sqlmock.NewRows([]string{"file_path", "blob_hash", "tree_entry_mode"}).
+			AddRow("old.txt", "old", "100644").
+			AddRow("run.sh", "run", "100644"))
+	suite.Mock.ExpectQuery(filesQuery).
+		WithArgs("repo", "c2").
+		WillReturnRows(sqlmock.NewRows([]string{"file_path", "blob_hash", "tree_entry_mode"}).
+			AddRow("new.txt", "new", "100644").
+			AddRow("run.sh", "run", "100755"))
+
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT blob_hash, blob_size FROM blobs`)).
+		WithArgs("repo", "new", "old", "run", "run").
+		WillReturnRows(sqlmock.NewRows([]string{"blob_hash", "blob_size"}).
+			AddRow("new", 2).
+			AddRow("old", 2).
+			AddRow("run", 3))
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT blob_hash, blob_content FROM blobs`)).
+		WithArgs("repo", "new", "old", "run", "run").
+		WillReturnRows(sqlmock.NewRows([]string{"blob_hash", "blob_content"}).
+			AddRow("new", "b\n").
+			AddRow("old", "a\n").
+			AddRow("run", "ls\n"))
+
+	res := suite.Get("/repos/repo/diff?from=c1&to=c2&format=patch", nil, nil)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	suite.Equal("diff --git a/new.txt b/new.txt\n"+
+		"new file mode 100644\n"+
+		"--- /dev/null\n"+
+		"+++ b/new.txt\n"+
+		"@@ -0,0 +1 @@\n"+
+		"+b\n"+
+		"diff --git a/old.txt b/old.txt\n"+
+		"deleted file mode 100644\n"+
+		"--- a/old.txt\n"+
+		"+++ /dev/null\n"+
+		"@@ -1 +0,0 @@\n"+
+		"-a\n"+
+		"diff --git a/run.sh b/run.sh\n"+
+		"old mode 100644\n"+
+		"new mode 100755\n", res.Body.String())
+}
+
+func (suite *DiffSuite) TestPatchTooLarge() {
+	suite.expectRevision("b1", blobQuery)
+	suite.expectRevision("b2", blobQuery)
+
+	// the blobs do not fit in the cap, so their content is not loaded
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT blob_hash, blob_size FROM blobs`)).
+		WithArgs("repo", "b1", "b2").
+		WillReturnRows(sqlmock.NewRows([]string{"blob_hash", "blob_size"}).
+			AddRow("b1", 1<<30).
+			AddRow("b2", 1<<30))
+
+	res := suite.Get("/repos/repo/diff?from=b1&to=b2&format=patch", nil, nil)
+	suite.Equal(http.StatusRequestEntityTooLarge, res.Code, res.Body.String())
+}
+
+func (suite *DiffSuite) TestCommitAndBlob() {
+	suite.expectRevision("c1", commitQuery)
+	suite.expectRevision("b2", blobQuery)
+
+	res := suite.Get("/repos/repo/diff?from=c1&to=b2", nil, nil)
+	suite.Equal(http.StatusBadRequest, res.Code, res.Body.String())
+}
+
+func (suite *DiffSuite) TestNotFound() {
+	suite.expectRevision("missing", "")
+
+	res := suite.Get("/repos/repo/diff?from=missing", nil, nil)
+	suite.Equal(http.StatusNotFound, res.Code, res.Body.String())
+}
+
+func (suite *DiffSuite) TestBadRequest() {
+	urls := []string{
+		"/repos/repo/diff",
+		"/repos/repo/diff?from=c1&format=html",
+		"/repos/repo/diff?from=c1&context=11",
+	}
+
+	for _, url := range urls {
+		res := suite.Get(url, nil, nil)
+		suite.Equal(http.StatusBadRequest, res.Code, url)
+	}
+}
@@ -1,10 +1,13 @@
 package handler
 
 import (
+	"context"
 	"database/sql"
 	"encoding/csv"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 
 	"github.com/go-sql-driver/mysql"
@@ -13,102 +16,137 @@ import (
 	"github.com/src-d/gitbase-web/server/service"
 )
 
+type exportFormat = string
+
+const (
+	csvFormat    exportFormat = "csv"
+	zipFormat    exportFormat = "zip"
+	tarGzFormat  exportFormat = "tar.gz"
+	sqliteFormat exportFormat = "sqlite"
+)
+
+// defaultExportTable is the table name used when the query is not named
+const defaultExportTable = "export"
+
+// exporter writes the rows of a query result to a file in a given format
+type exporter interface {
+	// Start is called with the result columns of each query, before its
+	// rows. Only the sqlite format accepts more than one query
+	Start(table string, columnNames, columnTypes []string) error
+	// Row is called for each row, with the values scanned by genericVals
+	Row(columnValsPtr []interface{}) error
+	// Truncate is called instead of Row when the export reaches one of its
+	// limits, to add a marker explaining why the file is incomplete
+	Truncate(reason string) error
+	// Close flushes any pending data
+	Close() error
+}
+
+// exportTruncatedHeader is the HTTP header, or trailer for the formats that
+// are streamed, set when the export is truncated
+const exportTruncatedHeader = "X-Export-Truncated"
+
+// ExportLimits caps the size of the files returned by /export. A value of 0
+// means no limit
+type ExportLimits struct {
+	// MaxRows is the maximum number of rows
+	MaxRows int
+	// MaxBytes is the maximum size of the exported values. The overhead added
+	// by each file format is not counted
+	MaxBytes int64
+}
+
+// cleaner is implemented by the exporters that need to release resources
+// when the export does not finish
+type cleaner interface {
+	cleanup()
+}
+
 // Export returns a function that forwards an SQL query to gitbase and returns
-// the rows as CSV file
-func Export(db service.SQLDB) http.HandlerFunc {
+// the rows as a file. By default the file is CSV; the format query parameter
+// can be used to request other formats. The export is truncated when it
+// reaches the given limits, or the lower ones requested with the maxRows and
+// maxBytes query parameters
+func Export(db service.SQLDB, limits ExportLimits) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		err := func(w http.ResponseWriter, r *http.Request) error {
-			query := r.URL.Query().Get("query")
-			if query == "" {
+			params := r.URL.Query()
+
+			queries := params["query"]
+			if len(queries) == 0 || queries[0] == "" {
 				return serializer.NewHTTPError(http.StatusBadRequest,
 					`Bad Request. Query can't be empty.`)
 			}
 
-			rows, err := db.Query(query)
+			format := params.Get("format")
+			tables, err := exportTables(format, queries, params["table"])
 			if err != nil {
-				return dbError(err)
+				return err
 			}
-			defer rows.Close()
 
-			columnNames, columnTypes, err := columnsInfo(rows)
+			newExporter, err := exporterFor(format)
 			if err != nil {
 				return err
 			}
 
-			columnValsPtr := genericVals(columnTypes)
+			budget, err := newExportBudget(params, limits)
+			if err != nil {
+				return err
+			}
 
-			w.Header().Set("Content-Disposition", "attachment; filename=export.csv")
-			w.Header().Set("Content-Type", "text/csv")
-			csvWriter := csv.NewWriter(w)
+			var exp exporter
+			defer func() {
+				if c, ok := exp.(cleaner); ok {
+					c.cleanup()
+				}
+			}()
 
-			csvWriter.Write(columnNames)
+			for i, query := range queries {
+				limited := false
+				if budget.MaxRows > 0 {
+					// ask for one more row, to know if the results are truncated
+					query, limited = addLimit(query, budget.MaxRows-budget.rows+1)
+				}
 
-			for rows.Next() {
-				if err := rows.Scan(columnValsPtr...); err != nil {
-					return err
+				ctx, cancel := context.WithCancel(r.Context())
+				rows, err := db.QueryContext(ctx, query)
+				if err != nil {
+					cancel()
+					return dbError(err)
 				}
 
-				record := make([]string, len(columnTypes))
-
-				for i, val := range columnValsPtr {
-					switch v := val.(type) {
-					case *sql.NullBool:
-						sqlVal, _ := val.(*sql.NullBool)
-						if sqlVal.Valid {
-							record[i] = strconv.FormatBool(sqlVal.Bool)
-						}
-					case *mysql.NullTime:
-						sqlVal, _ := val.(*mysql.NullTime)
-						if sqlVal.Valid {
-							b, err := sqlVal.Time.MarshalText()
-							if err != nil {
-								return err
-							}
-							record[i] = string(b)
-						}
-					case *sql.NullInt64:
-						sqlVal, _ := val.(*sql.NullInt64)
-						if sqlVal.Valid {
-							record[i] = strconv.FormatInt(sqlVal.Int64, 10)
-						}
-					case *sql.NullString:
-						// DatabaseTypeName TEXT is used for text or blobs. We try
-						// to parse as UAST first
-						sqlVal, _ := val.(*sql.NullString)
-						if sqlVal.Valid {
-							nodes, err := service.UnmarshalNodes([]byte(sqlVal.String))
-
-							if err == nil && nodes != nil {
-								b, err := json.MarshalIndent(nodes, "", "  ")
-								if err != nil {
-									return err
-								}
-								record[i] = string(b)
-							} else {
-								record[i] = sqlVal.String
-							}
-						}
-					case *[]byte:
-						record[i] = string(*v)
+				if exp == nil {
+					exp, err = newExporter(w, params)
+					if err != nil {
+						rows.Close()
+						cancel()
+						return err
 					}
 				}
 
-				if err := csvWriter.Write(record); err != nil {
+				reason, err := exportRows(exp, tables[i], rows, budget)
+				if reason != "" && !limited {
+					// the query has no LIMIT, it is canceled instead of
+					// reading the rest of its rows on Close
+					cancel()
+				}
+
+				rows.Close()
+				cancel()
+				if err != nil {
 					return err
 				}
-			}
 
-			if err := rows.Err(); err != nil {
-				return err
-			}
+				if reason != "" {
+					if err := exp.Truncate(reason); err != nil {
+						return err
+					}
 
-			if err := csvWriter.Error(); err != nil {
-				return err
+					break
+				}
 			}
 
-			csvWriter.Flush()
-
-			return nil
+			return exp.Close()
 		}(w, r)
 
 		if err != nil {
@@ -120,3 +158,291 @@ func Export(db service.SQLDB) http.HandlerFunc {
 		}
 	}
 }
+
+// exportTables returns the table name for each one of the queries. Only the
+// sqlite format accepts more than one query, and each one of them must be
+// named with a table parameter
+func exportTables(format exportFormat, queries, tables []string) ([]string, error) {
+	if len(queries) == 1 && len(tables) <= 1 {
+		if len(tables) == 0 || tables[0] == "" {
+			return []string{defaultExportTable}, nil
+		}
+
+		return tables, nil
+	}
+
+	if format != sqliteFormat {
+		return nil, serializer.NewHTTPError(http.StatusBadRequest,
+			`Bad Request. Only the "sqlite" format accepts more than one query.`)
+	}
+
+	if len(queries) != len(tables) {
+		return nil, serializer.NewHTTPError(http.StatusBadRequest,
+			`Bad Request. Each query needs a "table" name.`)
+	}
+
+	for i, query := range queries {
+		if query == "" || tables[i] == "" {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				`Bad Request. Query and table names can't be empty.`)
+		}
+	}
+
+	return tables, nil
+}
+
+// exportRows writes the rows to the exporter until the budget is exhausted.
+// If that happens, the reason is returned
+func exportRows(exp exporter, table string, rows *sql.Rows, budget *exportBudget) (string, error) {
+	columnNames, columnTypes, err := columnsInfo(rows)
+	if err != nil {
+		return "", err
+	}
+
+	if err := exp.Start(table, columnNames, columnTypes); err != nil {
+		return "", err
+	}
+
+	columnValsPtr := genericVals(columnTypes)
+
+	for rows.Next() {
+		if err := rows.Scan(columnValsPtr...); err != nil {
+			return "", err
+		}
+
+		if reason := budget.take(columnValsPtr); reason != "" {
+			return reason, nil
+		}
+
+		if err := exp.Row(columnValsPtr); err != nil {
+			return "", err
+		}
+	}
+
+	return "", rows.Err()
+}
+
+// exportBudget keeps track of the rows and bytes exported, to stop when the
+// limits are reached. The reasons name the rows as what, "the export" by
+// default
+type exportBudget struct {
+	ExportLimits
+	what  string
+	rows  int
+	bytes int64
+}
+
+// newExportBudget returns the budget for the export, using the limits
+// requested in the query parameters if they are lower than the server ones
+func newExportBudget(params url.Values, limits ExportLimits) (*exportBudget, error) {
+	if v := params.Get("maxRows"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf(`Bad Request. Invalid "maxRows" %q; it must be a positive number`, v))
+		}
+
+		if limits.MaxRows == 0 || n < limits.MaxRows {
+			limits.MaxRows = n
+		}
+	}
+
+	if v := params.Get("maxBytes"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf(`Bad Request. Invalid "maxBytes" %q; it must be a positive number`, v))
+		}
+
+		if limits.MaxBytes == 0 || n < limits.MaxBytes {
+			limits.MaxBytes = n
+		}
+	}
+
+	return &exportBudget{ExportLimits: limits}, nil
+}
+
+// take adds a row to the budget. If the row does not fit in it, the reason
+// is returned and the row must not be exported
+func (b *exportBudget) take(columnValsPtr []interface{}) string {
+	what := b.what
+	if what == "" {
+		what = "the export"
+	}
+
+	if b.MaxRows > 0 && b.rows >= b.MaxRows {
+		return fmt.Sprintf("%s reached the maximum of %d rows", what, b.MaxRows)
+	}
+
+	size := valuesSize(columnValsPtr)
+	if b.MaxBytes > 0 && b.bytes+size > b.MaxBytes {
+		return fmt.Sprintf("%s reached the maximum of %d bytes", what, b.MaxBytes)
+	}
+
+	b.rows++
+	b.bytes += size
+	return ""
+}
+
+// valuesSize returns the size of the row values: the length of text and
+// binary values, and 8 bytes for any other non NULL value
+func valuesSize(columnValsPtr []interface{}) int64 {
+	var size int64
+
+	for _, val := range columnValsPtr {
+		switch v := val.(type) {
+		case *sql.NullString:
+			size += int64(len(v.String))
+		case *[]byte:
+			size += int64(len(*v))
+		case *sql.NullBool:
+			if v.Valid {
+				size += 8
+			}
+		case *mysql.NullTime:
+			if v.Valid {
+				size += 8
+			}
+		case *sql.NullInt64:
+			if v.Valid {
+				size += 8
+			}
+		case *sql.NullFloat64:
+			if v.Valid {
+				size += 8
+			}
+		}
+	}
+
+	return size
+}
+
+type exporterFunc func(w http.ResponseWriter, params url.Values) (exporter, error)
+
+// exporterFor returns the constructor of the exporter for the given format
+func exporterFor(format exportFormat) (exporterFunc, error) {
+	switch format {
+	case csvFormat, "":
+		return newCSVExporter, nil
+	case zipFormat, tarGzFormat:
+		return func(w http.ResponseWriter, params url.Values) (exporter, error) {
+			return newArchiveExporter(w, format, params)
+		}, nil
+	case sqliteFormat:
+		return newSQLiteExporter, nil
+	default:
+		return nil, serializer.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf(`Bad Request. Invalid "format" %q; it must be one of "csv", "zip", "tar.gz", "sqlite"`, format))
+	}
+}
+
+type csvExporter struct {
+	w         http.ResponseWriter
+	csvWriter *csv.Writer
+	size      int
+}
+
+func newCSVExporter(w http.ResponseWriter, params url.Values) (exporter, error) {
+	return &csvExporter{w: w}, nil
+}
+
+func (e *csvExporter) Start(table string, columnNames, columnTypes []string) error {
+	e.w.Header().Set("Content-Disposition", "attachment; filename=export.csv")
+	e.w.Header().Set("Content-Type", "text/csv")
+	e.w.Header().Set("Trailer", exportTruncatedHeader)
+
+	e.csvWriter = csv.NewWriter(e.w)
+	e.size = len(columnNames)
+
+	return e.csvWriter.Write(columnNames)
+}
+
+func (e *csvExporter) Row(columnValsPtr []interface{}) error {
+	record := make([]string, e.size)
+
+	for i, val := range columnValsPtr {
+		str, err := csvValue(val)
+		if err != nil {
+			return err
+		}
+
+		record[i] = str
+	}
+
+	return e.csvWriter.Write(record)
+}
+
+// Truncate adds a last row with only one value, starting with '#' as the
+// comments supported by some CSV readers
+func (e *csvExporter) Truncate(reason string) error {
+	e.w.Header().Set(exportTruncatedHeader, reason)
+	return e.csvWriter.Write([]string{"# truncated: " + reason})
+}
+
+func (e *csvExporter) Close() error {
+	if err := e.csvWriter.Error(); err != nil {
+		return err
+	}
+
+	e.csvWriter.Flush()
+	return e.csvWriter.Error()
+}
+
+// csvValue returns the text representation of a value scanned into one of
+// the types returned by genericVals. NULL values are returned as ""
+func csvValue(val interface{}) (string, error) {
+	switch v := val.(type) {
+	case *sql.NullBool:
+		if v.Valid {
+			return strconv.FormatBool(v.Bool), nil
+		}
+	case *mysql.NullTime:
+		if v.Valid {
+			b, err := v.Time.MarshalText()
+			if err != nil {
+				return "", err
+			}
+			return string(b), nil
+		}
+	case *sql.NullInt64:
+		if v.Valid {
+			return strconv.FormatInt(v.Int64, 10), nil
+		}
+	case *sql.NullString:
+		// DatabaseTypeName TEXT is used for text or blobs. We try
+		// to parse as UAST first
+		if v.Valid {
+			nodes, err := service.UnmarshalNodes([]byte(v.String))
+
+			if err == nil && nodes != nil {
+				b, err := json.MarshalIndent(nodes, "", "  ")
+				if err != nil {
+					return "", err
+				}
+				return string(b), nil
+			}
+
+			return v.String, nil
+		}
+	case *[]byte:
+		return string(*v), nil
+	}
+
+	return "", nil
+}
+
+// rawValue returns the bytes of a value scanned into one of the types
+// returned by genericVals. Unlike csvValue, text columns are returned as they
+// are, without trying to decode them as UAST
+func rawValue(val interface{}) ([]byte, error) {
+	if v, ok := val.(*sql.NullString); ok {
+		if !v.Valid {
+			return nil, nil
+		}
+
+		return []byte(v.String), nil
+	}
+
+	str, err := csvValue(val)
+	return []byte(str), err
+}
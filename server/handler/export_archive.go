@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/src-d/gitbase-web/server/serializer"
+)
+
+// archiveExporter writes each row as a file inside a zip or tar.gz archive.
+// The file path is built joining the values of the path columns, and the
+// file contents are taken from the content column
+type archiveExporter struct {
+	w           http.ResponseWriter
+	format      exportFormat
+	pathColumns []string
+	contentCol  string
+
+	pathIdx    []int
+	contentIdx int
+
+	zipWriter *zip.Writer
+	gzWriter  *gzip.Writer
+	tarWriter *tar.Writer
+
+	modTime time.Time
+}
+
+func newArchiveExporter(w http.ResponseWriter, format exportFormat, params url.Values) (exporter, error) {
+	pathColumns := params["path"]
+	contentCol := params.Get("content")
+
+	if len(pathColumns) == 0 || contentCol == "" {
+		return nil, serializer.NewHTTPError(http.StatusBadRequest,
+			`Bad Request. The archive formats need at least one "path" column and a "content" column`)
+	}
+
+	return &archiveExporter{
+		w:           w,
+		format:      format,
+		pathColumns: pathColumns,
+		contentCol:  contentCol,
+		modTime:     time.Now(),
+	}, nil
+}
+
+func (e *archiveExporter) Start(table string, columnNames, columnTypes []string) error {
+	e.pathIdx = make([]int, len(e.pathColumns))
+	for i, col := range e.pathColumns {
+		idx, err := columnIndex(columnNames, col)
+		if err != nil {
+			return err
+		}
+
+		e.pathIdx[i] = idx
+	}
+
+	idx, err := columnIndex(columnNames, e.contentCol)
+	if err != nil {
+		return err
+	}
+	e.contentIdx = idx
+
+	e.w.Header().Set("Content-Disposition", "attachment; filename=export."+e.format)
+	e.w.Header().Set("Trailer", exportTruncatedHeader)
+
+	switch e.format {
+	case zipFormat:
+		e.w.Header().Set("Content-Type", "application/zip")
+		e.zipWriter = zip.NewWriter(e.w)
+	case tarGzFormat:
+		e.w.Header().Set("Content-Type", "application/gzip")
+		e.gzWriter = gzip.NewWriter(e.w)
+		e.tarWriter = tar.NewWriter(e.gzWriter)
+	}
+
+	return nil
+}
+
+func (e *archiveExporter) Row(columnValsPtr []interface{}) error {
+	parts := make([]string, len(e.pathIdx))
+	for i, idx := range e.pathIdx {
+		str, err := csvValue(columnValsPtr[idx])
+		if err != nil {
+			return err
+		}
+
+		parts[i] = str
+	}
+
+	name, err := archivePath(parts)
+	if err != nil {
+		return err
+	}
+
+	content, err := rawValue(columnValsPtr[e.contentIdx])
+	if err != nil {
+		return err
+	}
+
+	var fw io.Writer
+	if e.zipWriter != nil {
+		fw, err = e.zipWriter.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: e.modTime,
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		err = e.tarWriter.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			ModTime:  e.modTime,
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			return err
+		}
+
+		fw = e.tarWriter
+	}
+
+	_, err = fw.Write(content)
+	return err
+}
+
+// Truncate sets the zip archive comment, or adds a pax global header with a
+// comment to the tar archive. Neither of them is extracted as a file
+func (e *archiveExporter) Truncate(reason string) error {
+	e.w.Header().Set(exportTruncatedHeader, reason)
+
+	comment := "truncated: " + reason
+	if e.zipWriter != nil {
+		return e.zipWriter.SetComment(comment)
+	}
+
+	return e.tarWriter.WriteHeader(&tar.Header{
+		Typeflag:   tar.TypeXGlobalHeader,
+		Name:       "pax_global_header",
+		PAXRecords: map[string]string{"comment": comment},
+	})
+}
+
+func (e *archiveExporter) Close() error {
+	if e.zipWriter != nil {
+		return e.zipWriter.Close()
+	}
+
+	if err := e.tarWriter.Close(); err != nil {
+		return err
+	}
+
+	return e.gzWriter.Close()
+}
+
+// columnIndex returns the position of the column with the given name
+func columnIndex(columnNames []string, name string) (int, error) {
+	for i, col := range columnNames {
+		if col == name {
+			return i, nil
+		}
+	}
+
+	return 0, serializer.NewHTTPError(http.StatusBadRequest,
+		fmt.Sprintf("Bad Request. Column %q is not part of the query results", name))
+}
+
+// archivePath joins the given path parts into a clean, relative slash
+// separated path. Leading slashes, as in repository IDs like /opt/repos/foo,
+// are removed. An error is returned if the path is empty or if it would end
+// outside of the archive root
+func archivePath(parts []string) (string, error) {
+	joined := strings.Replace(strings.Join(parts, "/"), `\`, "/", -1)
+	name := path.Clean(strings.TrimLeft(joined, "/"))
+
+	if name == "." || name == ".." || strings.HasPrefix(name, "../") {
+		return "", serializer.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("Bad Request. Invalid archive path %q", joined))
+	}
+
+	return name, nil
+}
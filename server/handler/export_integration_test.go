@@ -31,7 +31,7 @@ func TestExportIntegrationSuite(t *testing.T) {
 
 	s := new(ExportIntegrationSuite)
 	s.db = db
-	s.handler = handler.Export(db)
+	s.handler = handler.Export(db, handler.ExportLimits{})
 
 	if !isIntegration() {
 		t.Skip("use the env var GITBASEPG_INTEGRATION_TESTS=true to run this test")
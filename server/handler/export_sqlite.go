@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/src-d/gitbase-web/server/serializer"
+	"github.com/src-d/gitbase-web/server/service"
+	"github.com/src-d/gitbase-web/server/sqlite"
+)
+
+// sqliteExporter writes the results of each query to a table of an SQLite
+// database file. The file is built in a temporary file, and sent once all the
+// queries are done
+type sqliteExporter struct {
+	w      http.ResponseWriter
+	file   *os.File
+	writer *sqlite.Writer
+	table  string
+	values []interface{}
+
+	truncated string
+}
+
+// truncatedTable is the name of the table added to the SQLite file when the
+// export is truncated
+const truncatedTable = "export_truncated"
+
+func newSQLiteExporter(w http.ResponseWriter, params url.Values) (exporter, error) {
+	file, err := ioutil.TempFile("", "gitbase-web-export-")
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqliteExporter{
+		w:      w,
+		file:   file,
+		writer: sqlite.NewWriter(file),
+	}, nil
+}
+
+func (e *sqliteExporter) Start(table string, columnNames, columnTypes []string) error {
+	columns := make([]sqlite.Column, len(columnNames))
+	for i, name := range columnNames {
+		columns[i] = sqlite.Column{Name: name, Type: sqliteType(columnTypes[i])}
+	}
+
+	e.table = table
+	e.values = make([]interface{}, len(columnNames))
+
+	err := e.writer.CreateTable(table, columns)
+	if err == sqlite.ErrSchemaTooLarge {
+		return serializer.NewHTTPError(http.StatusBadRequest,
+			"Bad Request. The tables of the queries do not fit in the sqlite schema; "+
+				"export fewer queries or columns")
+	}
+
+	return err
+}
+
+func (e *sqliteExporter) Row(columnValsPtr []interface{}) error {
+	for i, val := range columnValsPtr {
+		v, err := sqliteValue(val)
+		if err != nil {
+			return err
+		}
+
+		e.values[i] = v
+	}
+
+	return e.writer.Insert(e.values)
+}
+
+// Truncate adds a table with the name of the truncated table, and the reason
+func (e *sqliteExporter) Truncate(reason string) error {
+	e.truncated = reason
+
+	err := e.writer.CreateTable(truncatedTable, []sqlite.Column{
+		{Name: "table", Type: "TEXT"},
+		{Name: "reason", Type: "TEXT"},
+	})
+	if err != nil {
+		return err
+	}
+
+	return e.writer.Insert([]interface{}{e.table, reason})
+}
+
+func (e *sqliteExporter) Close() error {
+	if err := e.writer.Close(); err != nil {
+		return err
+	}
+
+	if _, err := e.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	info, err := e.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	e.w.Header().Set("Content-Disposition", "attachment; filename=export.sqlite")
+	e.w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	e.w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	if e.truncated != "" {
+		e.w.Header().Set(exportTruncatedHeader, e.truncated)
+	}
+
+	_, err = io.Copy(e.w, e.file)
+	return err
+}
+
+// cleanup deletes the temporary file. It is safe to call it more than once
+func (e *sqliteExporter) cleanup() {
+	if e.file == nil {
+		return
+	}
+
+	e.file.Close()
+	os.Remove(e.file.Name())
+	e.file = nil
+}
+
+// sqliteType returns the declared type for an SQLite column, given the
+// DatabaseTypeName returned by gitbase
+func sqliteType(colType string) string {
+	switch colType {
+	case "BIT":
+		return "BOOLEAN"
+	case "TIMESTAMP", "DATE", "DATETIME":
+		return "DATETIME"
+	case "INT", "MEDIUMINT", "BIGINT", "SMALLINT", "TINYINT":
+		return "INTEGER"
+	case "DOUBLE", "FLOAT":
+		return "REAL"
+	default:
+		return "TEXT"
+	}
+}
+
+// sqliteValue converts a value scanned into one of the types returned by
+// genericVals into a value accepted by sqlite.Writer. UASTs are stored as
+// JSON, in the same way they are exported to CSV
+func sqliteValue(val interface{}) (interface{}, error) {
+	switch v := val.(type) {
+	case *sql.NullBool:
+		if v.Valid {
+			return v.Bool, nil
+		}
+	case *mysql.NullTime:
+		if v.Valid {
+			return v.Time.Format(time.RFC3339Nano), nil
+		}
+	case *sql.NullInt64:
+		if v.Valid {
+			return v.Int64, nil
+		}
+	case *sql.NullFloat64:
+		if v.Valid {
+			return v.Float64, nil
+		}
+	case *sql.NullString:
+		if v.Valid {
+			nodes, err := service.UnmarshalNodes([]byte(v.String))
+			if err == nil && nodes != nil {
+				b, err := json.Marshal(nodes)
+				if err != nil {
+					return nil, err
+				}
+				return string(b), nil
+			}
+
+			return v.String, nil
+		}
+	case *[]byte:
+		return string(*v), nil
+	}
+
+	return nil, nil
+}
@@ -1,10 +1,17 @@
 package handler_test
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
 	"encoding/csv"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/src-d/gitbase-web/server/handler"
@@ -30,7 +37,7 @@ func (suite *ExportSuite) SetupTest() {
 		suite.T().Fatalf("failed to initialize the mock DB. '%s'", err)
 	}
 
-	suite.handler = handler.Export(suite.db)
+	suite.handler = handler.Export(suite.db, handler.ExportLimits{})
 }
 
 func (suite *ExportSuite) TearDownTest() {
@@ -132,3 +139,331 @@ func (suite *ExportSuite) TestBadRequest() {
 		})
 	}
 }
+
+func (suite *ExportSuite) TestZip() {
+	rows := sqlmock.NewRows([]string{"repository_id", "file_path", "blob_content"}).
+		AddRow("/opt/repos/a", "main.go", "package main").
+		AddRow("/opt/repos/b", "docs/README.md", "# b")
+
+	suite.mock.ExpectQuery(".*").WillReturnRows(rows)
+
+	req, _ := http.NewRequest("GET", "/export/?query=select&format=zip"+
+		"&path=repository_id&path=file_path&content=blob_content", nil)
+	res := httptest.NewRecorder()
+
+	suite.handler.ServeHTTP(res, req)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+	suite.Equal("application/zip", res.Header().Get("Content-Type"))
+
+	zr, err := zip.NewReader(bytes.NewReader(res.Body.Bytes()), int64(res.Body.Len()))
+	suite.Require().NoError(err)
+
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		suite.Require().NoError(err)
+		b, err := ioutil.ReadAll(rc)
+		suite.Require().NoError(err)
+		rc.Close()
+
+		files[f.Name] = string(b)
+	}
+
+	suite.Equal(map[string]string{
+		"opt/repos/a/main.go":        "package main",
+		"opt/repos/b/docs/README.md": "# b",
+	}, files)
+}
+
+func (suite *ExportSuite) TestTarGz() {
+	rows := sqlmock.NewRows([]string{"file_path", "blob_content"}).
+		AddRow("main.go", "package main")
+
+	suite.mock.ExpectQuery(".*").WillReturnRows(rows)
+
+	req, _ := http.NewRequest("GET", "/export/?query=select&format=tar.gz"+
+		"&path=file_path&content=blob_content", nil)
+	res := httptest.NewRecorder()
+
+	suite.handler.ServeHTTP(res, req)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	gz, err := gzip.NewReader(res.Body)
+	suite.Require().NoError(err)
+	tr := tar.NewReader(gz)
+
+	hdr, err := tr.Next()
+	suite.Require().NoError(err)
+	suite.Equal("main.go", hdr.Name)
+
+	b, err := ioutil.ReadAll(tr)
+	suite.Require().NoError(err)
+	suite.Equal("package main", string(b))
+
+	_, err = tr.Next()
+	suite.Equal(io.EOF, err)
+}
+
+func (suite *ExportSuite) TestArchivePathEscape() {
+	testCases := []string{
+		"../etc/passwd",
+		"a/../../etc/passwd",
+		"/..",
+		"",
+	}
+
+	for _, tc := range testCases {
+		suite.T().Run(tc, func(t *testing.T) {
+			a := assert.New(t)
+
+			rows := sqlmock.NewRows([]string{"file_path", "blob_content"}).
+				AddRow(tc, "content")
+			suite.mock.ExpectQuery(".*").WillReturnRows(rows)
+
+			req, _ := http.NewRequest("GET", "/export/?query=select&format=zip"+
+				"&path=file_path&content=blob_content", nil)
+			res := httptest.NewRecorder()
+
+			suite.handler.ServeHTTP(res, req)
+
+			a.Equal(http.StatusBadRequest, res.Code)
+			a.Contains(res.Body.String(), "Invalid archive path")
+		})
+	}
+}
+
+func (suite *ExportSuite) TestArchiveBadRequest() {
+	testCases := []string{
+		"/export/?query=select&format=zip",
+		"/export/?query=select&format=zip&path=file_path",
+		"/export/?query=select&format=tar.gz&content=blob_content",
+		"/export/?query=select&format=zip&path=nope&content=blob_content",
+		"/export/?query=select&format=rar",
+	}
+
+	for _, tc := range testCases {
+		suite.T().Run(tc, func(t *testing.T) {
+			a := assert.New(t)
+
+			rows := sqlmock.NewRows([]string{"file_path", "blob_content"}).
+				AddRow("main.go", "content")
+			suite.mock.ExpectQuery(".*").WillReturnRows(rows)
+
+			req, _ := http.NewRequest("GET", tc, nil)
+			res := httptest.NewRecorder()
+
+			suite.handler.ServeHTTP(res, req)
+
+			a.Equal(http.StatusBadRequest, res.Code)
+			a.Contains(res.Body.String(), "Bad Request")
+		})
+	}
+}
+
+func (suite *ExportSuite) TestSQLite() {
+	suite.mock.ExpectQuery("select 1").WillReturnRows(
+		sqlmock.NewRows([]string{"a", "b"}).AddRow(1, "one").AddRow(2, "two"))
+	suite.mock.ExpectQuery("select 2").WillReturnRows(
+		sqlmock.NewRows([]string{"uast"}).AddRow(common.UASTMarshaled))
+
+	req, _ := http.NewRequest("GET", "/export/?format=sqlite"+
+		"&query=select+1&table=numbers&query=select+2&table=uasts", nil)
+	res := httptest.NewRecorder()
+
+	suite.handler.ServeHTTP(res, req)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+	suite.Equal("application/vnd.sqlite3", res.Header().Get("Content-Type"))
+	suite.Equal(fmt.Sprint(res.Body.Len()), res.Header().Get("Content-Length"))
+
+	body := res.Body.String()
+	suite.True(strings.HasPrefix(body, "SQLite format 3\x00"))
+	suite.Contains(body, `CREATE TABLE "numbers" ("a" TEXT, "b" TEXT)`)
+	suite.Contains(body, `CREATE TABLE "uasts" ("uast" TEXT)`)
+	suite.Contains(body, `"@type":"uast:Identifier"`)
+}
+
+func (suite *ExportSuite) TestSQLiteBadRequest() {
+	testCases := []string{
+		"/export/?query=select+1&query=select+2",
+		"/export/?format=zip&query=select+1&table=a&query=select+2&table=b",
+		"/export/?format=sqlite&query=select+1&query=select+2",
+		"/export/?format=sqlite&query=select+1&table=a&query=select+2&table=",
+		"/export/?format=sqlite&query=select+1&table=a&query=select+2",
+	}
+
+	for _, tc := range testCases {
+		suite.T().Run(tc, func(t *testing.T) {
+			a := assert.New(t)
+
+			req, _ := http.NewRequest("GET", tc, nil)
+			res := httptest.NewRecorder()
+
+			suite.handler.ServeHTTP(res, req)
+
+			a.Equal(http.StatusBadRequest, res.Code)
+			a.Contains(res.Body.String(), "Bad Request")
+		})
+	}
+}
+
+func (suite *ExportSuite) TestMaxRows() {
+	rows := sqlmock.NewRows([]string{"a", "b"}).
+		AddRow(1, "one").
+		AddRow(2, "two").
+		AddRow(3, "three")
+
+	// one more row than the limit is requested, to detect the truncation
+	suite.mock.ExpectQuery(`select \* from repositories LIMIT 3`).WillReturnRows(rows)
+
+	h := handler.Export(suite.db, handler.ExportLimits{MaxRows: 5})
+	req, _ := http.NewRequest("GET", "/export/?query=select+*+from+repositories&maxRows=2", nil)
+	res := httptest.NewRecorder()
+
+	h.ServeHTTP(res, req)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+	suite.Equal("the export reached the maximum of 2 rows",
+		res.Result().Trailer.Get("X-Export-Truncated"))
+
+	body := res.Body.String()
+	suite.True(strings.HasSuffix(body,
+		"# truncated: the export reached the maximum of 2 rows\n"))
+
+	r := csv.NewReader(strings.NewReader(body))
+	r.Comment = '#'
+	records, err := r.ReadAll()
+	suite.Require().NoError(err)
+	suite.Equal([][]string{{"a", "b"}, {"1", "one"}, {"2", "two"}}, records)
+}
+
+func (suite *ExportSuite) TestMaxRowsWithoutLimit() {
+	rows := sqlmock.NewRows([]string{"name"}).
+		AddRow("commits").
+		AddRow("refs").
+		AddRow("repositories")
+
+	// a LIMIT can't be added to the query, the rows are counted instead
+	suite.mock.ExpectQuery(`^show tables$`).WillReturnRows(rows)
+
+	h := handler.Export(suite.db, handler.ExportLimits{})
+	req, _ := http.NewRequest("GET", "/export/?query=show+tables&maxRows=2", nil)
+	res := httptest.NewRecorder()
+
+	h.ServeHTTP(res, req)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+	suite.Equal("name\ncommits\nrefs\n# truncated: the export reached the maximum of 2 rows\n",
+		res.Body.String())
+}
+
+func (suite *ExportSuite) TestMaxRowsNotReached() {
+	rows := sqlmock.NewRows([]string{"a"}).AddRow(1)
+	suite.mock.ExpectQuery(`select 1 LIMIT 3`).WillReturnRows(rows)
+
+	h := handler.Export(suite.db, handler.ExportLimits{MaxRows: 2})
+	req, _ := http.NewRequest("GET", "/export/?query=select+1&maxRows=10", nil)
+	res := httptest.NewRecorder()
+
+	h.ServeHTTP(res, req)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+	suite.Empty(res.Result().Trailer.Get("X-Export-Truncated"))
+	suite.Equal("a\n1\n", res.Body.String())
+}
+
+func (suite *ExportSuite) TestMaxBytes() {
+	rows := sqlmock.NewRows([]string{"file_path", "blob_content"}).
+		AddRow("a.go", "package a").
+		AddRow("b.go", "package b")
+
+	suite.mock.ExpectQuery(".*").WillReturnRows(rows)
+
+	h := handler.Export(suite.db, handler.ExportLimits{MaxBytes: 20})
+	req, _ := http.NewRequest("GET", "/export/?query=select&format=zip"+
+		"&path=file_path&content=blob_content", nil)
+	res := httptest.NewRecorder()
+
+	h.ServeHTTP(res, req)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	zr, err := zip.NewReader(bytes.NewReader(res.Body.Bytes()), int64(res.Body.Len()))
+	suite.Require().NoError(err)
+	suite.Require().Len(zr.File, 1)
+	suite.Equal("a.go", zr.File[0].Name)
+	suite.Equal("truncated: the export reached the maximum of 20 bytes", zr.Comment)
+}
+
+func (suite *ExportSuite) TestMaxRowsTarGz() {
+	rows := sqlmock.NewRows([]string{"file_path", "blob_content"}).
+		AddRow("a.go", "package a").
+		AddRow("b.go", "package b")
+
+	suite.mock.ExpectQuery(".*").WillReturnRows(rows)
+
+	req, _ := http.NewRequest("GET", "/export/?query=select&format=tar.gz&maxRows=1"+
+		"&path=file_path&content=blob_content", nil)
+	res := httptest.NewRecorder()
+
+	suite.handler.ServeHTTP(res, req)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	gz, err := gzip.NewReader(res.Body)
+	suite.Require().NoError(err)
+	tr := tar.NewReader(gz)
+
+	hdr, err := tr.Next()
+	suite.Require().NoError(err)
+	suite.Equal("a.go", hdr.Name)
+
+	hdr, err = tr.Next()
+	suite.Require().NoError(err)
+	suite.Equal(byte(tar.TypeXGlobalHeader), hdr.Typeflag)
+	suite.Equal("truncated: the export reached the maximum of 1 rows",
+		hdr.PAXRecords["comment"])
+
+	_, err = tr.Next()
+	suite.Equal(io.EOF, err)
+}
+
+func (suite *ExportSuite) TestMaxRowsSQLite() {
+	suite.mock.ExpectQuery("select 1 LIMIT 3").WillReturnRows(
+		sqlmock.NewRows([]string{"a"}).AddRow(1).AddRow(2))
+	suite.mock.ExpectQuery("select 2 LIMIT 1").WillReturnRows(
+		sqlmock.NewRows([]string{"b"}).AddRow(3))
+
+	req, _ := http.NewRequest("GET", "/export/?format=sqlite&maxRows=2"+
+		"&query=select+1&table=first&query=select+2&table=second&query=select+3&table=third", nil)
+	res := httptest.NewRecorder()
+
+	suite.handler.ServeHTTP(res, req)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+	suite.Equal("the export reached the maximum of 2 rows",
+		res.Header().Get("X-Export-Truncated"))
+
+	body := res.Body.String()
+	suite.Contains(body, `CREATE TABLE "second" ("b" TEXT)`)
+	suite.Contains(body, `CREATE TABLE "export_truncated" ("table" TEXT, "reason" TEXT)`)
+	suite.NotContains(body, `CREATE TABLE "third"`)
+	suite.NoError(suite.mock.ExpectationsWereMet())
+}
+
+func (suite *ExportSuite) TestLimitsBadRequest() {
+	testCases := []string{
+		"/export/?query=select+1&maxRows=0",
+		"/export/?query=select+1&maxRows=-1",
+		"/export/?query=select+1&maxRows=x",
+		"/export/?query=select+1&maxBytes=0",
+		"/export/?query=select+1&maxBytes=1k",
+	}
+
+	for _, tc := range testCases {
+		suite.T().Run(tc, func(t *testing.T) {
+			a := assert.New(t)
+
+			req, _ := http.NewRequest("GET", tc, nil)
+			res := httptest.NewRecorder()
+
+			suite.handler.ServeHTTP(res, req)
+
+			a.Equal(http.StatusBadRequest, res.Code)
+			a.Contains(res.Body.String(), "Bad Request")
+		})
+	}
+}
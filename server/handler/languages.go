@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/src-d/gitbase-web/server/bblfshpool"
+	"github.com/src-d/gitbase-web/server/serializer"
+	"github.com/src-d/gitbase-web/server/service"
+
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// DefaultLanguagesTTL is the default time the supported languages are cached
+const DefaultLanguagesTTL = 5 * time.Minute
+
+// LanguagesCache keeps the languages supported by the bblfsh drivers. They
+// are requested again when they are older than the TTL, and refreshed in the
+// background once Start is called
+type LanguagesCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	langs   []service.Language
+	updated time.Time
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	// fetch is replaced in the tests
+	fetch func(ctx context.Context) ([]service.Language, error)
+}
+
+// NewLanguagesCache returns a LanguagesCache for the drivers of the bblfsh
+// servers of the pool
+func NewLanguagesCache(pool *bblfshpool.Pool, ttl time.Duration) *LanguagesCache {
+	if ttl <= 0 {
+		ttl = DefaultLanguagesTTL
+	}
+
+	return &LanguagesCache{
+		ttl: ttl,
+		fetch: func(ctx context.Context) ([]service.Language, error) {
+			return supportedLanguages(ctx, pool)
+		},
+	}
+}
+
+func supportedLanguages(ctx context.Context, pool *bblfshpool.Pool) ([]service.Language, error) {
+	cli, done, err := bblfshClient(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	resp, err := cli.NewSupportedLanguagesRequest().Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	langs := service.DriverManifestsToLangs(resp)
+
+	sort.Slice(langs, func(i, j int) bool {
+		return langs[i].Name < langs[j].Name
+	})
+
+	return langs, nil
+}
+
+// Start requests the languages, and keeps refreshing them in the background
+// before they expire until Stop is called
+func (c *LanguagesCache) Start() {
+	c.stop = make(chan struct{})
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		c.refresh()
+
+		// refresh a bit earlier than the TTL, so the requests never wait
+		ticker := time.NewTicker(c.ttl * 9 / 10)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				c.refresh()
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the background refresh
+func (c *LanguagesCache) Stop() {
+	if c.stop != nil {
+		close(c.stop)
+		c.wg.Wait()
+		c.stop = nil
+	}
+}
+
+func (c *LanguagesCache) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), bblfshpool.DefaultTimeout)
+	defer cancel()
+
+	if _, err := c.update(ctx); err != nil {
+		log.Errorf(err, "error refreshing the bblfsh supported languages")
+	}
+}
+
+// update requests the languages and stores them
+func (c *LanguagesCache) update(ctx context.Context) ([]service.Language, error) {
+	langs, err := c.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.langs = langs
+	c.updated = time.Now()
+
+	return langs, nil
+}
+
+// Languages returns the cached languages, or requests them if they have
+// expired
+func (c *LanguagesCache) Languages(ctx context.Context) ([]service.Language, error) {
+	c.mu.Lock()
+	langs, updated := c.langs, c.updated
+	c.mu.Unlock()
+
+	if langs != nil && time.Since(updated) < c.ttl {
+		return langs, nil
+	}
+
+	return c.update(ctx)
+}
+
+// languageID returns the id of the bblfsh driver for the language with the
+// given enry name or alias, or an empty string if no driver supports it
+func (c *LanguagesCache) languageID(ctx context.Context, lang string) (string, error) {
+	langs, err := c.Languages(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return service.LanguageID(langs, lang), nil
+}
+
+// GetLanguages returns a list of supported languages by bblfsh
+func GetLanguages(cache *LanguagesCache) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		langs, err := cache.Languages(r.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewLanguagesResponse(langs), nil
+	}
+}
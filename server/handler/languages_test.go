@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/src-d/gitbase-web/server/service"
+
+	bblfsh "github.com/bblfsh/go-client"
+	"github.com/pressly/lg"
+	"github.com/stretchr/testify/suite"
+)
+
+type LanguagesSuite struct {
+	HandlerUnitSuite
+	cache *LanguagesCache
+
+	mu    sync.Mutex
+	calls int
+	err   error
+}
+
+func (suite *LanguagesSuite) SetupTest() {
+	suite.calls = 0
+	suite.err = nil
+
+	suite.cache = &LanguagesCache{ttl: time.Hour, fetch: suite.fetch}
+
+	h := APIHandlerFunc(GetLanguages(suite.cache))
+	suite.handler = lg.RequestLogger(suite.logger)(h)
+}
+
+func (suite *LanguagesSuite) TearDownTest() {
+	suite.cache.Stop()
+}
+
+func (suite *LanguagesSuite) fetch(ctx context.Context) ([]service.Language, error) {
+	suite.mu.Lock()
+	defer suite.mu.Unlock()
+
+	suite.calls++
+	if suite.err != nil {
+		return nil, suite.err
+	}
+
+	return service.DriverManifestsToLangs([]bblfsh.DriverManifest{{
+		Name:     "Go",
+		Language: "go",
+		Version:  "v2.2.0",
+		Status:   "beta",
+		Features: []string{"ast", "uast", "roles"},
+	}}), nil
+}
+
+func (suite *LanguagesSuite) fetchCalls() int {
+	suite.mu.Lock()
+	defer suite.mu.Unlock()
+
+	return suite.calls
+}
+
+func (suite *LanguagesSuite) getLanguages() *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", "/get-languages", nil)
+	res := httptest.NewRecorder()
+	suite.handler.ServeHTTP(res, req)
+
+	return res
+}
+
+// Tests
+// -----------------------------------------------------------------------------
+
+func TestLanguagesSuite(t *testing.T) {
+	s := new(LanguagesSuite)
+
+	suite.Run(t, s)
+}
+
+func (suite *LanguagesSuite) TestDetails() {
+	res := suite.getLanguages()
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	var resBody struct {
+		Data []service.Language `json:"data"`
+	}
+	suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &resBody))
+	suite.Require().Len(resBody.Data, 1)
+
+	lang := resBody.Data[0]
+	suite.Equal("go", lang.ID)
+	suite.Equal("v2.2.0", lang.Version)
+	suite.Equal("beta", lang.Status)
+	suite.Equal([]string{"ast", "uast", "roles"}, lang.Features)
+	suite.Equal([]string{"native", "annotated", "semantic"}, lang.Modes)
+	suite.Contains(lang.Aliases, "golang")
+}
+
+func (suite *LanguagesSuite) TestCached() {
+	for i := 0; i < 3; i++ {
+		res := suite.getLanguages()
+		suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+	}
+
+	suite.Equal(1, suite.fetchCalls())
+}
+
+func (suite *LanguagesSuite) TestExpired() {
+	suite.cache.ttl = time.Millisecond
+
+	res := suite.getLanguages()
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	time.Sleep(5 * time.Millisecond)
+
+	res = suite.getLanguages()
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	suite.Equal(2, suite.fetchCalls())
+}
+
+func (suite *LanguagesSuite) TestError() {
+	suite.err = errors.New("bblfsh is down")
+
+	res := suite.getLanguages()
+	suite.Equal(http.StatusInternalServerError, res.Code)
+}
+
+func (suite *LanguagesSuite) TestBackgroundRefresh() {
+	suite.cache.ttl = 20 * time.Millisecond
+	suite.cache.Start()
+
+	deadline := time.Now().Add(time.Second)
+	for suite.fetchCalls() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	suite.Require().True(suite.fetchCalls() >= 3)
+
+	suite.cache.Stop()
+	calls := suite.fetchCalls()
+
+	time.Sleep(50 * time.Millisecond)
+	suite.Equal(calls, suite.fetchCalls())
+}
+
+func (suite *LanguagesSuite) TestLanguageID() {
+	suite.cache.fetch = func(ctx context.Context) ([]service.Language, error) {
+		return service.DriverManifestsToLangs([]bblfsh.DriverManifest{
+			{Language: "go"}, {Language: "csharp"}, {Language: "cpp"},
+		}), nil
+	}
+
+	testCases := map[string]string{
+		"Go":     "go",
+		"golang": "go",
+		"C#":     "csharp",
+		"C++":    "cpp",
+		"Python": "",
+		"nope":   "",
+	}
+
+	for lang, id := range testCases {
+		got, err := suite.cache.languageID(context.Background(), lang)
+		suite.Require().NoError(err)
+		suite.Equal(id, got, lang)
+	}
+}
+
+// NewTestLanguagesCache returns a LanguagesCache with the drivers of the given
+// bblfsh languages, for the tests of the handler_test package
+func NewTestLanguagesCache(ids ...string) *LanguagesCache {
+	drivers := make([]bblfsh.DriverManifest, len(ids))
+	for i, id := range ids {
+		drivers[i] = bblfsh.DriverManifest{Language: id}
+	}
+
+	return &LanguagesCache{
+		ttl: time.Hour,
+		fetch: func(ctx context.Context) ([]service.Language, error) {
+			return service.DriverManifestsToLangs(drivers), nil
+		},
+	}
+}
@@ -10,9 +10,12 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
+	"github.com/src-d/gitbase-web/server/memstore"
 	"github.com/src-d/gitbase-web/server/serializer"
 	"github.com/src-d/gitbase-web/server/service"
+	"github.com/src-d/gitbase-web/server/uastutil"
 
 	"github.com/go-sql-driver/mysql"
 )
@@ -20,6 +23,41 @@ import (
 type queryRequest struct {
 	Query string `json:"query"`
 	Limit int    `json:"limit,omitempty"`
+	// Output is the format of the UAST columns
+	Output string `json:"output,omitempty"`
+	// UASTFilters are xpath queries applied to the UAST columns, by column
+	// name. Only the matching nodes are returned
+	UASTFilters map[string]string `json:"uastFilters,omitempty"`
+	// CellMaxBytes overrides the maximum size of the text cells. 0 means no
+	// limit
+	CellMaxBytes *int `json:"cellMaxBytes,omitempty"`
+}
+
+// QueryOptions configures the values kept by the server for the /query
+// results. A nil store disables its feature
+type QueryOptions struct {
+	// UASTs keeps the protobufs of the UAST columns, so the rows have their
+	// handles instead. They are decoded again by /filter
+	UASTs *memstore.Store
+	// Cells keeps the full value of the text cells truncated to CellMaxBytes,
+	// so they can be loaded later with /cell
+	Cells *memstore.Store
+	// CellMaxBytes is the default maximum size in bytes of the text cells.
+	// 0 means no limit
+	CellMaxBytes int
+
+	// limits caps the rows read, like the /export ones. The rest of the rows
+	// are not read, and the result is truncated
+	limits ExportLimits
+}
+
+// truncatedCell replaces the text cells longer than the maximum size. Cell is
+// the id to load the full value from /cell, empty if it was not kept
+type truncatedCell struct {
+	Truncated bool   `json:"truncated"`
+	Size      int    `json:"size"`
+	Value     string `json:"value"`
+	Cell      string `json:"cell,omitempty"`
 }
 
 // genericVals returns a slice of interface{}, each one a pointer to the proper
@@ -50,8 +88,9 @@ func genericVals(colTypes []string) []interface{} {
 }
 
 // Query returns a function that forwards an SQL query to gitbase and returns
-// the rows as JSON
-func Query(db service.SQLDB) RequestProcessFunc {
+// the rows as JSON. The UASTs and the long text cells are kept in the stores
+// of opts, if any
+func Query(db service.SQLDB, opts QueryOptions) RequestProcessFunc {
 	return func(r *http.Request) (*serializer.Response, error) {
 		var queryReq queryRequest
 		body, err := ioutil.ReadAll(r.Body)
@@ -65,76 +104,149 @@ func Query(db service.SQLDB) RequestProcessFunc {
 				`Bad Request. Expected body: { "query": "SQL statement", "limit": 1234 }`)
 		}
 
-		// go-sql-driver/mysql QueryContext stops waiting for the query results on
-		// context cancel, but it does not actually cancel the query on the server
+		if _, err := outputFormat(queryReq.Output); err != nil {
+			return nil, err
+		}
 
-		c := make(chan error, 1)
+		// opts is shared by all the requests, the override only applies to
+		// this one
+		reqOpts := opts
+		if queryReq.CellMaxBytes != nil {
+			if *queryReq.CellMaxBytes < 0 {
+				return nil, serializer.NewHTTPError(http.StatusBadRequest,
+					`Bad Request. "cellMaxBytes" can not be negative`)
+			}
 
-		conn, err := db.Conn(r.Context())
-		if err != nil {
-			return nil, fmt.Errorf("failed to get a DB connection: %s", err)
+			reqOpts.CellMaxBytes = *queryReq.CellMaxBytes
 		}
-		defer conn.Close()
 
-		connID, err := getConnID(conn)
+		res, err := runQuery(r.Context(), db, queryReq, reqOpts)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get connection id: %s", err)
+			return nil, err
 		}
 
-		var resp *serializer.Response
-		go func() {
-			resp, err = queryContext(r.Context(), conn, queryReq)
-			c <- err
-		}()
-
-		// It may happen that the QueryContext returns with an error because of
-		// context cancellation. In this case, the select may enter on the second
-		// case. We check if the context was cancelled with Err() instead of Done()
-		select {
-		case <-r.Context().Done():
-		case err = <-c:
-		}
+		return serializer.NewQueryResponse(
+			res.rows, res.columnNames, res.columnTypes, res.limitSet, queryReq.Limit), nil
+	}
+}
 
-		if r.Context().Err() != nil {
-			db.Exec(fmt.Sprintf("KILL %d", connID))
-			return nil, dbError(r.Context().Err())
-		}
+// queryResult holds the rows returned by a query, already converted to the
+// JSON friendly types by columnsData
+type queryResult struct {
+	rows        []map[string]interface{}
+	columnNames []string
+	columnTypes []string
+	limitSet    bool
+	// truncated is the reason why the rows were truncated by the limits of
+	// the options, if they were
+	truncated string
+}
 
-		if err != nil {
-			return nil, dbError(err)
-		}
+// runQuery runs the query in a dedicated connection. If the context is done
+// before the query finishes, the query is killed in gitbase
+func runQuery(
+	ctx context.Context,
+	db service.SQLDB,
+	queryReq queryRequest,
+	opts QueryOptions,
+) (*queryResult, error) {
+	// go-sql-driver/mysql QueryContext stops waiting for the query results on
+	// context cancel, but it does not actually cancel the query on the server
 
-		return resp, nil
+	c := make(chan error, 1)
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get a DB connection: %s", err)
+	}
+	defer conn.Close()
+
+	connID, err := getConnID(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection id: %s", err)
+	}
+
+	var res *queryResult
+	go func() {
+		res, err = queryContext(ctx, conn, queryReq, opts)
+		c <- err
+	}()
+
+	// It may happen that the QueryContext returns with an error because of
+	// context cancellation. In this case, the select may enter on the second
+	// case. We check if the context was cancelled with Err() instead of Done()
+	select {
+	case <-ctx.Done():
+	case err = <-c:
 	}
+
+	if ctx.Err() != nil {
+		db.Exec(fmt.Sprintf("KILL %d", connID))
+		return nil, dbError(ctx.Err())
+	}
+
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return res, nil
 }
 
-func queryContext(ctx context.Context, conn *sql.Conn, queryReq queryRequest) (*serializer.Response, error) {
+func queryContext(
+	ctx context.Context,
+	conn *sql.Conn,
+	queryReq queryRequest,
+	opts QueryOptions,
+) (*queryResult, error) {
 	query, limitSet := addLimit(queryReq.Query, queryReq.Limit)
 
+	// the query is canceled if the limits stop it, instead of reading the
+	// rest of its rows on Close
+	ctx, cancel := context.WithCancel(ctx)
 	var rows *sql.Rows
 
 	rows, err := conn.QueryContext(ctx, query)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
 	defer rows.Close()
+	defer cancel()
 
 	columnNames, columnTypes, err := columnsInfo(rows)
 	if err != nil {
 		return nil, err
 	}
 
+	for col := range queryReq.UASTFilters {
+		if !containsString(columnNames, col) {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf(`Bad Request. The "uastFilters" column %q is not in the results`, col))
+		}
+	}
+
 	columnValsPtr := genericVals(columnTypes)
+	output, err := uastutil.ParseFormat(queryReq.Output)
+	if err != nil {
+		return nil, err
+	}
 
 	tableData := make([]map[string]interface{}, 0)
+	budget := &exportBudget{ExportLimits: opts.limits, what: "the results"}
+	var truncated string
 
 	for rows.Next() {
 		if err := rows.Scan(columnValsPtr...); err != nil {
 			return nil, err
 		}
 
-		colData, err := columnsData(columnNames, columnTypes, columnValsPtr)
+		if truncated = budget.take(columnValsPtr); truncated != "" {
+			break
+		}
+
+		colData, err := columnsData(columnNames, columnTypes, columnValsPtr,
+			output, queryReq.UASTFilters, opts)
 		if err != nil {
 			return nil, err
 		}
@@ -146,8 +258,13 @@ func queryContext(ctx context.Context, conn *sql.Conn, queryReq queryRequest) (*
 		return nil, err
 	}
 
-	return serializer.NewQueryResponse(
-		tableData, columnNames, columnTypes, limitSet, queryReq.Limit), nil
+	return &queryResult{
+		rows:        tableData,
+		columnNames: columnNames,
+		columnTypes: columnTypes,
+		limitSet:    limitSet,
+		truncated:   truncated,
+	}, nil
 }
 
 func getConnID(conn *sql.Conn) (uint32, error) {
@@ -186,10 +303,31 @@ func columnsInfo(rows *sql.Rows) ([]string, []string, error) {
 	return names, typesStr, nil
 }
 
+// containsString returns whether the slice contains s
+func containsString(slice []string, s string) bool {
+	for _, v := range slice {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// columnsData returns the row values converted to JSON friendly types. The
+// UAST columns are filtered with the xpath query of their column in filters,
+// if any, and encoded in the given output format. They are kept in the UASTs
+// store, with their handle in an extra __<column>-handle value. Without a
+// store, or if they do not fit in it, their protobufs are kept in an extra
+// __<column>-protobufs value instead. The text values longer than
+// CellMaxBytes are truncated, see truncateCell
 func columnsData(
 	columnNames []string,
 	columnTypes []string,
 	columnValsPtr []interface{},
+	output uastutil.Format,
+	filters map[string]string,
+	opts QueryOptions,
 ) (map[string]interface{}, error) {
 	colData := make(map[string]interface{}, len(columnTypes))
 
@@ -224,10 +362,39 @@ func columnsData(
 			if sqlVal.Valid {
 				nodes, err := service.UnmarshalNodes([]byte(sqlVal.String))
 				if err == nil && nodes != nil {
-					colData[columnNames[i]] = nodes
-					colData["__"+columnNames[i]+"-protobufs"] = []byte(sqlVal.String)
+					protobufs := []byte(sqlVal.String)
+
+					if filter, ok := filters[columnNames[i]]; ok {
+						nodes, err = applyXpath(nodes, filter)
+						if err != nil {
+							return nil, err
+						}
+
+						protobufs, err = service.MarshalNodes(nodes)
+						if err != nil {
+							return nil, err
+						}
+					}
+
+					uast, err := uastutil.Encode(nodes, output)
+					if err != nil {
+						return nil, err
+					}
+
+					colData[columnNames[i]] = uast
+
+					var handle string
+					if opts.UASTs != nil {
+						handle = opts.UASTs.Put(protobufs, len(protobufs))
+					}
+
+					if handle != "" {
+						colData["__"+columnNames[i]+"-handle"] = handle
+					} else {
+						colData["__"+columnNames[i]+"-protobufs"] = protobufs
+					}
 				} else {
-					colData[columnNames[i]] = sqlVal.String
+					colData[columnNames[i]] = truncateCell(sqlVal.String, opts)
 				}
 			}
 		case *[]byte:
@@ -244,6 +411,54 @@ func columnsData(
 	return colData, nil
 }
 
+// truncateCell returns the text value, or a truncatedCell with its first
+// CellMaxBytes bytes if it is longer. The full value is kept in the Cells
+// store. Without a store the values are not truncated, since they could not
+// be loaded
+func truncateCell(value string, opts QueryOptions) interface{} {
+	if opts.Cells == nil || opts.CellMaxBytes <= 0 || len(value) <= opts.CellMaxBytes {
+		return value
+	}
+
+	// do not cut a multi-byte character
+	end := opts.CellMaxBytes
+	for end > 0 && !utf8.RuneStart(value[end]) {
+		end--
+	}
+
+	return truncatedCell{
+		Truncated: true,
+		Size:      len(value),
+		Value:     value[:end],
+		Cell:      opts.Cells.Put(value, len(value)),
+	}
+}
+
+// Cell returns a function that returns the full value of a text cell
+// truncated by /query, found in the cells store by the id of the cell
+func Cell(cells *memstore.Store) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				`Bad Request. The cell "id" is required`)
+		}
+
+		var value interface{}
+		var ok bool
+		if cells != nil {
+			value, ok = cells.Get(id)
+		}
+
+		if !ok {
+			return nil, serializer.NewHTTPError(http.StatusNotFound,
+				"Cell not found; it may have expired, run the query again")
+		}
+
+		return serializer.NewCellResponse(value.(string)), nil
+	}
+}
+
 var noCommentsRegexp = regexp.MustCompile(`\/\*(?s:.)*?\*\/`)
 var limitRegexp = regexp.MustCompile(`\s+LIMIT\s+(\d+)$`)
 
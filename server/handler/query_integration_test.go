@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/src-d/gitbase-web/server/handler"
+	"github.com/src-d/gitbase-web/server/service"
 
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -22,7 +23,9 @@ type QueryIntegrationSuite struct {
 
 func TestQueryIntegrationSuite(t *testing.T) {
 	q := new(QueryIntegrationSuite)
-	q.requestProcessFunc = handler.Query
+	q.requestProcessFunc = func(db service.SQLDB) handler.RequestProcessFunc {
+		return handler.Query(db, handler.QueryOptions{})
+	}
 
 	if !isIntegration() {
 		t.Skip("use the env var GITBASEPG_INTEGRATION_TESTS=true to run this test")
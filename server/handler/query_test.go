@@ -11,7 +11,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/src-d/gitbase-web/server/memstore"
+	"github.com/src-d/gitbase-web/server/service"
 	common "github.com/src-d/gitbase-web/server/testing"
+	"github.com/src-d/gitbase-web/server/uastutil"
 
 	"github.com/pressly/lg"
 	"github.com/stretchr/testify/assert"
@@ -30,7 +33,9 @@ type QuerySuite struct {
 
 func TestQuerySuite(t *testing.T) {
 	s := new(QuerySuite)
-	s.requestProcessFunc = Query
+	s.requestProcessFunc = func(db service.SQLDB) RequestProcessFunc {
+		return Query(db, QueryOptions{})
+	}
 
 	suite.Run(t, s)
 }
@@ -159,7 +164,7 @@ func (suite *QuerySuite) TestTypes() {
 	err = rows.Scan(columnValsPtr...)
 	suite.NoError(err)
 
-	colData, err := columnsData(columnNames, columnTypes, columnValsPtr)
+	colData, err := columnsData(columnNames, columnTypes, columnValsPtr, uastutil.JSON, nil, QueryOptions{})
 	suite.NoError(err)
 
 	suite.EqualValues(true, colData["a"])
@@ -171,7 +176,7 @@ func (suite *QuerySuite) TestTypes() {
 	err = rows.Scan(columnValsPtr...)
 	suite.NoError(err)
 
-	colData, err = columnsData(columnNames, columnTypes, columnValsPtr)
+	colData, err = columnsData(columnNames, columnTypes, columnValsPtr, uastutil.JSON, nil, QueryOptions{})
 	suite.NoError(err)
 
 	suite.Nil(colData["a"])
@@ -198,7 +203,7 @@ func (suite *QuerySuite) TestUASTType() {
 	err = rows.Scan(columnValsPtr...)
 	suite.NoError(err)
 
-	colData, err := columnsData(columnNames, columnTypes, columnValsPtr)
+	colData, err := columnsData(columnNames, columnTypes, columnValsPtr, uastutil.JSON, nil, QueryOptions{})
 	suite.NoError(err)
 
 	suite.EqualValues("hello.js", colData["filename"])
@@ -212,6 +217,204 @@ func (suite *QuerySuite) TestUASTType() {
 	suite.EqualValues(common.UASTMarshaled, colData["__uast_b-protobufs"])
 }
 
+func (suite *QuerySuite) TestUASTOutput() {
+	columnNames := []string{"uast"}
+	columnTypes := []string{"TEXT"}
+
+	columnValsPtr := genericVals(columnTypes)
+
+	mockRows := sqlmock.NewRows(columnNames).AddRow(common.UASTMarshaled)
+
+	suite.mock.ExpectQuery(".*").WillReturnRows(mockRows)
+
+	rows, err := suite.db.Query("select * from table")
+	suite.NoError(err)
+
+	rows.Next()
+	err = rows.Scan(columnValsPtr...)
+	suite.NoError(err)
+
+	colData, err := columnsData(columnNames, columnTypes, columnValsPtr, uastutil.SExpression, nil, QueryOptions{})
+	suite.NoError(err)
+
+	suite.IsType("", colData["uast"])
+	suite.Contains(colData["uast"], "(")
+	suite.EqualValues(common.UASTMarshaled, colData["__uast-protobufs"])
+}
+
+func (suite *QuerySuite) TestUASTHandle() {
+	columnNames := []string{"uast"}
+	columnTypes := []string{"TEXT"}
+
+	columnValsPtr := genericVals(columnTypes)
+
+	mockRows := sqlmock.NewRows(columnNames).AddRow(common.UASTMarshaled)
+
+	suite.mock.ExpectQuery(".*").WillReturnRows(mockRows)
+
+	rows, err := suite.db.Query("select * from table")
+	suite.NoError(err)
+
+	rows.Next()
+	err = rows.Scan(columnValsPtr...)
+	suite.NoError(err)
+
+	uasts := memstore.New(memstore.Options{})
+	colData, err := columnsData(columnNames, columnTypes, columnValsPtr, uastutil.JSON, nil, QueryOptions{UASTs: uasts})
+	suite.NoError(err)
+
+	suite.Nil(colData["__uast-protobufs"])
+
+	handle, ok := colData["__uast-handle"].(string)
+	suite.Require().True(ok)
+
+	stored, ok := uasts.Get(handle)
+	suite.True(ok)
+	suite.EqualValues(common.UASTMarshaled, stored)
+
+	// the UASTs that do not fit in the store keep their protobufs
+	uasts = memstore.New(memstore.Options{MaxBytes: 1})
+	colData, err = columnsData(columnNames, columnTypes, columnValsPtr, uastutil.JSON, nil, QueryOptions{UASTs: uasts})
+	suite.NoError(err)
+
+	suite.Nil(colData["__uast-handle"])
+	suite.EqualValues(common.UASTMarshaled, colData["__uast-protobufs"])
+}
+
+func (suite *QuerySuite) TestUASTFilters() {
+	columnNames := []string{"uast_a", "uast_b"}
+	columnTypes := []string{"TEXT", "TEXT"}
+
+	columnValsPtr := genericVals(columnTypes)
+
+	mockRows := sqlmock.NewRows(columnNames).
+		AddRow(common.UASTMarshaled, common.UASTMarshaled)
+
+	suite.mock.ExpectQuery(".*").WillReturnRows(mockRows)
+
+	rows, err := suite.db.Query("select * from table")
+	suite.NoError(err)
+
+	rows.Next()
+	err = rows.Scan(columnValsPtr...)
+	suite.NoError(err)
+
+	colData, err := columnsData(columnNames, columnTypes, columnValsPtr, uastutil.JSON,
+		map[string]string{"uast_a": "//uast:Identifier"}, QueryOptions{})
+	suite.NoError(err)
+
+	filtered, ok := colData["uast_a"].(nodes.Array)
+	suite.Require().True(ok)
+	suite.Len(filtered, 2)
+
+	protobufs, err := service.UnmarshalNodes(colData["__uast_a-protobufs"].([]byte))
+	suite.NoError(err)
+	suite.Len(protobufs, 2)
+
+	suite.EqualValues(common.UASTMarshaled, colData["__uast_b-protobufs"])
+
+	_, err = columnsData(columnNames, columnTypes, columnValsPtr, uastutil.JSON,
+		map[string]string{"uast_a": "//["}, QueryOptions{})
+	suite.Error(err)
+}
+
+func (suite *QuerySuite) TestTruncateCell() {
+	cells := memstore.New(memstore.Options{})
+	opts := QueryOptions{Cells: cells, CellMaxBytes: 5}
+
+	suite.Equal("short", truncateCell("short", opts))
+	suite.Equal("long text", truncateCell("long text", QueryOptions{CellMaxBytes: 5}))
+	suite.Equal("long text", truncateCell("long text", QueryOptions{Cells: cells}))
+
+	cell, ok := truncateCell("long text", opts).(truncatedCell)
+	suite.Require().True(ok)
+	suite.True(cell.Truncated)
+	suite.Equal(9, cell.Size)
+	suite.Equal("long ", cell.Value)
+
+	full, ok := cells.Get(cell.Cell)
+	suite.True(ok)
+	suite.Equal("long text", full)
+
+	// multi-byte characters are not cut
+	cell = truncateCell("abcdé", opts).(truncatedCell)
+	suite.Equal("abcd", cell.Value)
+	suite.Equal(6, cell.Size)
+}
+
+func (suite *QuerySuite) TestCell() {
+	cells := memstore.New(memstore.Options{})
+	id := cells.Put("full value", 10)
+
+	handler := lg.RequestLogger(suite.logger)(APIHandlerFunc(Cell(cells)))
+
+	testCases := []struct {
+		url  string
+		code int
+	}{
+		{"/cell?id=" + id, http.StatusOK},
+		{"/cell?id=unknown", http.StatusNotFound},
+		{"/cell", http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		suite.T().Run(tc.url, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", tc.url, nil)
+			res := httptest.NewRecorder()
+			handler.ServeHTTP(res, req)
+
+			require.Equal(t, tc.code, res.Code, res.Body.String())
+			if tc.code == http.StatusOK {
+				require.JSONEq(t, `{"status": 200, "data": "full value"}`, res.Body.String())
+			}
+		})
+	}
+}
+
+func (suite *QuerySuite) TestQueryBadCellMaxBytes() {
+	json := `{"query": "select * from repositories", "cellMaxBytes": -1}`
+	req, _ := http.NewRequest("POST", "/query", strings.NewReader(json))
+
+	res := httptest.NewRecorder()
+	suite.handler.ServeHTTP(res, req)
+
+	suite.Equal(http.StatusBadRequest, res.Code)
+}
+
+func (suite *QuerySuite) TestQueryCellMaxBytes() {
+	handler := lg.RequestLogger(suite.logger)(APIHandlerFunc(
+		Query(suite.db, QueryOptions{Cells: memstore.New(memstore.Options{})})))
+
+	query := func(body string) string {
+		suite.mock.ExpectQuery("SELECT CONNECTION_ID()").
+			WillReturnRows(sqlmock.NewRows([]string{"Id"}).AddRow(1288))
+		suite.mock.ExpectQuery(`select \* from repositories`).
+			WillReturnRows(sqlmock.NewRows([]string{"a"}).AddRow("long text"))
+
+		req, _ := http.NewRequest("POST", "/query", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+		return res.Body.String()
+	}
+
+	suite.Contains(query(`{"query": "select * from repositories", "cellMaxBytes": 4}`), `"truncated":true`)
+
+	// the override of a request does not change the default of the next ones
+	suite.NotContains(query(`{"query": "select * from repositories"}`), `"truncated"`)
+}
+
+func (suite *QuerySuite) TestQueryBadOutput() {
+	json := `{"query": "select * from repositories", "output": "xml"}`
+	req, _ := http.NewRequest("POST", "/query", strings.NewReader(json))
+
+	res := httptest.NewRecorder()
+	suite.handler.ServeHTTP(res, req)
+
+	suite.Equal(http.StatusBadRequest, res.Code)
+}
+
 func (suite *QuerySuite) TestQueryAbort() {
 	// Ideally we would test that the sql query context is canceled, but
 	// go-sqlmock does not have something like ExpectContextCancellation
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/src-d/gitbase-web/server/handler"
+	"github.com/src-d/gitbase-web/server/service"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -19,7 +20,9 @@ type QueryUast struct {
 
 func TestUastFunctions(t *testing.T) {
 	q := new(QueryUast)
-	q.requestProcessFunc = handler.Query
+	q.requestProcessFunc = func(db service.SQLDB) handler.RequestProcessFunc {
+		return handler.Query(db, handler.QueryOptions{})
+	}
 
 	if !isIntegration() {
 		t.Skip("use the env var GITBASEPG_INTEGRATION_TESTS=true to run this test")
@@ -36,23 +36,14 @@ func APIHandlerFunc(rp RequestProcessFunc) http.HandlerFunc {
 // If the passed error has StatusCode, the http.Response will be returned with the StatusCode of the passed error
 // If the passed error has not StatusCode, the http.Response will be returned as a http.StatusInternalServerError
 func write(w http.ResponseWriter, r *http.Request, response *serializer.Response, err error) {
-	var statusCode int
-
 	// TODO: There should be no ppl calling write from the outside
 	if response == nil {
 		response = serializer.NewEmptyResponse()
 	}
 
-	if err == nil {
-		statusCode = http.StatusOK
-	} else if httpError, ok := err.(serializer.HTTPError); ok {
-		statusCode = httpError.StatusCode()
-		response.Status = statusCode
-		response.Errors = []serializer.HTTPError{httpError}
-	} else {
-		statusCode = http.StatusInternalServerError
-		response.Status = statusCode
-		response.Errors = []serializer.HTTPError{serializer.NewHTTPError(statusCode, http.StatusText(statusCode))}
+	statusCode := http.StatusOK
+	if err != nil {
+		statusCode = setError(response, err)
 	}
 
 	if statusCode >= http.StatusBadRequest {
@@ -72,6 +63,21 @@ func write(w http.ResponseWriter, r *http.Request, response *serializer.Response
 	w.Write(content)
 }
 
+// setError sets the status and errors of the response from the passed error,
+// and returns the status. If the passed error has not StatusCode, the status
+// is http.StatusInternalServerError
+func setError(response *serializer.Response, err error) int {
+	httpError, ok := err.(serializer.HTTPError)
+	if !ok {
+		httpError = serializer.NewHTTPError(http.StatusInternalServerError,
+			http.StatusText(http.StatusInternalServerError))
+	}
+
+	response.Status = httpError.StatusCode()
+	response.Errors = []serializer.HTTPError{httpError}
+	return response.Status
+}
+
 // urlParamInt returns the url parameter from an http.Request object. If the
 // param cannot be converted to int, it returns a serializer.NewHTTPError
 func urlParamInt(r *http.Request, key string) (int, error) {
@@ -0,0 +1,394 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/src-d/gitbase-web/server/serializer"
+	"github.com/src-d/gitbase-web/server/service"
+
+	"github.com/go-chi/chi"
+	enry "gopkg.in/src-d/enry.v1"
+)
+
+const (
+	defaultBrowseLimit = 100
+	maxBrowseLimit     = 1000
+)
+
+// page is a page of a listing, read from the offset and limit query
+// parameters
+type page struct {
+	offset int
+	limit  int
+}
+
+// newPage returns the page requested with the query parameters
+func newPage(params url.Values) (page, error) {
+	p := page{limit: defaultBrowseLimit}
+	err := parseIntParams(params, []intParam{
+		{"offset", &p.offset, 0, 0},
+		{"limit", &p.limit, 1, maxBrowseLimit},
+	})
+
+	return p, err
+}
+
+// sql returns the LIMIT clause of the page. It asks for one more row than
+// the page needs, to tell if there is a next one
+func (p page) sql() string {
+	return fmt.Sprintf(" LIMIT %d OFFSET %d", p.limit+1, p.offset)
+}
+
+// next returns the number of rows of the page, given the n rows returned by
+// its query, and the offset of the next page, or 0 if it is the last one
+func (p page) next(n int) (int, int) {
+	if n > p.limit {
+		return p.limit, p.offset + p.limit
+	}
+
+	return n, 0
+}
+
+// repositoryID returns the repository id of the URL. The ids with slashes
+// must be escaped
+func repositoryID(r *http.Request) (string, error) {
+	id, err := url.PathUnescape(chi.URLParam(r, "id"))
+	if err != nil {
+		return "", serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return id, nil
+}
+
+// ListRepositories returns a function that lists the repositories, with
+// their number of refs and commits
+func ListRepositories(db service.SQLDB) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		p, err := newPage(r.URL.Query())
+		if err != nil {
+			return nil, err
+		}
+
+		rows, err := db.QueryContext(r.Context(),
+			"SELECT repository_id FROM repositories ORDER BY repository_id"+p.sql())
+		if err != nil {
+			return nil, dbError(err)
+		}
+		defer rows.Close()
+
+		var ids []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				return nil, err
+			}
+
+			ids = append(ids, id)
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, dbError(err)
+		}
+
+		n, next := p.next(len(ids))
+		ids = ids[:n]
+
+		repos := make([]service.Repository, len(ids))
+		if len(ids) > 0 {
+			refs, err := countByRepository(r.Context(), db, "refs", ids)
+			if err != nil {
+				return nil, err
+			}
+
+			commits, err := countByRepository(r.Context(), db, "commits", ids)
+			if err != nil {
+				return nil, err
+			}
+
+			for i, id := range ids {
+				repos[i] = service.Repository{ID: id, Refs: refs[id], Commits: commits[id]}
+			}
+		}
+
+		return serializer.NewRepositoriesResponse(repos, p.offset, next), nil
+	}
+}
+
+// countByRepository returns the number of rows of the table for each one of
+// the repositories. The repositories are queried in batches of maxInValues
+func countByRepository(
+	ctx context.Context,
+	db service.SQLDB,
+	table string,
+	ids []string,
+) (map[string]int, error) {
+	counts := make(map[string]int, len(ids))
+	err := inBatches(ids, func(batch []string) error {
+		marks, args := inArgs(batch)
+		rows, err := db.QueryContext(ctx,
+			"SELECT repository_id, COUNT(*) FROM "+table+
+				" WHERE repository_id IN ("+marks+") GROUP BY repository_id", args...)
+		if err != nil {
+			return dbError(err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id string
+			var count int
+			if err := rows.Scan(&id, &count); err != nil {
+				return err
+			}
+
+			counts[id] = count
+		}
+
+		if err := rows.Err(); err != nil {
+			return dbError(err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// inArgs returns the placeholders of an IN list with the values, and the
+// values as query arguments
+func inArgs(values []string) (string, []interface{}) {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+
+	return strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", "), args
+}
+
+// maxInValues is the maximum number of values of the IN lists. The longer
+// lists are queried in batches
+const maxInValues = 100
+
+// inBatches calls fn with consecutive batches of at most maxInValues of the
+// values, until it returns an error
+func inBatches(values []string, fn func(batch []string) error) error {
+	for len(values) > 0 {
+		n := len(values)
+		if n > maxInValues {
+			n = maxInValues
+		}
+
+		if err := fn(values[:n]); err != nil {
+			return err
+		}
+
+		values = values[n:]
+	}
+
+	return nil
+}
+
+// ListRefs returns a function that lists the references of a repository
+func ListRefs(db service.SQLDB) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		repo, err := repositoryID(r)
+		if err != nil {
+			return nil, err
+		}
+
+		p, err := newPage(r.URL.Query())
+		if err != nil {
+			return nil, err
+		}
+
+		rows, err := db.QueryContext(r.Context(),
+			"SELECT ref_name, commit_hash, is_tag(ref_name), is_remote(ref_name)"+
+				" FROM refs WHERE repository_id = ? ORDER BY ref_name"+p.sql(), repo)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		defer rows.Close()
+
+		refs := []service.Ref{}
+		for rows.Next() {
+			var ref service.Ref
+			if err := rows.Scan(&ref.Name, &ref.Hash, &ref.Tag, &ref.Remote); err != nil {
+				return nil, err
+			}
+
+			refs = append(refs, ref)
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, dbError(err)
+		}
+
+		n, next := p.next(len(refs))
+
+		return serializer.NewRefsResponse(refs[:n], p.offset, next), nil
+	}
+}
+
+// GetTree returns a function that lists the entries of a directory in a
+// reference of a repository. The ref parameter is HEAD by default, and the
+// path parameter is the root directory by default
+func GetTree(db service.SQLDB) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		repo, err := repositoryID(r)
+		if err != nil {
+			return nil, err
+		}
+
+		params := r.URL.Query()
+		p, err := newPage(params)
+		if err != nil {
+			return nil, err
+		}
+
+		dir := cleanPath(params.Get("path"))
+		tree, err := treeHash(r.Context(), db, repo, refParam(params), dir)
+		if err != nil {
+			return nil, err
+		}
+
+		rows, err := db.QueryContext(r.Context(),
+			"SELECT tree_entry_name, blob_hash, tree_entry_mode FROM tree_entries"+
+				" WHERE repository_id = ? AND tree_hash = ? ORDER BY tree_entry_name"+p.sql(),
+			repo, tree)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		defer rows.Close()
+
+		entries := []service.TreeEntry{}
+		for rows.Next() {
+			var e service.TreeEntry
+			if err := rows.Scan(&e.Name, &e.Hash, &e.Mode); err != nil {
+				return nil, err
+			}
+
+			e.Path = path.Join(dir, e.Name)
+			e.Type = service.EntryType(e.Mode)
+			entries = append(entries, e)
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, dbError(err)
+		}
+
+		n, next := p.next(len(entries))
+
+		return serializer.NewTreeResponse(entries[:n], p.offset, next), nil
+	}
+}
+
+// GetFile returns a function that returns a file in a reference of a
+// repository, with its detected language. The ref parameter is HEAD by
+// default
+func GetFile(db service.SQLDB) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		repo, err := repositoryID(r)
+		if err != nil {
+			return nil, err
+		}
+
+		params := r.URL.Query()
+		filePath := cleanPath(params.Get("path"))
+		if filePath == "" {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				`Bad Request. The file "path" is required`)
+		}
+
+		ref := refParam(params)
+		var file service.File
+		var content string
+		err = db.QueryRowContext(r.Context(),
+			"SELECT file_path, blob_hash, blob_size, blob_content"+
+				" FROM refs NATURAL JOIN commit_files NATURAL JOIN files"+
+				" WHERE repository_id = ? AND ref_name = ? AND file_path = ?",
+			repo, ref, filePath).Scan(&file.Path, &file.Hash, &file.Size, &content)
+		if err == sql.ErrNoRows {
+			return nil, serializer.NewHTTPError(http.StatusNotFound,
+				fmt.Sprintf("File %q not found in the reference %q of the repository %q",
+					filePath, ref, repo))
+		}
+		if err != nil {
+			return nil, dbError(err)
+		}
+
+		file.Binary = enry.IsBinary([]byte(content))
+		if !file.Binary {
+			file.Content = content
+			file.Language = enry.GetLanguage(path.Base(filePath), []byte(content))
+		}
+
+		return serializer.NewFileResponse(file), nil
+	}
+}
+
+// refParam returns the ref query parameter, HEAD by default
+func refParam(params url.Values) string {
+	if ref := params.Get("ref"); ref != "" {
+		return ref
+	}
+
+	return "HEAD"
+}
+
+// cleanPath returns the path relative to the root of the tree, without
+// leading or trailing slashes
+func cleanPath(p string) string {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "." {
+		return ""
+	}
+
+	return p
+}
+
+// treeHash returns the hash of the tree of a directory in the commit of a
+// reference. The empty path is the root directory
+func treeHash(ctx context.Context, db service.SQLDB, repo, ref, dir string) (string, error) {
+	var hash string
+	err := db.QueryRowContext(ctx,
+		"SELECT tree_hash FROM refs NATURAL JOIN commits"+
+			" WHERE repository_id = ? AND ref_name = ?", repo, ref).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", serializer.NewHTTPError(http.StatusNotFound,
+			fmt.Sprintf("Reference %q not found in the repository %q", ref, repo))
+	}
+	if err != nil {
+		return "", dbError(err)
+	}
+
+	if dir == "" {
+		return hash, nil
+	}
+
+	// each directory is found in the tree of its parent
+	for _, name := range strings.Split(dir, "/") {
+		var mode string
+		err := db.QueryRowContext(ctx,
+			"SELECT blob_hash, tree_entry_mode FROM tree_entries"+
+				" WHERE repository_id = ? AND tree_hash = ? AND tree_entry_name = ?",
+			repo, hash, name).Scan(&hash, &mode)
+		if err == sql.ErrNoRows || (err == nil && service.EntryType(mode) != service.EntryDir) {
+			return "", serializer.NewHTTPError(http.StatusNotFound,
+				fmt.Sprintf("Directory %q not found in the reference %q of the repository %q",
+					dir, ref, repo))
+		}
+		if err != nil {
+			return "", dbError(err)
+		}
+	}
+
+	return hash, nil
+}
@@ -0,0 +1,218 @@
+package handler_test
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/src-d/gitbase-web/server/handler"
+	"github.com/src-d/gitbase-web/server/service"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/suite"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+type ReposSuite struct {
+	handler.RouterSuite
+}
+
+type pageMeta struct {
+	Offset int `json:"offset"`
+	Next   int `json:"next"`
+}
+
+func TestReposSuite(t *testing.T) {
+	suite.Run(t, &ReposSuite{handler.RouterSuite{
+		Routes: func(r chi.Router, db service.SQLDB) {
+			r.Get("/repos", handler.APIHandlerFunc(handler.ListRepositories(db)))
+			r.Get("/repos/{id}/refs", handler.APIHandlerFunc(handler.ListRefs(db)))
+			r.Get("/repos/{id}/tree", handler.APIHandlerFunc(handler.GetTree(db)))
+			r.Get("/repos/{id}/file", handler.APIHandlerFunc(handler.GetFile(db)))
+		},
+	}})
+}
+
+func (suite *ReposSuite) TestListRepositories() {
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT repository_id FROM repositories ORDER BY repository_id LIMIT 3 OFFSET 2`)).
+		WillReturnRows(sqlmock.NewRows([]string{"repository_id"}).
+			AddRow("a").AddRow("b").AddRow("c"))
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT repository_id, COUNT(*) FROM refs WHERE repository_id IN (?, ?) GROUP BY repository_id`)).
+		WithArgs("a", "b").
+		WillReturnRows(sqlmock.NewRows([]string{"repository_id", "count"}).
+			AddRow("a", 3).AddRow("b", 1))
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT repository_id, COUNT(*) FROM commits WHERE repository_id IN (?, ?) GROUP BY repository_id`)).
+		WithArgs("a", "b").
+		WillReturnRows(sqlmock.NewRows([]string{"repository_id", "count"}).
+			AddRow("a", 42))
+
+	var repos []service.Repository
+	var meta pageMeta
+	res := suite.Get("/repos?offset=2&limit=2", &repos, &meta)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	suite.Equal([]service.Repository{
+		{ID: "a", Refs: 3, Commits: 42},
+		{ID: "b", Refs: 1, Commits: 0},
+	}, repos)
+	suite.Equal(pageMeta{Offset: 2, Next: 4}, meta)
+}
+
+func (suite *ReposSuite) TestListRepositoriesBatches() {
+	rows := sqlmock.NewRows([]string{"repository_id"})
+	for i := 0; i < 150; i++ {
+		rows.AddRow(fmt.Sprintf("r%03d", i))
+	}
+
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT repository_id FROM repositories ORDER BY repository_id LIMIT 151 OFFSET 0`)).
+		WillReturnRows(rows)
+
+	// the counts are queried in batches of 100 repositories
+	for _, table := range []string{"refs", "commits"} {
+		for _, batch := range [][2]int{{0, 100}, {100, 150}} {
+			suite.Mock.ExpectQuery(regexp.QuoteMeta(
+				`SELECT repository_id, COUNT(*) FROM ` + table + ` WHERE repository_id IN (?` +
+					strings.Repeat(", ?", batch[1]-batch[0]-1) + `) GROUP BY repository_id`)).
+				WillReturnRows(sqlmock.NewRows([]string{"repository_id", "count"}).
+					AddRow(fmt.Sprintf("r%03d", batch[0]), 1))
+		}
+	}
+
+	var repos []service.Repository
+	res := suite.Get("/repos?limit=150", &repos, nil)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	suite.Require().Len(repos, 150)
+	suite.Equal(service.Repository{ID: "r000", Refs: 1, Commits: 1}, repos[0])
+	suite.Equal(service.Repository{ID: "r099", Refs: 0, Commits: 0}, repos[99])
+	suite.Equal(service.Repository{ID: "r100", Refs: 1, Commits: 1}, repos[100])
+}
+
+func (suite *ReposSuite) TestListRefs() {
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT ref_name, commit_hash, is_tag(ref_name), is_remote(ref_name)` +
+			` FROM refs WHERE repository_id = ? ORDER BY ref_name LIMIT 101 OFFSET 0`)).
+		WithArgs("github.com/src-d/gitbase").
+		WillReturnRows(sqlmock.NewRows([]string{"ref_name", "commit_hash", "tag", "remote"}).
+			AddRow("HEAD", "abc", false, false).
+			AddRow("refs/tags/v1", "def", true, false))
+
+	var refs []service.Ref
+	var meta pageMeta
+	res := suite.Get("/repos/github.com%2Fsrc-d%2Fgitbase/refs", &refs, &meta)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	suite.Equal([]service.Ref{
+		{Name: "HEAD", Hash: "abc"},
+		{Name: "refs/tags/v1", Hash: "def", Tag: true},
+	}, refs)
+	suite.Equal(pageMeta{}, meta)
+}
+
+func (suite *ReposSuite) TestGetTree() {
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT tree_hash FROM refs NATURAL JOIN commits WHERE repository_id = ? AND ref_name = ?`)).
+		WithArgs("repo", "refs/heads/dev").
+		WillReturnRows(sqlmock.NewRows([]string{"tree_hash"}).AddRow("root"))
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT blob_hash, tree_entry_mode FROM tree_entries`+
+			` WHERE repository_id = ? AND tree_hash = ? AND tree_entry_name = ?`)).
+		WithArgs("repo", "root", "server").
+		WillReturnRows(sqlmock.NewRows([]string{"blob_hash", "tree_entry_mode"}).
+			AddRow("server-tree", "40000"))
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT tree_entry_name, blob_hash, tree_entry_mode FROM tree_entries`+
+			` WHERE repository_id = ? AND tree_hash = ? ORDER BY tree_entry_name LIMIT 101 OFFSET 0`)).
+		WithArgs("repo", "server-tree").
+		WillReturnRows(sqlmock.NewRows([]string{"tree_entry_name", "blob_hash", "tree_entry_mode"}).
+			AddRow("handler", "h", "40000").
+			AddRow("router.go", "r", "100644"))
+
+	var entries []service.TreeEntry
+	res := suite.Get("/repos/repo/tree?ref=refs/heads/dev&path=/server/", &entries, nil)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	suite.Equal([]service.TreeEntry{
+		{Name: "handler", Path: "server/handler", Hash: "h", Mode: "40000", Type: service.EntryDir},
+		{Name: "router.go", Path: "server/router.go", Hash: "r", Mode: "100644", Type: service.EntryFile},
+	}, entries)
+}
+
+func (suite *ReposSuite) TestGetTreeNotFound() {
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT tree_hash FROM refs NATURAL JOIN commits WHERE repository_id = ? AND ref_name = ?`)).
+		WithArgs("repo", "HEAD").
+		WillReturnRows(sqlmock.NewRows([]string{"tree_hash"}).AddRow("root"))
+	suite.Mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT blob_hash, tree_entry_mode FROM tree_entries`+
+			` WHERE repository_id = ? AND tree_hash = ? AND tree_entry_name = ?`)).
+		WithArgs("repo", "root", "README.md").
+		WillReturnRows(sqlmock.NewRows([]string{"blob_hash", "tree_entry_mode"}).
+			AddRow("readme", "100644"))
+
+	res := suite.Get("/repos/repo/tree?path=README.md", nil, nil)
+	suite.Equal(http.StatusNotFound, res.Code, res.Body.String())
+}
+
+func (suite *ReposSuite) TestGetFile() {
+	query := regexp.QuoteMeta(`SELECT file_path, blob_hash, blob_size, blob_content` +
+		` FROM refs NATURAL JOIN commit_files NATURAL JOIN files` +
+		` WHERE repository_id = ? AND ref_name = ? AND file_path = ?`)
+	columns := []string{"file_path", "blob_hash", "blob_size", "blob_content"}
+
+	suite.Mock.ExpectQuery(query).
+		WithArgs("repo", "HEAD", "server/router.go").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("server/router.go", "r", 13, "package main\n"))
+	suite.Mock.ExpectQuery(query).
+		WithArgs("repo", "HEAD", "logo.png").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("logo.png", "l", 4, "\x89PNG\x00"))
+	suite.Mock.ExpectQuery(query).
+		WithArgs("repo", "HEAD", "missing.go").
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	var file service.File
+	res := suite.Get("/repos/repo/file?path=server/router.go", &file, nil)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+	suite.Equal(service.File{
+		Path:     "server/router.go",
+		Hash:     "r",
+		Size:     13,
+		Language: "Go",
+		Content:  "package main\n",
+	}, file)
+
+	file = service.File{}
+	res = suite.Get("/repos/repo/file?path=logo.png", &file, nil)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+	suite.True(file.Binary)
+	suite.Empty(file.Content)
+
+	res = suite.Get("/repos/repo/file?path=missing.go", nil, nil)
+	suite.Equal(http.StatusNotFound, res.Code, res.Body.String())
+
+	res = suite.Get("/repos/repo/file", nil, nil)
+	suite.Equal(http.StatusBadRequest, res.Code, res.Body.String())
+}
+
+func (suite *ReposSuite) TestBadPage() {
+	testCases := []string{
+		"/repos?offset=-1",
+		"/repos?limit=0",
+		"/repos/repo/refs?limit=1001",
+	}
+
+	for _, tc := range testCases {
+		suite.T().Run(tc, func(t *testing.T) {
+			res := suite.Get(tc, nil, nil)
+			suite.Equal(http.StatusBadRequest, res.Code, res.Body.String())
+		})
+	}
+}
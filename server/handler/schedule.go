@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+
+	"github.com/src-d/gitbase-web/server/scheduler"
+	"github.com/src-d/gitbase-web/server/serializer"
+	"github.com/src-d/gitbase-web/server/service"
+)
+
+// maxScheduleBodyBytes is the maximum size of the body of a request to add a
+// scheduled query
+const maxScheduleBodyBytes = 1 << 20
+
+// ScheduledQuery returns a scheduler.QueryFunc that runs the queries in the
+// same way as the /query endpoint, without the default LIMIT. The snapshots
+// are truncated when they reach the limits, like the /export files
+func ScheduledQuery(db service.SQLDB, limits ExportLimits) scheduler.QueryFunc {
+	return func(ctx context.Context, query string) (*scheduler.Snapshot, error) {
+		req := queryRequest{Query: query}
+		if limits.MaxRows > 0 {
+			// ask for one more row, to know if the results are truncated
+			req.Limit = limits.MaxRows + 1
+		}
+
+		res, err := runQuery(ctx, db, req, QueryOptions{limits: limits})
+		if err != nil {
+			return nil, err
+		}
+
+		return &scheduler.Snapshot{
+			Headers:   res.columnNames,
+			Types:     res.columnTypes,
+			Rows:      res.rows,
+			Truncated: res.truncated,
+		}, nil
+	}
+}
+
+type scheduleRequest struct {
+	Name    string `json:"name"`
+	Query   string `json:"query"`
+	Cron    string `json:"cron"`
+	Timeout int    `json:"timeout"`
+	MaxRuns int    `json:"maxRuns"`
+}
+
+// ListSchedules returns a function that lists the scheduled queries
+func ListSchedules(s *scheduler.Scheduler) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		return serializer.NewSchedulesResponse(s.Schedules()), nil
+	}
+}
+
+// CreateSchedule returns a function that adds a new scheduled query
+func CreateSchedule(s *scheduler.Scheduler) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		var req scheduleRequest
+		body, err := readBody(r, maxScheduleBodyBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		sched, err := s.Add(scheduler.Schedule{
+			Name:    req.Name,
+			Query:   req.Query,
+			Cron:    req.Cron,
+			Timeout: req.Timeout,
+			MaxRuns: req.MaxRuns,
+		})
+		if err != nil {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		return serializer.NewScheduleResponse(sched), nil
+	}
+}
+
+// DeleteSchedule returns a function that removes a scheduled query and its
+// snapshots
+func DeleteSchedule(s *scheduler.Scheduler) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		sched, err := s.Remove(chi.URLParam(r, "id"))
+		if err != nil {
+			return nil, schedulerError(err)
+		}
+
+		return serializer.NewScheduleResponse(sched), nil
+	}
+}
+
+// ListRuns returns a function that lists the runs of a scheduled query
+func ListRuns(s *scheduler.Scheduler) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		runs, err := s.Runs(chi.URLParam(r, "id"))
+		if err != nil {
+			return nil, schedulerError(err)
+		}
+
+		return serializer.NewRunsResponse(runs), nil
+	}
+}
+
+// GetSnapshot returns a function that returns the snapshot stored by a run of
+// a scheduled query
+func GetSnapshot(s *scheduler.Scheduler) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		snapshot, err := s.Snapshot(chi.URLParam(r, "id"), chi.URLParam(r, "run"))
+		if err != nil {
+			return nil, schedulerError(err)
+		}
+
+		return serializer.NewSnapshotResponse(snapshot), nil
+	}
+}
+
+// CompareSnapshots returns a function that compares the snapshots of two runs
+// of a scheduled query
+func CompareSnapshots(s *scheduler.Scheduler) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		params := r.URL.Query()
+
+		from, to := params.Get("from"), params.Get("to")
+		if from == "" || to == "" {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				`Bad Request. The "from" and "to" run IDs are required`)
+		}
+
+		var keys []string
+		for _, key := range params["key"] {
+			keys = append(keys, strings.Split(key, ",")...)
+		}
+
+		diff, err := s.Compare(chi.URLParam(r, "id"), from, to, keys)
+		if err != nil {
+			return nil, schedulerError(err)
+		}
+
+		return serializer.NewSnapshotDiffResponse(diff), nil
+	}
+}
+
+// schedulerError transforms scheduler errors to HTTP errors
+func schedulerError(err error) error {
+	switch {
+	case scheduler.ErrNotFound.Is(err):
+		return serializer.NewHTTPError(http.StatusNotFound, err.Error())
+	case scheduler.ErrInvalidKey.Is(err):
+		return serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+	default:
+		return err
+	}
+}
@@ -0,0 +1,179 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/src-d/gitbase-web/server/handler"
+	"github.com/src-d/gitbase-web/server/scheduler"
+
+	"github.com/go-chi/chi"
+	"github.com/pressly/lg"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+type ScheduleSuite struct {
+	suite.Suite
+	dir    string
+	sched  *scheduler.Scheduler
+	router http.Handler
+}
+
+func TestScheduleSuite(t *testing.T) {
+	suite.Run(t, new(ScheduleSuite))
+}
+
+func (suite *ScheduleSuite) SetupTest() {
+	var err error
+	suite.dir, err = ioutil.TempDir("", "schedule-test")
+	suite.Require().NoError(err)
+
+	store, err := scheduler.NewStore(suite.dir)
+	suite.Require().NoError(err)
+
+	query := func(ctx context.Context, query string) (*scheduler.Snapshot, error) {
+		return &scheduler.Snapshot{}, nil
+	}
+
+	suite.sched, err = scheduler.New(store, query, scheduler.Options{Timeout: time.Minute})
+	suite.Require().NoError(err)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+
+	r := chi.NewRouter()
+	r.Use(lg.RequestLogger(logger))
+	r.Get("/schedules", handler.APIHandlerFunc(handler.ListSchedules(suite.sched)))
+	r.Post("/schedules", handler.APIHandlerFunc(handler.CreateSchedule(suite.sched)))
+	r.Delete("/schedules/{id}", handler.APIHandlerFunc(handler.DeleteSchedule(suite.sched)))
+	r.Get("/schedules/{id}/runs", handler.APIHandlerFunc(handler.ListRuns(suite.sched)))
+	r.Get("/schedules/{id}/runs/{run}", handler.APIHandlerFunc(handler.GetSnapshot(suite.sched)))
+	r.Get("/schedules/{id}/compare", handler.APIHandlerFunc(handler.CompareSnapshots(suite.sched)))
+	suite.router = r
+}
+
+func (suite *ScheduleSuite) TearDownTest() {
+	os.RemoveAll(suite.dir)
+}
+
+func (suite *ScheduleSuite) do(method, url, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	res := httptest.NewRecorder()
+	suite.router.ServeHTTP(res, req)
+
+	return res
+}
+
+func (suite *ScheduleSuite) TestCreateListDelete() {
+	require := suite.Require()
+
+	res := suite.do("POST", "/schedules",
+		`{"name": "todos", "query": "SELECT 1", "cron": "0 9 * * *"}`)
+	require.Equal(http.StatusOK, res.Code, res.Body.String())
+
+	var created struct {
+		Data scheduler.Schedule `json:"data"`
+	}
+	require.NoError(json.Unmarshal(res.Body.Bytes(), &created))
+	require.NotEmpty(created.Data.ID)
+	require.Equal("todos", created.Data.Name)
+
+	res = suite.do("GET", "/schedules", "")
+	require.Equal(http.StatusOK, res.Code, res.Body.String())
+
+	var list struct {
+		Data []scheduler.ScheduleInfo `json:"data"`
+	}
+	require.NoError(json.Unmarshal(res.Body.Bytes(), &list))
+	require.Len(list.Data, 1)
+	require.Equal(created.Data.ID, list.Data[0].ID)
+	require.NotNil(list.Data[0].Next)
+
+	res = suite.do("GET", "/schedules/"+created.Data.ID+"/runs", "")
+	require.Equal(http.StatusOK, res.Code, res.Body.String())
+
+	res = suite.do("DELETE", "/schedules/"+created.Data.ID, "")
+	require.Equal(http.StatusOK, res.Code, res.Body.String())
+
+	res = suite.do("DELETE", "/schedules/"+created.Data.ID, "")
+	require.Equal(http.StatusNotFound, res.Code, res.Body.String())
+}
+
+func (suite *ScheduleSuite) TestBadRequest() {
+	testCases := []string{
+		`{"query": "SELECT 1", "cron": "wrong"}`,
+		`{"query": "", "cron": "* * * * *"}`,
+		`{"query": "SELECT 1", "cron": "* * * * *", "timeout": -1}`,
+		`{"query": "SELECT 1", "cron": "* * * * *", "maxRuns": -1}`,
+		`{"query": 1}`,
+	}
+
+	for _, tc := range testCases {
+		suite.T().Run(tc, func(t *testing.T) {
+			res := suite.do("POST", "/schedules", tc)
+			suite.Equal(http.StatusBadRequest, res.Code, res.Body.String())
+		})
+	}
+}
+
+func (suite *ScheduleSuite) TestBodyTooLarge() {
+	res := suite.do("POST", "/schedules",
+		`{"query": "`+strings.Repeat("a", 2<<20)+`", "cron": "* * * * *"}`)
+	suite.Equal(http.StatusRequestEntityTooLarge, res.Code)
+}
+
+func (suite *ScheduleSuite) TestScheduledQueryLimits() {
+	require := suite.Require()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(err)
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"repository_id"}).
+		AddRow("a").
+		AddRow("b").
+		AddRow("c")
+	mock.ExpectQuery("SELECT CONNECTION_ID()").
+		WillReturnRows(sqlmock.NewRows([]string{"Id"}).AddRow(1288))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT repository_id FROM repositories LIMIT 3")).
+		WillReturnRows(rows)
+
+	query := handler.ScheduledQuery(db, handler.ExportLimits{MaxRows: 2})
+	snapshot, err := query(context.Background(), "SELECT repository_id FROM repositories")
+	require.NoError(err)
+	require.Len(snapshot.Rows, 2)
+	require.Equal("the results reached the maximum of 2 rows", snapshot.Truncated)
+	require.NoError(mock.ExpectationsWereMet())
+}
+
+func (suite *ScheduleSuite) TestNotFound() {
+	sched, err := suite.sched.Add(scheduler.Schedule{Query: "SELECT 1", Cron: "* * * * *"})
+	suite.Require().NoError(err)
+
+	testCases := []string{
+		"/schedules/nope/runs",
+		"/schedules/nope/runs/20181010T1000Z",
+		"/schedules/" + sched.ID + "/runs/20181010T1000Z",
+		"/schedules/" + sched.ID + "/compare?from=20181010T1000Z&to=20181010T1001Z",
+	}
+
+	for _, tc := range testCases {
+		suite.T().Run(tc, func(t *testing.T) {
+			res := suite.do("GET", tc, "")
+			suite.Equal(http.StatusNotFound, res.Code, res.Body.String())
+		})
+	}
+
+	res := suite.do("GET", "/schedules/"+sched.ID+"/compare?from=20181010T1000Z", "")
+	suite.Equal(http.StatusBadRequest, res.Code, res.Body.String())
+}
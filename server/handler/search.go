@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/src-d/gitbase-web/server/serializer"
+	"github.com/src-d/gitbase-web/server/service"
+
+	enry "gopkg.in/src-d/enry.v1"
+)
+
+const (
+	defaultSearchLimit   = 50
+	defaultSearchContext = 2
+	maxSearchContext     = 10
+)
+
+// SearchLimits caps the work done by each code search. A value of 0 means no
+// limit
+type SearchLimits struct {
+	// MaxMatches is the maximum number of matches found by a search,
+	// including the ones skipped with the offset
+	MaxMatches int
+}
+
+// want returns the number of matches a search needs to find for the page.
+// One more match than the page needs tells if there is a next one
+func (l SearchLimits) want(offset, limit int) int {
+	want := offset + limit + 1
+	if l.MaxMatches > 0 && want > l.MaxMatches {
+		want = l.MaxMatches
+	}
+
+	return want
+}
+
+// searchPage returns the bounds of the page of matches, and the offset of
+// the next page, or 0 if it is the last one
+func searchPage(n, offset, limit int) (start, end, next int) {
+	start, end = offset, offset+limit
+	if start > n {
+		start = n
+	}
+	if end > n {
+		end = n
+	}
+
+	if n > offset+limit {
+		next = offset + limit
+	}
+
+	return start, end, next
+}
+
+// searchRequest is a code search, read from the query parameters
+type searchRequest struct {
+	re         *regexp.Regexp
+	lang       string
+	repo       string
+	ref        string
+	context    int
+	offset     int
+	limit      int
+	ignoreCase bool
+}
+
+// Search returns a function that searches a text or a regular expression in
+// the files of the repositories, and returns the matching lines. The files
+// are selected by gitbase, and the lines are found by the server
+func Search(db service.SQLDB, limits SearchLimits) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		req, err := newSearchRequest(r.URL.Query())
+		if err != nil {
+			return nil, err
+		}
+
+		want := limits.want(req.offset, req.limit)
+
+		// the query has no LIMIT, since the matches are found by the server.
+		// It is canceled once they are found, instead of reading the rest of
+		// its rows on Close
+		ctx, cancel := context.WithCancel(r.Context())
+		query, args := searchQuery(req)
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err != nil {
+			cancel()
+			return nil, dbError(err)
+		}
+		defer rows.Close()
+		defer cancel()
+
+		matches := []service.SearchMatch{}
+		for len(matches) < want && rows.Next() {
+			var repo, path, content string
+			if err := rows.Scan(&repo, &path, &content); err != nil {
+				return nil, err
+			}
+
+			if enry.IsBinary([]byte(content)) {
+				continue
+			}
+
+			found := service.FindLineMatches(content, req.re, req.context, want-len(matches))
+			for _, m := range found {
+				m.Repository = repo
+				m.Path = path
+				matches = append(matches, m)
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, dbError(err)
+		}
+
+		start, end, next := searchPage(len(matches), req.offset, req.limit)
+		truncated := limits.MaxMatches > 0 && len(matches) >= limits.MaxMatches
+
+		return serializer.NewSearchResponse(matches[start:end], req.offset, next, truncated), nil
+	}
+}
+
+// newSearchRequest returns the search requested with the query parameters
+func newSearchRequest(params url.Values) (*searchRequest, error) {
+	q, expr := params.Get("q"), params.Get("regex")
+	if (q == "") == (expr == "") {
+		return nil, serializer.NewHTTPError(http.StatusBadRequest,
+			`Bad Request. The search needs one of "q" or "regex"`)
+	}
+
+	if q != "" {
+		expr = regexp.QuoteMeta(q)
+	}
+
+	req := &searchRequest{
+		lang:       params.Get("lang"),
+		repo:       params.Get("repo"),
+		ref:        params.Get("ref"),
+		context:    defaultSearchContext,
+		limit:      defaultSearchLimit,
+		ignoreCase: params.Get("ignoreCase") == "true",
+	}
+
+	if req.ref == "" {
+		req.ref = "HEAD"
+	}
+
+	if req.ignoreCase {
+		expr = "(?i)" + expr
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, serializer.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf(`Bad Request. Invalid "regex": %s`, err))
+	}
+	req.re = re
+
+	err = parseIntParams(params, []intParam{
+		{"context", &req.context, 0, maxSearchContext},
+		{"offset", &req.offset, 0, 0},
+		{"limit", &req.limit, 1, 0},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// intParam is an integer query parameter, with its bounds. A max of 0 means
+// no maximum
+type intParam struct {
+	name string
+	dst  *int
+	min  int
+	max  int
+}
+
+// parseIntParams sets the integer query parameters found in params, and
+// returns a bad request error if any of them is out of its bounds
+func parseIntParams(params url.Values, ints []intParam) error {
+	for _, p := range ints {
+		v := params.Get(p.name)
+		if v == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(v)
+		if err != nil || n < p.min || (p.max > 0 && n > p.max) {
+			msg := fmt.Sprintf(`Bad Request. Invalid %q %q; it must be a number greater than or equal to %d`,
+				p.name, v, p.min)
+			if p.max > 0 {
+				msg += fmt.Sprintf(" and lower than or equal to %d", p.max)
+			}
+
+			return serializer.NewHTTPError(http.StatusBadRequest, msg)
+		}
+
+		*p.dst = n
+	}
+
+	return nil
+}
+
+// searchQuery returns the gitbase query that selects the files of the
+// search scope with any match, and its arguments
+func searchQuery(req *searchRequest) (string, []interface{}) {
+	conds := []string{"ref_name = ?", "blob_content REGEXP ?"}
+	args := []interface{}{req.ref, req.re.String()}
+
+	if req.repo != "" {
+		conds = append(conds, "repository_id = ?")
+		args = append(args, req.repo)
+	}
+
+	if req.lang != "" {
+		conds = append(conds, "LOWER(LANGUAGE(file_path, blob_content)) = ?")
+		args = append(args, strings.ToLower(req.lang))
+	}
+
+	return "SELECT repository_id, file_path, blob_content" +
+		" FROM refs NATURAL JOIN commit_files NATURAL JOIN files" +
+		" WHERE " + strings.Join(conds, " AND ") +
+		" ORDER BY repository_id, file_path", args
+}
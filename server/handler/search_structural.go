@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/src-d/gitbase-web/server/serializer"
+	"github.com/src-d/gitbase-web/server/service"
+	"github.com/src-d/gitbase-web/server/uastutil"
+
+	"gopkg.in/bblfsh/sdk.v2/uast"
+	"gopkg.in/bblfsh/sdk.v2/uast/nodes"
+	enry "gopkg.in/src-d/enry.v1"
+)
+
+const (
+	// maxSnippetLines is the maximum number of lines of the snippet returned
+	// for each structural search match
+	maxSnippetLines = 10
+
+	// maxStructuralSearchBodyBytes is the maximum size of the body of a
+	// structural search request
+	maxStructuralSearchBodyBytes = 1 << 20
+)
+
+type structuralSearchRequest struct {
+	XPath      string `json:"xpath"`
+	Language   string `json:"language"`
+	Repository string `json:"repository"`
+	Ref        string `json:"ref"`
+	Offset     int    `json:"offset"`
+	Limit      int    `json:"limit"`
+}
+
+// StructuralSearch returns a function that searches the UAST nodes that match
+// an xpath query in the files of a language. gitbase parses the files of the
+// reference with bblfsh and applies the query. The language can be any enry
+// name or alias of a language supported by the bblfsh drivers
+func StructuralSearch(db service.SQLDB, limits SearchLimits, languages *LanguagesCache) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		var req structuralSearchRequest
+		body, err := readBody(r, maxStructuralSearchBodyBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		if req.XPath == "" || req.Language == "" {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				`Bad Request. The search needs an "xpath" and a "language"`)
+		}
+
+		if req.Offset < 0 || req.Limit < 0 {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				`Bad Request. "offset" and "limit" can not be negative`)
+		}
+
+		if req.Ref == "" {
+			req.Ref = "HEAD"
+		}
+
+		if req.Limit == 0 {
+			req.Limit = defaultSearchLimit
+		}
+
+		name, ok := enry.GetLanguageByAlias(req.Language)
+		if !ok {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("Bad Request. Unknown language %q", req.Language))
+		}
+
+		id, err := languages.languageID(r.Context(), req.Language)
+		if err != nil {
+			return nil, err
+		}
+
+		if id == "" {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("Bad Request. The language %q is not supported by bblfsh", name))
+		}
+
+		want := limits.want(req.Offset, req.Limit)
+
+		// gitbase parses every file of the rows read, so the query is
+		// canceled once the matches are found, instead of reading the rest
+		// of its rows on Close
+		ctx, cancel := context.WithCancel(r.Context())
+		query, args := structuralSearchQuery(req, name, id)
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err != nil {
+			cancel()
+			return nil, dbError(err)
+		}
+		defer rows.Close()
+		defer cancel()
+
+		matches := []service.StructuralMatch{}
+		for len(matches) < want && rows.Next() {
+			var repo, path, content string
+			var data []byte
+			if err := rows.Scan(&repo, &path, &content, &data); err != nil {
+				return nil, err
+			}
+
+			// the files that bblfsh can not parse have a NULL UAST
+			found, err := service.UnmarshalNodes(data)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, n := range found {
+				obj, ok := n.(nodes.Object)
+				if !ok || len(matches) >= want {
+					continue
+				}
+
+				matches = append(matches, structuralMatch(repo, path, content, obj))
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, dbError(err)
+		}
+
+		start, end, next := searchPage(len(matches), req.Offset, req.Limit)
+		truncated := limits.MaxMatches > 0 && len(matches) >= limits.MaxMatches
+
+		return serializer.NewStructuralSearchResponse(
+			matches[start:end], req.Offset, next, truncated), nil
+	}
+}
+
+// structuralSearchQuery returns the gitbase query that selects the files of
+// the search scope, with the nodes that match the xpath query, and its
+// arguments. The files are selected by the enry name of the language, and
+// parsed with the bblfsh driver id
+func structuralSearchQuery(req structuralSearchRequest, name, id string) (string, []interface{}) {
+	conds := []string{"ref_name = ?", "LANGUAGE(file_path, blob_content) = ?"}
+	args := []interface{}{id, req.XPath, req.Ref, name}
+
+	if req.Repository != "" {
+		conds = append(conds, "repository_id = ?")
+		args = append(args, req.Repository)
+	}
+
+	return "SELECT repository_id, file_path, blob_content," +
+		" uast_xpath(uast(blob_content, ?), ?)" +
+		" FROM refs NATURAL JOIN commit_files NATURAL JOIN files" +
+		" WHERE " + strings.Join(conds, " AND ") +
+		" ORDER BY repository_id, file_path", args
+}
+
+// structuralMatch returns the match of the node in the file
+func structuralMatch(repo, path, content string, obj nodes.Object) service.StructuralMatch {
+	pos := uast.PositionsOf(obj)
+
+	return service.StructuralMatch{
+		Repository: repo,
+		Path:       path,
+		Type:       uast.TypeOf(obj),
+		Token:      uast.TokenOf(obj),
+		Start:      pos.Start(),
+		End:        pos.End(),
+		Snippet:    uastutil.Snippet(content, pos, maxSnippetLines),
+	}
+}
@@ -0,0 +1,179 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/src-d/gitbase-web/server/handler"
+	"github.com/src-d/gitbase-web/server/service"
+
+	"github.com/pressly/lg"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"gopkg.in/bblfsh/sdk.v2/uast"
+	"gopkg.in/bblfsh/sdk.v2/uast/nodes"
+	"gopkg.in/bblfsh/sdk.v2/uast/nodes/nodesproto"
+)
+
+type StructuralSearchSuite struct {
+	suite.Suite
+	mock    sqlmock.Sqlmock
+	handler http.Handler
+}
+
+func TestStructuralSearchSuite(t *testing.T) {
+	suite.Run(t, new(StructuralSearchSuite))
+}
+
+func (suite *StructuralSearchSuite) SetupTest() {
+	db, mock, err := sqlmock.New()
+	suite.Require().NoError(err)
+	suite.mock = mock
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	suite.handler = lg.RequestLogger(logger)(handler.APIHandlerFunc(
+		handler.StructuralSearch(db, handler.SearchLimits{}, handler.NewTestLanguagesCache("go", "csharp"))))
+}
+
+func (suite *StructuralSearchSuite) search(body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/search/structural", strings.NewReader(body))
+	res := httptest.NewRecorder()
+	suite.handler.ServeHTTP(res, req)
+
+	return res
+}
+
+func (suite *StructuralSearchSuite) protobufs(arr nodes.Array) []byte {
+	var buf bytes.Buffer
+	suite.Require().NoError(nodesproto.WriteTo(&buf, arr))
+
+	return buf.Bytes()
+}
+
+func (suite *StructuralSearchSuite) TestMatches() {
+	content := "package main\n\nfunc main() {\n\tlog.Fatal(err)\n}\n"
+
+	call := nodes.Object{
+		uast.KeyType: nodes.String("go:CallExpr"),
+		uast.KeyPos: nodes.Object{
+			uast.KeyType: nodes.String(uast.TypePositions),
+			uast.KeyStart: nodes.Object{
+				uast.KeyType: nodes.String(uast.TypePosition),
+				"offset":     nodes.Uint(29),
+				"line":       nodes.Uint(4),
+				"col":        nodes.Uint(2),
+			},
+			uast.KeyEnd: nodes.Object{
+				uast.KeyType: nodes.String(uast.TypePosition),
+				"offset":     nodes.Uint(43),
+				"line":       nodes.Uint(4),
+				"col":        nodes.Uint(16),
+			},
+		},
+	}
+
+	suite.mock.ExpectQuery(regexp.QuoteMeta(`SELECT repository_id, file_path, blob_content,`+
+		` uast_xpath(uast(blob_content, ?), ?)`+
+		` FROM refs NATURAL JOIN commit_files NATURAL JOIN files`+
+		` WHERE ref_name = ? AND LANGUAGE(file_path, blob_content) = ?`+
+		` AND repository_id = ?`+
+		` ORDER BY repository_id, file_path`)).
+		WithArgs("go", "//go:CallExpr", "HEAD", "Go", "repo").
+		WillReturnRows(sqlmock.NewRows([]string{"repository_id", "file_path", "blob_content", "uast"}).
+			AddRow("repo", "broken.go", "package", nil).
+			AddRow("repo", "main.go", content, suite.protobufs(nodes.Array{call})))
+
+	res := suite.search(`{ "xpath": "//go:CallExpr", "language": "Go", "repository": "repo" }`)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	var resp struct {
+		Data []service.StructuralMatch `json:"data"`
+	}
+	suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &resp))
+	suite.Require().Len(resp.Data, 1)
+
+	m := resp.Data[0]
+	suite.Equal("main.go", m.Path)
+	suite.Equal("go:CallExpr", m.Type)
+	suite.Equal(uast.Position{Offset: 29, Line: 4, Col: 2}, *m.Start)
+	suite.Equal(uast.Position{Offset: 43, Line: 4, Col: 16}, *m.End)
+	suite.Equal("\tlog.Fatal(err)", m.Snippet)
+	suite.NoError(suite.mock.ExpectationsWereMet())
+}
+
+func (suite *StructuralSearchSuite) TestLanguageAlias() {
+	// the files are selected by the enry name, and parsed by the driver id
+	suite.mock.ExpectQuery(regexp.QuoteMeta(`uast_xpath(uast(blob_content, ?), ?)`)).
+		WithArgs("csharp", "//*", "refs/heads/dev", "C#").
+		WillReturnRows(sqlmock.NewRows([]string{"repository_id", "file_path", "blob_content", "uast"}))
+
+	res := suite.search(`{ "xpath": "//*", "language": "C#", "ref": "refs/heads/dev" }`)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+	suite.NoError(suite.mock.ExpectationsWereMet())
+}
+
+func (suite *StructuralSearchSuite) TestCancelRemainingRows() {
+	mockDB, mock, err := sqlmock.New()
+	suite.Require().NoError(err)
+	defer mockDB.Close()
+
+	node := suite.protobufs(nodes.Array{nodes.Object{uast.KeyType: nodes.String("go:File")}})
+	rows := sqlmock.NewRows([]string{"repository_id", "file_path", "blob_content", "uast"})
+	for _, path := range []string{"a.go", "b.go", "c.go", "d.go"} {
+		rows.AddRow("repo", path, "package main", node)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`uast_xpath(uast(blob_content, ?), ?)`)).WillReturnRows(rows)
+
+	db := &ctxDB{SQLDB: mockDB}
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	h := lg.RequestLogger(logger)(handler.APIHandlerFunc(
+		handler.StructuralSearch(db, handler.SearchLimits{}, handler.NewTestLanguagesCache("go"))))
+
+	body := `{ "xpath": "//go:File", "language": "go", "limit": 1 }`
+	req, _ := http.NewRequest("POST", "/search/structural", strings.NewReader(body))
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	var resp struct {
+		Data []service.StructuralMatch `json:"data"`
+	}
+	suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &resp))
+	suite.Require().Len(resp.Data, 1)
+	suite.Equal("a.go", resp.Data[0].Path)
+
+	// the query is canceled instead of parsing the files left
+	suite.Require().NotNil(db.ctx)
+	suite.Equal(context.Canceled, db.ctx.Err())
+}
+
+func (suite *StructuralSearchSuite) TestBodyTooLarge() {
+	res := suite.search(`{ "xpath": "` + strings.Repeat("a", 2<<20) + `", "language": "go" }`)
+	suite.Equal(http.StatusRequestEntityTooLarge, res.Code)
+}
+
+func (suite *StructuralSearchSuite) TestBadRequest() {
+	testCases := []string{
+		`{ "language": "go" }`,
+		`{ "xpath": "//*" }`,
+		`{ "xpath": "//*", "language": "go", "offset": -1 }`,
+		`{ "xpath": "//*", "language": "nope" }`,
+		`{ "xpath": "//*", "language": "Python" }`,
+	}
+
+	for _, tc := range testCases {
+		suite.T().Run(tc, func(t *testing.T) {
+			res := suite.search(tc)
+			suite.Equal(http.StatusBadRequest, res.Code, res.Body.String())
+		})
+	}
+}
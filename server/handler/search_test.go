@@ -0,0 +1,192 @@
+package handler_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/src-d/gitbase-web/server/handler"
+	"github.com/src-d/gitbase-web/server/service"
+
+	"github.com/pressly/lg"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+type SearchSuite struct {
+	suite.Suite
+	mock    sqlmock.Sqlmock
+	handler http.Handler
+}
+
+type searchResponse struct {
+	Status int                   `json:"status"`
+	Data   []service.SearchMatch `json:"data"`
+	Meta   struct {
+		Offset    int  `json:"offset"`
+		Next      int  `json:"next"`
+		Truncated bool `json:"truncated"`
+	} `json:"meta"`
+}
+
+func TestSearchSuite(t *testing.T) {
+	suite.Run(t, new(SearchSuite))
+}
+
+func (suite *SearchSuite) SetupTest() {
+	db, mock, err := sqlmock.New()
+	suite.Require().NoError(err)
+	suite.mock = mock
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	suite.handler = lg.RequestLogger(logger)(handler.APIHandlerFunc(
+		handler.Search(db, handler.SearchLimits{MaxMatches: 5})))
+}
+
+func (suite *SearchSuite) search(params string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", "/search?"+params, nil)
+	res := httptest.NewRecorder()
+	suite.handler.ServeHTTP(res, req)
+
+	return res
+}
+
+func (suite *SearchSuite) expectFiles(query string, files ...[]string) *sqlmock.ExpectedQuery {
+	rows := sqlmock.NewRows([]string{"repository_id", "file_path", "blob_content"})
+	for _, f := range files {
+		rows.AddRow(f[0], f[1], f[2])
+	}
+
+	return suite.mock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(rows)
+}
+
+func (suite *SearchSuite) TestText() {
+	suite.expectFiles(`SELECT repository_id, file_path, blob_content`+
+		` FROM refs NATURAL JOIN commit_files NATURAL JOIN files`+
+		` WHERE ref_name = ? AND blob_content REGEXP ?`+
+		` AND repository_id = ? AND LOWER(LANGUAGE(file_path, blob_content)) = ?`+
+		` ORDER BY repository_id, file_path`,
+		[]string{"repo", "main.go", "package main\n\nfunc main() {\n\tlog.Fatal(err)\n}\n"}).
+		WithArgs("HEAD", `log\.Fatal\(`, "repo", "go")
+
+	res := suite.search("q=log.Fatal(&repo=repo&lang=Go&context=1")
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	var resp searchResponse
+	suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &resp))
+	suite.Equal([]service.SearchMatch{{
+		Repository: "repo",
+		Path:       "main.go",
+		Line:       4,
+		Column:     2,
+		EndColumn:  12,
+		Text:       "\tlog.Fatal(err)",
+		Before:     []string{"func main() {"},
+		After:      []string{"}"},
+	}}, resp.Data)
+	suite.Equal(0, resp.Meta.Next)
+	suite.False(resp.Meta.Truncated)
+	suite.NoError(suite.mock.ExpectationsWereMet())
+}
+
+func (suite *SearchSuite) TestRegexPages() {
+	suite.expectFiles(`WHERE ref_name = ? AND blob_content REGEXP ?`,
+		[]string{"a", "a.go", "// TODO one\n// todo two"},
+		[]string{"b", "b.go", "\x00binary todo"},
+		[]string{"b", "c.go", "x := 1 // ToDo three"}).
+		WithArgs("refs/heads/dev", "(?i)todo")
+
+	res := suite.search("regex=todo&ignoreCase=true&ref=refs/heads/dev&offset=1&limit=1")
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	var resp searchResponse
+	suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &resp))
+	suite.Require().Len(resp.Data, 1)
+	suite.Equal("a.go", resp.Data[0].Path)
+	suite.Equal(2, resp.Data[0].Line)
+	suite.Equal(1, resp.Meta.Offset)
+	suite.Equal(2, resp.Meta.Next)
+	suite.False(resp.Meta.Truncated)
+}
+
+func (suite *SearchSuite) TestMaxMatches() {
+	suite.expectFiles(`REGEXP ?`,
+		[]string{"a", "a.go", "x x x\nx x x"}).
+		WithArgs("HEAD", "x")
+
+	res := suite.search("q=x&limit=10")
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	var resp searchResponse
+	suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &resp))
+	suite.Len(resp.Data, 5)
+	suite.Equal(0, resp.Meta.Next)
+	suite.True(resp.Meta.Truncated)
+}
+
+// ctxDB records the context of the last query
+type ctxDB struct {
+	service.SQLDB
+	ctx context.Context
+}
+
+func (db *ctxDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	db.ctx = ctx
+	return db.SQLDB.QueryContext(ctx, query, args...)
+}
+
+func (suite *SearchSuite) TestCancelRemainingRows() {
+	mockDB, mock, err := sqlmock.New()
+	suite.Require().NoError(err)
+	defer mockDB.Close()
+
+	rows := sqlmock.NewRows([]string{"repository_id", "file_path", "blob_content"})
+	for _, path := range []string{"a.go", "b.go", "c.go", "d.go"} {
+		rows.AddRow("repo", path, "x")
+	}
+	mock.ExpectQuery(`REGEXP ?`).WillReturnRows(rows)
+
+	db := &ctxDB{SQLDB: mockDB}
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	h := lg.RequestLogger(logger)(handler.APIHandlerFunc(
+		handler.Search(db, handler.SearchLimits{MaxMatches: 2})))
+
+	req, _ := http.NewRequest("GET", "/search?q=x", nil)
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	var resp searchResponse
+	suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &resp))
+	suite.Len(resp.Data, 2)
+	suite.True(resp.Meta.Truncated)
+
+	// the query is canceled instead of reading the rows left
+	suite.Require().NotNil(db.ctx)
+	suite.Equal(context.Canceled, db.ctx.Err())
+}
+
+func (suite *SearchSuite) TestBadRequest() {
+	testCases := []string{
+		"",
+		"q=a&regex=b",
+		"regex=(",
+		"q=a&limit=0",
+		"q=a&offset=-1",
+		"q=a&context=11",
+	}
+
+	for _, tc := range testCases {
+		suite.T().Run(tc, func(t *testing.T) {
+			res := suite.search(tc)
+			suite.Equal(http.StatusBadRequest, res.Code, res.Body.String())
+		})
+	}
+}
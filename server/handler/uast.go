@@ -1,19 +1,25 @@
 package handler
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
-	"sort"
 
+	"github.com/src-d/gitbase-web/server/memstore"
 	"github.com/src-d/gitbase-web/server/serializer"
 	"github.com/src-d/gitbase-web/server/service"
+	"github.com/src-d/gitbase-web/server/uastutil"
 
 	bblfsh "github.com/bblfsh/go-client"
 	"github.com/bblfsh/go-client/tools"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"gopkg.in/bblfsh/sdk.v2/uast/nodes"
+	enry "gopkg.in/src-d/enry.v1"
 )
 
 type uastMode = string
@@ -25,20 +31,35 @@ const (
 )
 
 type parseRequest struct {
-	ServerURL string   `json:"serverUrl"`
-	Language  string   `json:"language"`
-	Filename  string   `json:"filename"`
-	Content   string   `json:"content"`
-	Filter    string   `json:"filter"`
-	Mode      uastMode `json:"mode"`
+	ServerURL string `json:"serverUrl"`
+	// The content can be read from gitbase instead, from a blob or from a
+	// file path in the commit of a reference
+	RepositoryID string `json:"repositoryId"`
+	BlobHash     string `json:"blobHash"`
+	Ref          string `json:"ref"`
+	Path         string `json:"path"`
+	parseFileRequest
+}
+
+type parseFileRequest struct {
+	Endpoint string   `json:"endpoint"`
+	Language string   `json:"language"`
+	Filename string   `json:"filename"`
+	Content  string   `json:"content"`
+	Filter   string   `json:"filter"`
+	Mode     uastMode `json:"mode"`
+	Output   string   `json:"output"`
+	// XPaths are evaluated over the whole tree, before the filter
+	XPaths []xpathQuery `json:"xpaths"`
 }
 
 // Parse returns a function that parses text contents using bblfsh and
-// returns UAST
-func Parse(bbblfshServerURL string) RequestProcessFunc {
+// returns UAST. The contents can also be read from a blob or a file stored in
+// gitbase; then they are returned with the UAST
+func Parse(db service.SQLDB, endpoints *BblfshEndpoints, languages *LanguagesCache) RequestProcessFunc {
 	return func(r *http.Request) (*serializer.Response, error) {
 		var req parseRequest
-		body, err := ioutil.ReadAll(r.Body)
+		body, err := readBody(r, endpoints.Limits.maxBodyBytes(1))
 		if err != nil {
 			return nil, err
 		}
@@ -48,65 +69,262 @@ func Parse(bbblfshServerURL string) RequestProcessFunc {
 			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
 
-		if req.ServerURL != "" {
-			bbblfshServerURL = req.ServerURL
+		fromGitbase := req.BlobHash != "" || req.Path != ""
+		if fromGitbase {
+			if err := loadContent(r.Context(), db, languages, &req); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := endpoints.Limits.checkContent(req.Content); err != nil {
+			return nil, err
+		}
+
+		cli, done, err := endpoints.client(r.Context(), req.Endpoint, req.ServerURL)
+		if err != nil {
+			return nil, err
 		}
+		defer done()
 
-		cli, err := bblfsh.NewClient(bbblfshServerURL)
+		resp, err := parseFile(r.Context(), cli, endpoints.Limits, req.parseFileRequest)
 		if err != nil {
 			return nil, err
 		}
 
-		var mode bblfsh.Mode
-		switch req.Mode {
-		case native:
-			mode = bblfsh.Native
-		case annotated:
-			mode = bblfsh.Annotated
-		case semantic:
-			mode = bblfsh.Semantic
-		case "":
-			mode = bblfsh.Semantic
-		default:
-			return nil, serializer.NewHTTPError(http.StatusBadRequest,
-				fmt.Sprintf(`invalid "mode" %q; it must be one of "native", "annotated", "semantic"`, req.Mode))
+		if fromGitbase {
+			resp.Content = req.Content
 		}
 
-		resp, lang, err := cli.NewParseRequest().
-			Language(req.Language).
-			Filename(req.Filename).
-			Content(req.Content).
-			Mode(mode).
-			UAST()
+		return parseResponse(resp, req.Output)
+	}
+}
 
-		if bblfsh.ErrSyntax.Is(err) {
-			return nil, serializer.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error parsing UAST: %s", err))
+// loadContent sets the content of the request to the blob or file read from
+// gitbase. If the request has no language, it is detected with enry and set
+// to the id of the bblfsh driver for it. It is left empty when no driver
+// matches, so bblfsh detects it
+func loadContent(
+	ctx context.Context,
+	db service.SQLDB,
+	languages *LanguagesCache,
+	req *parseRequest,
+) error {
+	if req.Content != "" || (req.BlobHash != "" && req.Path != "") {
+		return serializer.NewHTTPError(http.StatusBadRequest,
+			`Bad Request. Only one of "content", "blobHash" or "path" can be used`)
+	}
+
+	var content string
+	var err error
+
+	if req.BlobHash != "" {
+		content, err = blobContent(ctx, db, req.RepositoryID, req.BlobHash)
+	} else {
+		if req.RepositoryID == "" {
+			return serializer.NewHTTPError(http.StatusBadRequest,
+				`Bad Request. "path" needs a "repositoryId"`)
 		}
-		if err != nil {
-			return nil, serializer.NewHTTPError(http.StatusInternalServerError, err.Error())
+
+		ref := req.Ref
+		if ref == "" {
+			ref = "HEAD"
 		}
 
-		if req.Filter != "" {
-			resp, err = applyXpath(resp, req.Filter)
-			if err != nil {
-				return nil, err
-			}
+		content, err = fileContent(ctx, db, req.RepositoryID, ref, req.Path)
+		if req.Filename == "" {
+			req.Filename = req.Path
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	req.Content = content
+
+	if req.Language == "" {
+		lang := enry.GetLanguage(req.Filename, []byte(content))
+		if lang == "" {
+			return serializer.NewHTTPError(http.StatusBadRequest,
+				`Bad Request. The language of the file could not be detected, it must be set in "language"`)
+		}
+
+		// the language is left empty if the drivers can not be requested
+		req.Language, _ = languages.languageID(ctx, lang)
+	}
+
+	return nil
+}
+
+// parseResponse returns a Response with the UAST and the xpath results
+// encoded in the given output format, already validated by parseFile
+func parseResponse(resp *service.ParseResponse, output string) (*serializer.Response, error) {
+	f, err := outputFormat(output)
+	if err != nil {
+		return nil, err
+	}
+
+	uast, err := uastutil.Encode(resp.UAST, f)
+	if err != nil {
+		return nil, err
+	}
+
+	xpaths, err := encodeXpaths(resp.XPaths, f)
+	if err != nil {
+		return nil, err
+	}
+
+	return serializer.NewParseResponse(resp, uast, xpaths), nil
+}
+
+// outputFormat returns the UAST output format with the given name, or a bad
+// request error
+func outputFormat(name string) (uastutil.Format, error) {
+	f, err := uastutil.ParseFormat(name)
+	if err != nil {
+		return "", serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return f, nil
+}
+
+// readBody reads the request body, or returns a 413 error if it is larger
+// than maxBytes. A value of 0 means no limit
+func readBody(r *http.Request, maxBytes int64) ([]byte, error) {
+	if maxBytes <= 0 {
+		return ioutil.ReadAll(r.Body)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(body)) > maxBytes {
+		return nil, serializer.NewHTTPError(http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Request Entity Too Large. The request body can not be larger than %d bytes", maxBytes))
+	}
+
+	return body, nil
+}
+
+// parseFile parses the file contents with bblfsh and applies the filter, if
+// any. The file content size and the time waiting for bblfsh are capped by
+// the limits
+func parseFile(
+	ctx context.Context,
+	cli *bblfsh.Client,
+	limits ParseLimits,
+	req parseFileRequest,
+) (*service.ParseResponse, error) {
+	var mode bblfsh.Mode
+	switch req.Mode {
+	case native:
+		mode = bblfsh.Native
+	case annotated:
+		mode = bblfsh.Annotated
+	case semantic:
+		mode = bblfsh.Semantic
+	case "":
+		mode = bblfsh.Semantic
+	default:
+		return nil, serializer.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf(`invalid "mode" %q; it must be one of "native", "annotated", "semantic"`, req.Mode))
+	}
+
+	if _, err := outputFormat(req.Output); err != nil {
+		return nil, err
+	}
+
+	if err := validateXpaths(req.XPaths); err != nil {
+		return nil, err
+	}
+
+	if err := limits.checkContent(req.Content); err != nil {
+		return nil, err
+	}
+
+	if limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+		defer cancel()
+	}
+
+	resp, lang, err := cli.NewParseRequest().
+		Context(ctx).
+		Language(req.Language).
+		Filename(req.Filename).
+		Content(req.Content).
+		Mode(mode).
+		UAST()
+
+	if err != nil {
+		return nil, parseError(ctx, err)
+	}
+
+	xpaths, err := evalXpaths(resp, req.XPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Filter != "" {
+		resp, err = applyXpath(resp, req.Filter)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		return serializer.NewParseResponse(&service.ParseResponse{
-			UAST: resp,
-			Lang: lang,
-		}), nil
+	return &service.ParseResponse{
+		UAST:   resp,
+		Lang:   lang,
+		XPaths: xpaths,
+	}, nil
+}
+
+// parseError returns an error with a status code that tells apart the
+// syntax errors of the file, the parse timeouts and the unavailable drivers
+func parseError(ctx context.Context, err error) error {
+	if bblfsh.ErrSyntax.Is(err) {
+		return serializer.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error parsing UAST: %s", err))
 	}
+
+	if ctx.Err() == context.DeadlineExceeded || status.Code(err) == codes.DeadlineExceeded {
+		return serializer.NewHTTPError(http.StatusGatewayTimeout,
+			fmt.Sprintf("timeout parsing the file with bblfsh: %s", err))
+	}
+
+	if ctx.Err() == context.Canceled {
+		return serializer.NewHTTPError(http.StatusServiceUnavailable,
+			"the request was canceled before parsing the file")
+	}
+
+	switch {
+	case bblfsh.ErrDriverFailure.Is(err), status.Code(err) == codes.Unavailable:
+		return serializer.NewHTTPError(http.StatusServiceUnavailable,
+			fmt.Sprintf("the bblfsh driver is unavailable: %s", err))
+	case status.Code(err) == codes.ResourceExhausted:
+		return serializer.NewHTTPError(http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("the file is too large for bblfsh: %s", err))
+	}
+
+	return serializer.NewHTTPError(http.StatusInternalServerError, err.Error())
 }
 
 type filterRequest struct {
 	Protobufs string `json:"protobufs"`
-	Filter    string `json:"filter"`
+	// Handle refers to a UAST kept by the server, instead of the protobufs
+	Handle string `json:"handle"`
+	Filter string `json:"filter"`
+	Output string `json:"output"`
+	// XPaths are evaluated instead of the filter
+	XPaths []xpathQuery `json:"xpaths"`
 }
 
-// Filter returns a function that filters UAST protobuf and returns UAST JSON
-func Filter() RequestProcessFunc {
+// Filter returns a function that filters UAST protobuf, or a UAST kept in the
+// uasts store, and returns the UAST in the requested output format, JSON by
+// default. With several named xpath expressions, it returns their typed
+// results instead
+func Filter(uasts *memstore.Store) RequestProcessFunc {
 	return func(r *http.Request) (*serializer.Response, error) {
 		var req filterRequest
 		body, err := ioutil.ReadAll(r.Body)
@@ -119,14 +337,32 @@ func Filter() RequestProcessFunc {
 			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
 
-		data, err := base64.StdEncoding.DecodeString(req.Protobufs)
+		output, err := outputFormat(req.Output)
 		if err != nil {
-			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+			return nil, err
+		}
+
+		if err := validateXpaths(req.XPaths); err != nil {
+			return nil, err
 		}
 
-		reqNodes, err := service.UnmarshalNodes(data)
+		reqNodes, err := filterNodes(req, uasts)
 		if err != nil {
-			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+			return nil, err
+		}
+
+		if len(req.XPaths) > 0 {
+			xpaths, err := evalXpaths(reqNodes, req.XPaths)
+			if err != nil {
+				return nil, err
+			}
+
+			xpaths, err = encodeXpaths(xpaths, output)
+			if err != nil {
+				return nil, err
+			}
+
+			return serializer.NewXPathsResponse(xpaths), nil
 		}
 
 		var resp nodes.Array
@@ -140,43 +376,64 @@ func Filter() RequestProcessFunc {
 			resp = reqNodes
 		}
 
-		return serializer.UASTFilterResponse(resp), nil
+		uast, err := uastutil.Encode(resp, output)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.UASTFilterResponse(uast), nil
 	}
 }
 
-func applyXpath(n nodes.Node, query string) (nodes.Array, serializer.HTTPError) {
-	iter, err := tools.Filter(n, query)
+// filterNodes returns the UAST of the filter request, decoded from its
+// protobufs or found in the store by its handle
+func filterNodes(req filterRequest, uasts *memstore.Store) (nodes.Array, error) {
+	if req.Handle != "" && req.Protobufs != "" {
+		return nil, serializer.NewHTTPError(http.StatusBadRequest,
+			`Bad Request. Only one of "protobufs" and "handle" can be used`)
+	}
+
+	if req.Handle != "" {
+		var n interface{}
+		var ok bool
+		if uasts != nil {
+			n, ok = uasts.Get(req.Handle)
+		}
+
+		if !ok {
+			return nil, serializer.NewHTTPError(http.StatusNotFound,
+				"UAST not found; it may have expired, run the query again")
+		}
+
+		// the store keeps the protobufs, that are smaller than the nodes
+		return service.UnmarshalNodes(n.([]byte))
+	}
+
+	data, err := base64.StdEncoding.DecodeString(req.Protobufs)
 	if err != nil {
 		return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	results := nodes.Array{}
-	for iter.Next() {
-		results = append(results, iter.Node().(nodes.Node))
+	n, err := service.UnmarshalNodes(data)
+	if err != nil {
+		return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	return results, nil
+	return n, nil
 }
 
-// GetLanguages returns a list of supported languages by bblfsh
-func GetLanguages(bbblfshServerURL string) RequestProcessFunc {
-	return func(r *http.Request) (*serializer.Response, error) {
-		cli, err := bblfsh.NewClient(bbblfshServerURL)
-		if err != nil {
-			return nil, err
-		}
-
-		resp, err := cli.NewSupportedLanguagesRequest().Do()
-		if err != nil {
-			return nil, err
-		}
-
-		langs := service.DriverManifestsToLangs(resp)
+func applyXpath(n nodes.Node, query string) (_ nodes.Array, err error) {
+	defer recoverXpath(query, &err)
 
-		sort.Slice(langs, func(i, j int) bool {
-			return langs[i].Name < langs[j].Name
-		})
+	iter, err := tools.Filter(n, query)
+	if err != nil {
+		return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
 
-		return serializer.NewLanguagesResponse(langs), nil
+	results := nodes.Array{}
+	for iter.Next() {
+		results = append(results, iter.Node().(nodes.Node))
 	}
+
+	return results, nil
 }
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/src-d/gitbase-web/server/serializer"
+	"github.com/src-d/gitbase-web/server/service"
+	"github.com/src-d/gitbase-web/server/uastutil"
+)
+
+type uastAtRequest struct {
+	Endpoint     string `json:"endpoint"`
+	RepositoryID string `json:"repositoryId"`
+	Language     string `json:"language"`
+	Filename     string `json:"filename"`
+	fileVersion
+	Line   uint32  `json:"line"`
+	Col    uint32  `json:"col"`
+	Offset *uint32 `json:"offset"`
+}
+
+// UASTAt returns a function that parses a file using bblfsh and returns the
+// UAST nodes that enclose a position, from the root to the innermost one
+func UASTAt(db service.SQLDB, endpoints *BblfshEndpoints) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		var req uastAtRequest
+		body, err := readBody(r, endpoints.Limits.maxBodyBytes(1))
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		if (req.Content == nil) == (req.BlobHash == "") {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				`Bad Request. The request needs a "content" or a "blobHash"`)
+		}
+
+		loc := uastutil.Location{Line: req.Line, Col: req.Col}
+		if req.Offset != nil {
+			loc = uastutil.Location{Offset: *req.Offset, ByOffset: true}
+		} else if req.Line == 0 || req.Col == 0 {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				`Bad Request. The request needs an "offset", or a "line" and "col" starting at 1`)
+		}
+
+		content, err := versionContent(r.Context(), db, req.RepositoryID, req.fileVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		cli, done, err := endpoints.client(r.Context(), req.Endpoint, "")
+		if err != nil {
+			return nil, err
+		}
+		defer done()
+
+		// the semantic mode is the one with uast:Positions
+		resp, err := parseFile(r.Context(), cli, endpoints.Limits, parseFileRequest{
+			Language: req.Language,
+			Filename: req.Filename,
+			Content:  content,
+			Mode:     semantic,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewUASTAtResponse(uastutil.Enclosing(resp.UAST, loc), resp.Lang), nil
+	}
+}
@@ -0,0 +1,78 @@
+package handler_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/src-d/gitbase-web/server/bblfshpool"
+	"github.com/src-d/gitbase-web/server/handler"
+
+	"github.com/pressly/lg"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+type UASTAtSuite struct {
+	suite.Suite
+	mock    sqlmock.Sqlmock
+	handler http.Handler
+}
+
+func TestUASTAtSuite(t *testing.T) {
+	suite.Run(t, new(UASTAtSuite))
+}
+
+func (suite *UASTAtSuite) SetupTest() {
+	db, mock, err := sqlmock.New()
+	suite.Require().NoError(err)
+	suite.mock = mock
+
+	pool, err := bblfshpool.New([]string{"127.0.0.1:9432"}, bblfshpool.Options{})
+	suite.Require().NoError(err)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	suite.handler = lg.RequestLogger(logger)(handler.APIHandlerFunc(
+		handler.UASTAt(db, &handler.BblfshEndpoints{Default: pool})))
+}
+
+func (suite *UASTAtSuite) TestBadRequest() {
+	testCases := []string{
+		`{ "language": "go", "offset": 0 }`,
+		`{ "language": "go", "content": "", "blobHash": "abc", "offset": 0 }`,
+		`{ "language": "go", "content": "" }`,
+		`{ "language": "go", "content": "", "line": 1 }`,
+		`{ "language": "go", "blobHash": "../abc", "offset": 0 }`,
+		`{ "language": "go", "content": "", "offset": 0, "endpoint": "nope" }`,
+	}
+
+	for _, tc := range testCases {
+		suite.T().Run(tc, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/uast/at", strings.NewReader(tc))
+			res := httptest.NewRecorder()
+			suite.handler.ServeHTTP(res, req)
+
+			suite.Equal(http.StatusBadRequest, res.Code, res.Body.String())
+		})
+	}
+}
+
+func (suite *UASTAtSuite) TestBlobNotFound() {
+	hash := strings.Repeat("a", 40)
+	suite.mock.ExpectQuery(regexp.QuoteMeta(`SELECT blob_content FROM blobs WHERE blob_hash = ? LIMIT 1`)).
+		WithArgs(hash).
+		WillReturnRows(sqlmock.NewRows([]string{"blob_content"}))
+
+	body := fmt.Sprintf(`{ "language": "go", "blobHash": "%s", "line": 1, "col": 1 }`, hash)
+	req, _ := http.NewRequest("POST", "/uast/at", strings.NewReader(body))
+	res := httptest.NewRecorder()
+	suite.handler.ServeHTTP(res, req)
+
+	suite.Equal(http.StatusNotFound, res.Code, res.Body.String())
+	suite.NoError(suite.mock.ExpectationsWereMet())
+}
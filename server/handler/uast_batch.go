@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/pressly/lg"
+	"github.com/src-d/gitbase-web/server/serializer"
+	"github.com/src-d/gitbase-web/server/service"
+)
+
+// ParseBatch returns a function that parses several files using bblfsh, with
+// the given number of concurrent workers. The results are returned in the
+// same order as the request files, each one with its own status and errors.
+// With the stream query parameter set to true, they are written as
+// newline-delimited JSON as soon as they are ready
+func ParseBatch(endpoints *BblfshEndpoints, workers int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var reqs []parseFileRequest
+		body, err := readBody(r, endpoints.Limits.maxBodyBytes(endpoints.Limits.MaxBatchFiles))
+		if err != nil {
+			write(w, r, nil, err)
+			return
+		}
+
+		err = json.Unmarshal(body, &reqs)
+		if err != nil {
+			write(w, r, nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error()))
+			return
+		}
+
+		if len(reqs) == 0 {
+			write(w, r, nil, serializer.NewHTTPError(http.StatusBadRequest,
+				"Bad Request. The request must be an array with at least one file"))
+			return
+		}
+
+		if err := endpoints.Limits.checkBatch(len(reqs)); err != nil {
+			write(w, r, nil, err)
+			return
+		}
+
+		stream := r.URL.Query().Get("stream") == "true"
+
+		results := parseFiles(r, endpoints, reqs, workers)
+
+		if !stream {
+			responses := make([]*serializer.Response, len(results))
+			for i, res := range results {
+				responses[i] = <-res
+			}
+
+			write(w, r, serializer.NewParseBatchResponse(responses), nil)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+
+		flusher, _ := w.(http.Flusher)
+		enc := json.NewEncoder(w)
+		for i, res := range results {
+			if err := enc.Encode(<-res); err != nil {
+				lg.RequestLog(r).Error(fmt.Sprintf("error writing the result %d: %s", i, err))
+				return
+			}
+
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// parseFiles starts the workers that parse the files, and returns a channel
+// for each file that receives its response
+func parseFiles(
+	r *http.Request,
+	endpoints *BblfshEndpoints,
+	reqs []parseFileRequest,
+	workers int,
+) []chan *serializer.Response {
+	results := make([]chan *serializer.Response, len(reqs))
+	for i := range results {
+		results[i] = make(chan *serializer.Response, 1)
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(reqs) {
+		workers = len(reqs)
+	}
+
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range reqs {
+			select {
+			case jobs <- i:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for n := 0; n < workers; n++ {
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				resp, err := parseFileWith(r.Context(), endpoints, reqs[i])
+				if err != nil {
+					results[i] <- errorResponse(err)
+					continue
+				}
+
+				response, err := parseResponse(resp, reqs[i].Output)
+				if err != nil {
+					results[i] <- errorResponse(err)
+					continue
+				}
+
+				results[i] <- response
+			}
+		}()
+	}
+
+	// the files that were not parsed because the request was canceled get
+	// an error, so nobody waits forever for them
+	go func() {
+		wg.Wait()
+		for _, res := range results {
+			select {
+			case res <- canceledResponse():
+			default:
+			}
+		}
+	}()
+
+	return results
+}
+
+// parseFileWith parses the file with a client of the selected endpoint.
+// Each file can be sent to a different bblfsh server
+func parseFileWith(
+	ctx context.Context,
+	endpoints *BblfshEndpoints,
+	req parseFileRequest,
+) (*service.ParseResponse, error) {
+	if err := endpoints.Limits.checkContent(req.Content); err != nil {
+		return nil, err
+	}
+
+	cli, done, err := endpoints.client(ctx, req.Endpoint, "")
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	return parseFile(ctx, cli, endpoints.Limits, req)
+}
+
+func canceledResponse() *serializer.Response {
+	return errorResponse(serializer.NewHTTPError(http.StatusServiceUnavailable,
+		"the request was canceled before parsing the file"))
+}
+
+// errorResponse returns the Response of a file of the batch that failed
+func errorResponse(err error) *serializer.Response {
+	response := serializer.NewEmptyResponse()
+	setError(response, err)
+	return response
+}
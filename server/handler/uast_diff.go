@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/src-d/gitbase-web/server/serializer"
+	"github.com/src-d/gitbase-web/server/service"
+	"github.com/src-d/gitbase-web/server/uastutil"
+)
+
+// fileVersion is one of the versions compared by /uast/diff, given by its
+// content or by the hash of a blob stored in gitbase
+type fileVersion struct {
+	Content  *string `json:"content"`
+	BlobHash string  `json:"blobHash"`
+}
+
+type uastDiffRequest struct {
+	Endpoint     string      `json:"endpoint"`
+	RepositoryID string      `json:"repositoryId"`
+	Language     string      `json:"language"`
+	Filename     string      `json:"filename"`
+	Mode         uastMode    `json:"mode"`
+	From         fileVersion `json:"from"`
+	To           fileVersion `json:"to"`
+}
+
+// UASTDiff returns a function that parses two versions of a file using bblfsh
+// and returns the structural differences between their UASTs
+func UASTDiff(db service.SQLDB, endpoints *BblfshEndpoints) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		var req uastDiffRequest
+		body, err := readBody(r, endpoints.Limits.maxBodyBytes(2))
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		for _, v := range []fileVersion{req.From, req.To} {
+			if (v.Content == nil) == (v.BlobHash == "") {
+				return nil, serializer.NewHTTPError(http.StatusBadRequest,
+					`Bad Request. Each one of "from" and "to" needs a "content" or a "blobHash"`)
+			}
+		}
+
+		var contents [2]string
+		for i, v := range []fileVersion{req.From, req.To} {
+			contents[i], err = versionContent(r.Context(), db, req.RepositoryID, v)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		cli, done, err := endpoints.client(r.Context(), req.Endpoint, "")
+		if err != nil {
+			return nil, err
+		}
+		defer done()
+
+		var trees [2]*service.ParseResponse
+		for i, content := range contents {
+			trees[i], err = parseFile(r.Context(), cli, endpoints.Limits, parseFileRequest{
+				Language: req.Language,
+				Filename: req.Filename,
+				Content:  content,
+				Mode:     req.Mode,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return serializer.NewUASTDiffResponse(
+			uastutil.Compare(trees[0].UAST, trees[1].UAST), trees[1].Lang), nil
+	}
+}
+
+func versionContent(
+	ctx context.Context,
+	db service.SQLDB,
+	repositoryID string,
+	v fileVersion,
+) (string, error) {
+	if v.Content != nil {
+		return *v.Content, nil
+	}
+
+	return blobContent(ctx, db, repositoryID, v.BlobHash)
+}
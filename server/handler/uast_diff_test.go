@@ -0,0 +1,79 @@
+package handler_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/src-d/gitbase-web/server/bblfshpool"
+	"github.com/src-d/gitbase-web/server/handler"
+
+	"github.com/pressly/lg"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+type UASTDiffSuite struct {
+	suite.Suite
+	mock    sqlmock.Sqlmock
+	handler http.Handler
+}
+
+func TestUASTDiffSuite(t *testing.T) {
+	suite.Run(t, new(UASTDiffSuite))
+}
+
+func (suite *UASTDiffSuite) SetupTest() {
+	db, mock, err := sqlmock.New()
+	suite.Require().NoError(err)
+	suite.mock = mock
+
+	pool, err := bblfshpool.New([]string{"127.0.0.1:9432"}, bblfshpool.Options{})
+	suite.Require().NoError(err)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	suite.handler = lg.RequestLogger(logger)(handler.APIHandlerFunc(
+		handler.UASTDiff(db, &handler.BblfshEndpoints{Default: pool})))
+}
+
+func (suite *UASTDiffSuite) TestBadRequest() {
+	testCases := []string{
+		`{ "language": "go" }`,
+		`{ "language": "go", "from": { "content": "" } }`,
+		`{ "language": "go", "from": { "content": "", "blobHash": "abc" }, "to": { "content": "" } }`,
+		`{ "language": "go", "from": { "blobHash": "../abc" }, "to": { "content": "" } }`,
+		`{ "language": "go", "from": { "content": "" }, "to": { "content": "" }, "endpoint": "nope" }`,
+	}
+
+	for _, tc := range testCases {
+		suite.T().Run(tc, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/uast/diff", strings.NewReader(tc))
+			res := httptest.NewRecorder()
+			suite.handler.ServeHTTP(res, req)
+
+			suite.Equal(http.StatusBadRequest, res.Code, res.Body.String())
+		})
+	}
+}
+
+func (suite *UASTDiffSuite) TestBlobNotFound() {
+	hash := strings.Repeat("a", 40)
+	suite.mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT blob_content FROM blobs WHERE blob_hash = ? AND repository_id = ? LIMIT 1`)).
+		WithArgs(hash, "it's").
+		WillReturnRows(sqlmock.NewRows([]string{"blob_content"}))
+
+	body := fmt.Sprintf(`{ "language": "go", "repositoryId": "it's",
+		"from": { "blobHash": "%s" }, "to": { "content": "" } }`, hash)
+	req, _ := http.NewRequest("POST", "/uast/diff", strings.NewReader(body))
+	res := httptest.NewRecorder()
+	suite.handler.ServeHTTP(res, req)
+
+	suite.Equal(http.StatusNotFound, res.Code, res.Body.String())
+	suite.NoError(suite.mock.ExpectationsWereMet())
+}
@@ -1,6 +1,7 @@
 package handler_test
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -9,10 +10,13 @@ import (
 
 	"github.com/pressly/lg"
 	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 
 	"github.com/src-d/gitbase-web/server/handler"
+	"github.com/src-d/gitbase-web/server/memstore"
 	"github.com/src-d/gitbase-web/server/serializer"
+	"github.com/src-d/gitbase-web/server/uastutil"
 )
 
 type UASTParseSuite struct {
@@ -22,7 +26,9 @@ type UASTParseSuite struct {
 
 func TestUASTParseSuite(t *testing.T) {
 	q := new(UASTParseSuite)
-	q.handler = lg.RequestLogger(logrus.New())(handler.APIHandlerFunc(handler.Parse(bblfshServerURL())))
+	endpoints := bblfshEndpoints()
+	languages := handler.NewLanguagesCache(endpoints.Default, 0)
+	q.handler = lg.RequestLogger(logrus.New())(handler.APIHandlerFunc(handler.Parse(nil, endpoints, languages)))
 
 	if !isIntegration() {
 		t.Skip("use the env var GITBASEPG_INTEGRATION_TESTS=true to run this test")
@@ -61,11 +67,13 @@ func (suite *UASTParseSuite) TestError() {
 type UASTFilterSuite struct {
 	suite.Suite
 	handler http.Handler
+	uasts   *memstore.Store
 }
 
 func TestUASTFilterSuite(t *testing.T) {
 	q := new(UASTFilterSuite)
-	q.handler = lg.RequestLogger(logrus.New())(handler.APIHandlerFunc(handler.Filter()))
+	q.uasts = memstore.New(memstore.Options{})
+	q.handler = lg.RequestLogger(logrus.New())(handler.APIHandlerFunc(handler.Filter(q.uasts)))
 
 	suite.Run(t, q)
 }
@@ -87,6 +95,30 @@ func (suite *UASTFilterSuite) TestSuccess() {
 	suite.NotEmpty(resBody.Data)
 }
 
+func (suite *UASTFilterSuite) TestHandle() {
+	data, err := base64.StdEncoding.DecodeString(uastProtoMsgBase64List)
+	suite.Require().NoError(err)
+	handle := suite.uasts.Put(data, len(data))
+
+	testCases := []struct {
+		body   string
+		status int
+	}{
+		{`{ "handle": "` + handle + `", "filter": "//uast:Identifier" }`, http.StatusOK},
+		{`{ "handle": "unknown", "filter": "//*" }`, http.StatusNotFound},
+		{`{ "handle": "` + handle + `", "protobufs": "` + uastProtoMsgBase64List + `" }`,
+			http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		req, _ := http.NewRequest("POST", "/filter", strings.NewReader(tc.body))
+		res := httptest.NewRecorder()
+		suite.handler.ServeHTTP(res, req)
+
+		suite.Equal(tc.status, res.Code, res.Body.String())
+	}
+}
+
 func (suite *UASTFilterSuite) TestProtobufError() {
 	jsonRequest := `{ "protobufs": "not-proto", "filter": "[" }`
 	req, _ := http.NewRequest("POST", "/filter", strings.NewReader(jsonRequest))
@@ -97,6 +129,16 @@ func (suite *UASTFilterSuite) TestProtobufError() {
 	suite.Equal(http.StatusBadRequest, res.Code)
 }
 
+func (suite *UASTFilterSuite) TestUnsupportedFilter() {
+	jsonRequest := `{ "protobufs": "` + uastProtoMsgBase64List + `", "filter": "string(//*)" }`
+	req, _ := http.NewRequest("POST", "/filter", strings.NewReader(jsonRequest))
+
+	res := httptest.NewRecorder()
+	suite.handler.ServeHTTP(res, req)
+
+	suite.Equal(http.StatusBadRequest, res.Code)
+}
+
 func (suite *UASTFilterSuite) TestFilterError() {
 	jsonRequest := `{ "protobufs": "` + uastProtoMsgBase64List + `", "filter": "[" }`
 	req, _ := http.NewRequest("POST", "/filter", strings.NewReader(jsonRequest))
@@ -107,6 +149,73 @@ func (suite *UASTFilterSuite) TestFilterError() {
 	suite.Equal(http.StatusBadRequest, res.Code)
 }
 
+func (suite *UASTFilterSuite) TestXPaths() {
+	jsonRequest := `{ "protobufs": "` + uastProtoMsgBase64List + `", "xpaths": [
+		{ "name": "count", "query": "count(//uast:Identifier)" },
+		{ "name": "name", "query": "name(//uast:Identifier)" },
+		{ "name": "hasString", "query": "boolean(//uast:String)" },
+		{ "name": "idents", "query": "//uast:Identifier" },
+		{ "name": "number", "query": "count(//uast:Identifier)", "kind": "number" },
+		{ "name": "identName", "query": "string(//uast:Identifier/@Name)" },
+		{ "name": "identString", "query": "string(//uast:Identifier/@Name)", "kind": "string" },
+		{ "name": "missing", "query": "string(//uast:Identifier/@Nope)" }
+	] }`
+	req, _ := http.NewRequest("POST", "/filter", strings.NewReader(jsonRequest))
+
+	res := httptest.NewRecorder()
+	suite.handler.ServeHTTP(res, req)
+
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	var resBody struct {
+		Data map[string]struct {
+			Kind  string      `json:"kind"`
+			Value interface{} `json:"value"`
+		} `json:"data"`
+	}
+	err := json.Unmarshal(res.Body.Bytes(), &resBody)
+	suite.Require().NoError(err)
+
+	suite.Equal("number", resBody.Data["count"].Kind)
+	suite.EqualValues(2, resBody.Data["count"].Value)
+	suite.Equal("string", resBody.Data["name"].Kind)
+	suite.Equal("uast:Identifier", resBody.Data["name"].Value)
+	suite.Equal("boolean", resBody.Data["hasString"].Kind)
+	suite.Equal(true, resBody.Data["hasString"].Value)
+	suite.Equal("nodes", resBody.Data["idents"].Kind)
+	suite.Len(resBody.Data["idents"].Value, 2)
+	suite.Equal("number", resBody.Data["number"].Kind)
+	suite.EqualValues(2, resBody.Data["number"].Value)
+	suite.Equal("string", resBody.Data["identName"].Kind)
+	suite.Equal("console", resBody.Data["identName"].Value)
+	suite.Equal("string", resBody.Data["identString"].Kind)
+	suite.Equal("console", resBody.Data["identString"].Value)
+	suite.Equal("string", resBody.Data["missing"].Kind)
+	suite.Equal("", resBody.Data["missing"].Value)
+}
+
+func (suite *UASTFilterSuite) TestXPathsError() {
+	testCases := []string{
+		`[{ "query": "//*" }]`,
+		`[{ "name": "a", "query": "//*" }, { "name": "a", "query": "//*" }]`,
+		`[{ "name": "a", "query": "//*", "kind": "date" }]`,
+		`[{ "name": "a", "query": "[" }]`,
+		`[{ "name": "a", "query": "//uast:Identifier", "kind": "number" }]`,
+	}
+
+	for _, tc := range testCases {
+		suite.T().Run(tc, func(t *testing.T) {
+			jsonRequest := `{ "protobufs": "` + uastProtoMsgBase64List + `", "xpaths": ` + tc + ` }`
+			req, _ := http.NewRequest("POST", "/filter", strings.NewReader(jsonRequest))
+
+			res := httptest.NewRecorder()
+			suite.handler.ServeHTTP(res, req)
+
+			require.Equal(t, http.StatusBadRequest, res.Code, res.Body.String())
+		})
+	}
+}
+
 type UASTModeSuite struct {
 	suite.Suite
 	handler http.Handler
@@ -114,7 +223,9 @@ type UASTModeSuite struct {
 
 func TestUASTModeSuite(t *testing.T) {
 	q := new(UASTModeSuite)
-	q.handler = lg.RequestLogger(logrus.New())(handler.APIHandlerFunc(handler.Parse(bblfshServerURL())))
+	endpoints := bblfshEndpoints()
+	languages := handler.NewLanguagesCache(endpoints.Default, 0)
+	q.handler = lg.RequestLogger(logrus.New())(handler.APIHandlerFunc(handler.Parse(nil, endpoints, languages)))
 
 	if !isIntegration() {
 		t.Skip("use the env var GITBASEPG_INTEGRATION_TESTS=true to run this test")
@@ -160,6 +271,183 @@ func (suite *UASTModeSuite) TestWrongMode() {
 	suite.Equal(http.StatusBadRequest, res.Code)
 }
 
+type UASTParseBatchSuite struct {
+	suite.Suite
+	handler http.Handler
+}
+
+func TestUASTParseBatchSuite(t *testing.T) {
+	q := new(UASTParseBatchSuite)
+	q.handler = lg.RequestLogger(logrus.New())(handler.ParseBatch(bblfshEndpoints(), 2))
+
+	if !isIntegration() {
+		t.Skip("use the env var GITBASEPG_INTEGRATION_TESTS=true to run this test")
+	}
+
+	suite.Run(t, q)
+}
+
+const parseBatchRequest = `[
+	{ "content": "console.log('test')", "language": "javascript" },
+	{ "content": "function(} ][", "language": "javascript" },
+	{ "content": "console.log('test')", "language": "javascript", "mode": "foo" },
+	{ "content": "print('test')", "language": "python", "filter": "//uast:Identifier" }
+]`
+
+func (suite *UASTParseBatchSuite) TestSuccess() {
+	req, _ := http.NewRequest("POST", "/parse/batch", strings.NewReader(parseBatchRequest))
+
+	res := httptest.NewRecorder()
+	suite.handler.ServeHTTP(res, req)
+
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	var resBody struct {
+		Data []serializer.Response `json:"data"`
+	}
+	err := json.Unmarshal(res.Body.Bytes(), &resBody)
+	suite.Require().NoError(err)
+
+	suite.Require().Len(resBody.Data, 4)
+	suite.Equal(http.StatusOK, resBody.Data[0].Status)
+	suite.NotEmpty(resBody.Data[0].Data)
+	suite.Equal(http.StatusBadRequest, resBody.Data[1].Status)
+	suite.Equal(http.StatusBadRequest, resBody.Data[2].Status)
+	suite.Equal(http.StatusOK, resBody.Data[3].Status)
+}
+
+func (suite *UASTParseBatchSuite) TestStream() {
+	req, _ := http.NewRequest("POST", "/parse/batch?stream=true", strings.NewReader(parseBatchRequest))
+
+	res := httptest.NewRecorder()
+	suite.handler.ServeHTTP(res, req)
+
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+	suite.Equal("application/x-ndjson", res.Header().Get("Content-Type"))
+
+	var statuses []int
+	dec := json.NewDecoder(res.Body)
+	for dec.More() {
+		var item struct {
+			Status int `json:"status"`
+		}
+		suite.Require().NoError(dec.Decode(&item))
+		statuses = append(statuses, item.Status)
+	}
+
+	suite.Equal([]int{200, 400, 400, 200}, statuses)
+}
+
+func TestParseBatchBadRequest(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	h := lg.RequestLogger(logger)(handler.ParseBatch(bblfshEndpoints(), 2))
+
+	testCases := []string{
+		``,
+		`[]`,
+		`{ "content": "console.log('test')", "language": "javascript" }`,
+		`[{ "content": 1 }]`,
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/parse/batch", strings.NewReader(tc))
+
+			res := httptest.NewRecorder()
+			h.ServeHTTP(res, req)
+
+			require.Equal(t, http.StatusBadRequest, res.Code, res.Body.String())
+		})
+	}
+}
+
+type UASTDiffIntegrationSuite struct {
+	suite.Suite
+	handler http.Handler
+}
+
+func TestUASTDiffIntegrationSuite(t *testing.T) {
+	q := new(UASTDiffIntegrationSuite)
+	q.handler = lg.RequestLogger(logrus.New())(
+		handler.APIHandlerFunc(handler.UASTDiff(nil, bblfshEndpoints())))
+
+	if !isIntegration() {
+		t.Skip("use the env var GITBASEPG_INTEGRATION_TESTS=true to run this test")
+	}
+
+	suite.Run(t, q)
+}
+
+func (suite *UASTDiffIntegrationSuite) TestSuccess() {
+	jsonRequest := `{ "language": "javascript",
+		"from": { "content": "console.log('test')" },
+		"to": { "content": "\n\nconsole.log('other')" } }`
+	req, _ := http.NewRequest("POST", "/uast/diff", strings.NewReader(jsonRequest))
+
+	res := httptest.NewRecorder()
+	suite.handler.ServeHTTP(res, req)
+
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	var resBody struct {
+		Data uastutil.Diff `json:"data"`
+	}
+	err := json.Unmarshal(res.Body.Bytes(), &resBody)
+	suite.Require().NoError(err)
+
+	// the new lines only change the positions
+	suite.Empty(resBody.Data.Inserted)
+	suite.Empty(resBody.Data.Deleted)
+	suite.Require().NotEmpty(resBody.Data.Updated)
+
+	var tokens []string
+	for _, c := range resBody.Data.Updated {
+		tokens = append(tokens, c.From.Token+" -> "+c.To.Token)
+	}
+	suite.Contains(tokens, "test -> other")
+}
+
+type UASTAtIntegrationSuite struct {
+	suite.Suite
+	handler http.Handler
+}
+
+func TestUASTAtIntegrationSuite(t *testing.T) {
+	q := new(UASTAtIntegrationSuite)
+	q.handler = lg.RequestLogger(logrus.New())(
+		handler.APIHandlerFunc(handler.UASTAt(nil, bblfshEndpoints())))
+
+	if !isIntegration() {
+		t.Skip("use the env var GITBASEPG_INTEGRATION_TESTS=true to run this test")
+	}
+
+	suite.Run(t, q)
+}
+
+func (suite *UASTAtIntegrationSuite) TestSuccess() {
+	jsonRequest := `{ "language": "javascript", "content": "console.log('test')", "line": 1, "col": 10 }`
+	req, _ := http.NewRequest("POST", "/uast/at", strings.NewReader(jsonRequest))
+
+	res := httptest.NewRecorder()
+	suite.handler.ServeHTTP(res, req)
+
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	var resBody struct {
+		Data []uastutil.EnclosingNode `json:"data"`
+	}
+	err := json.Unmarshal(res.Body.Bytes(), &resBody)
+	suite.Require().NoError(err)
+
+	suite.Require().NotEmpty(resBody.Data)
+	suite.Equal("File", resBody.Data[0].Type)
+
+	innermost := resBody.Data[len(resBody.Data)-1]
+	suite.Equal("uast:Identifier", innermost.Type)
+	suite.Equal(uint32(9), innermost.Pos.Start().Col)
+}
+
 // JSON: [<UAST(console.log("test"))>]
 // Easy to obtain in the frontend with SELECT UAST('console.log("test")', 'JavaScript') AS uast
 // Gitbase v0.18.0-beta.1, Bblfsh v2.9.2-drivers
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/src-d/gitbase-web/server/serializer"
+	"github.com/src-d/gitbase-web/server/service"
+	"github.com/src-d/gitbase-web/server/uastutil"
+
+	"gopkg.in/bblfsh/sdk.v2/uast/nodes"
+)
+
+type uastStatsRequest struct {
+	Endpoint  string   `json:"endpoint"`
+	Language  string   `json:"language"`
+	Filename  string   `json:"filename"`
+	Content   *string  `json:"content"`
+	Mode      uastMode `json:"mode"`
+	Protobufs string   `json:"protobufs"`
+}
+
+// UASTStats returns a function that returns the stats of a UAST, parsed from
+// a file with bblfsh or given as protobufs
+func UASTStats(endpoints *BblfshEndpoints) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		var req uastStatsRequest
+		body, err := readBody(r, endpoints.Limits.maxBodyBytes(1))
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		if (req.Content == nil) == (req.Protobufs == "") {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				`Bad Request. The request needs a "content" or "protobufs"`)
+		}
+
+		var tree nodes.Node
+		var lang string
+
+		if req.Content != nil {
+			cli, done, err := endpoints.client(r.Context(), req.Endpoint, "")
+			if err != nil {
+				return nil, err
+			}
+			defer done()
+
+			resp, err := parseFile(r.Context(), cli, endpoints.Limits, parseFileRequest{
+				Language: req.Language,
+				Filename: req.Filename,
+				Content:  *req.Content,
+				Mode:     req.Mode,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			tree, lang = resp.UAST, resp.Lang
+		} else {
+			data, err := base64.StdEncoding.DecodeString(req.Protobufs)
+			if err != nil {
+				return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+
+			tree, err = service.UnmarshalNodes(data)
+			if err != nil {
+				return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+		}
+
+		stats, err := uastutil.NewStats(tree)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewUASTStatsResponse(stats, lang), nil
+	}
+}
@@ -0,0 +1,78 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/src-d/gitbase-web/server/bblfshpool"
+	"github.com/src-d/gitbase-web/server/handler"
+	"github.com/src-d/gitbase-web/server/uastutil"
+
+	"github.com/pressly/lg"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+type UASTStatsSuite struct {
+	suite.Suite
+	handler http.Handler
+}
+
+func TestUASTStatsSuite(t *testing.T) {
+	suite.Run(t, new(UASTStatsSuite))
+}
+
+func (suite *UASTStatsSuite) SetupTest() {
+	pool, err := bblfshpool.New([]string{"127.0.0.1:9432"}, bblfshpool.Options{})
+	suite.Require().NoError(err)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	suite.handler = lg.RequestLogger(logger)(handler.APIHandlerFunc(
+		handler.UASTStats(&handler.BblfshEndpoints{Default: pool})))
+}
+
+func (suite *UASTStatsSuite) TestProtobufs() {
+	jsonRequest := `{ "protobufs": "` + uastProtoMsgBase64List + `" }`
+	req, _ := http.NewRequest("POST", "/uast/stats", strings.NewReader(jsonRequest))
+
+	res := httptest.NewRecorder()
+	suite.handler.ServeHTTP(res, req)
+
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+
+	var resBody struct {
+		Data uastutil.Stats `json:"data"`
+	}
+	err := json.Unmarshal(res.Body.Bytes(), &resBody)
+	suite.Require().NoError(err)
+
+	suite.True(resBody.Data.Nodes > 0)
+	suite.Equal(1, resBody.Data.Types["File"])
+	suite.Equal(2, resBody.Data.Types["uast:Identifier"])
+	suite.Zero(resBody.Data.Types["uast:Positions"])
+	suite.True(resBody.Data.MaxDepth > 0)
+	suite.True(resBody.Data.ProtobufSize > 0)
+}
+
+func (suite *UASTStatsSuite) TestBadRequest() {
+	testCases := []string{
+		`{ "language": "go" }`,
+		`{ "language": "go", "content": "", "protobufs": "` + uastProtoMsgBase64List + `" }`,
+		`{ "protobufs": "not-proto" }`,
+		`{ "language": "go", "content": "", "endpoint": "nope" }`,
+	}
+
+	for _, tc := range testCases {
+		suite.T().Run(tc, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/uast/stats", strings.NewReader(tc))
+			res := httptest.NewRecorder()
+			suite.handler.ServeHTTP(res, req)
+
+			suite.Equal(http.StatusBadRequest, res.Code, res.Body.String())
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/src-d/gitbase-web/server/serializer"
+
+	bblfsh "github.com/bblfsh/go-client"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseError(t *testing.T) {
+	expired, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-expired.Done()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ctx := context.Background()
+
+	testCases := []struct {
+		name   string
+		ctx    context.Context
+		err    error
+		status int
+	}{
+		{"syntax", ctx, bblfsh.ErrSyntax.New(), http.StatusBadRequest},
+		{"timeout", expired, errors.New("context deadline exceeded"), http.StatusGatewayTimeout},
+		{"grpc timeout", ctx, status.Error(codes.DeadlineExceeded, "timeout"), http.StatusGatewayTimeout},
+		{"canceled", canceled, errors.New("context canceled"), http.StatusServiceUnavailable},
+		{"driver failure", ctx, bblfsh.ErrDriverFailure.New(), http.StatusServiceUnavailable},
+		{"unavailable", ctx, status.Error(codes.Unavailable, "no driver"), http.StatusServiceUnavailable},
+		{"too large", ctx, status.Error(codes.ResourceExhausted, "message too large"), http.StatusRequestEntityTooLarge},
+		{"other", ctx, errors.New("unknown"), http.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := parseError(tc.ctx, tc.err)
+
+			httpErr, ok := err.(serializer.HTTPError)
+			require.True(t, ok)
+			require.Equal(t, tc.status, httpErr.StatusCode())
+		})
+	}
+}
@@ -3,14 +3,14 @@ package handler
 import (
 	"net/http"
 
-	bblfsh "github.com/bblfsh/go-client"
+	"github.com/src-d/gitbase-web/server/bblfshpool"
 	"github.com/src-d/gitbase-web/server/serializer"
 	"github.com/src-d/gitbase-web/server/service"
 )
 
 // Version returns a function that returns a *serializer.Response
 // with a current version of server and dependencies
-func Version(version, bbblfshServerURL string, db service.SQLDB) RequestProcessFunc {
+func Version(version string, bblfshPool *bblfshpool.Pool, db service.SQLDB) RequestProcessFunc {
 	return func(r *http.Request) (*serializer.Response, error) {
 		// old versions of gitbase don't have VERSION() function
 		// so we set it to undefined and ignore error
@@ -22,12 +22,13 @@ func Version(version, bbblfshServerURL string, db service.SQLDB) RequestProcessF
 
 		// ignore bblfsh errors and return undefined to be consistent with gitbase
 		bblfshVersion := "undefined"
-		cli, err := bblfsh.NewClient(bbblfshServerURL)
+		cli, done, err := bblfshPool.Client(r.Context())
 		if err == nil {
-			resp, err := cli.NewVersionRequest().Do()
+			resp, err := cli.NewVersionRequest().Context(r.Context()).Do()
 			if err == nil {
 				bblfshVersion = resp.Version
 			}
+			done()
 		}
 
 		return serializer.NewVersionResponse(version, bblfshVersion, gitbaseVersion), nil
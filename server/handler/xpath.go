@@ -0,0 +1,253 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/src-d/gitbase-web/server/serializer"
+	"github.com/src-d/gitbase-web/server/service"
+	"github.com/src-d/gitbase-web/server/uastutil"
+
+	"github.com/bblfsh/go-client/tools"
+	"gopkg.in/bblfsh/sdk.v2/uast"
+	"gopkg.in/bblfsh/sdk.v2/uast/nodes"
+)
+
+type xpathKind = string
+
+const (
+	nodesKind   xpathKind = "nodes"
+	booleanKind xpathKind = "boolean"
+	numberKind  xpathKind = "number"
+	stringKind  xpathKind = "string"
+)
+
+// xpathQuery is a named xpath expression. An empty kind is detected from
+// the result of the expression
+type xpathQuery struct {
+	Name  string    `json:"name"`
+	Query string    `json:"query"`
+	Kind  xpathKind `json:"kind"`
+}
+
+// validateXpaths returns a bad request error if any of the queries does not
+// have a unique name or has an unknown kind
+func validateXpaths(queries []xpathQuery) error {
+	names := make(map[string]bool, len(queries))
+	for _, q := range queries {
+		if q.Name == "" || names[q.Name] {
+			return serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf(`each one of the "xpaths" needs a unique "name", found %q`, q.Name))
+		}
+		names[q.Name] = true
+
+		switch q.Kind {
+		case "", nodesKind, booleanKind, numberKind, stringKind:
+		default:
+			return serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf(`invalid "kind" %q; it must be one of "nodes", "boolean", "number", "string"`, q.Kind))
+		}
+	}
+
+	return nil
+}
+
+// evalXpaths returns the results of the queries evaluated over the tree,
+// by name
+func evalXpaths(n nodes.Node, queries []xpathQuery) (map[string]service.XPathResult, error) {
+	if len(queries) == 0 {
+		return nil, nil
+	}
+
+	ctx := tools.NewContext(n)
+
+	results := make(map[string]service.XPathResult, len(queries))
+	for _, q := range queries {
+		res, err := evalXpath(ctx, n, q)
+		if err != nil {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("error evaluating xpath %q: %s", q.Name, err))
+		}
+
+		results[q.Name] = res
+	}
+
+	return results, nil
+}
+
+func evalXpath(ctx *tools.Context, n nodes.Node, q xpathQuery) (_ service.XPathResult, err error) {
+	defer recoverXpath(q.Query, &err)
+
+	if q.Kind == "" || q.Kind == stringKind {
+		if arg, ok := stringArg(q.Query); ok {
+			value, err := xpathString(n, arg)
+			if err != nil {
+				return service.XPathResult{}, err
+			}
+
+			return service.XPathResult{Kind: stringKind, Value: value}, nil
+		}
+	}
+
+	var value interface{}
+
+	switch q.Kind {
+	case booleanKind:
+		value, err = ctx.FilterBool(q.Query)
+	case numberKind:
+		value, err = ctx.FilterNumber(q.Query)
+	case stringKind:
+		value, err = ctx.FilterString(q.Query)
+	default:
+		var arr nodes.Array
+		arr, err = applyXpath(n, q.Query)
+		if err != nil {
+			return service.XPathResult{}, err
+		}
+
+		// the expressions that do not select nodes, like count(//*),
+		// evaluate to a single value
+		if q.Kind == "" && len(arr) == 1 {
+			if kind := valueKind(arr[0]); kind != "" {
+				return service.XPathResult{Kind: kind, Value: arr[0]}, nil
+			}
+		}
+
+		return service.XPathResult{Kind: nodesKind, Value: arr}, nil
+	}
+
+	if err != nil {
+		return service.XPathResult{}, err
+	}
+
+	return service.XPathResult{Kind: q.Kind, Value: value}, nil
+}
+
+// stringArg returns the argument of a string(...) expression. The xpath
+// engine does not support node-set arguments in string(), so these
+// expressions are evaluated by xpathString
+func stringArg(query string) (string, bool) {
+	query = strings.TrimSpace(query)
+	if !strings.HasPrefix(query, "string(") || !strings.HasSuffix(query, ")") {
+		return "", false
+	}
+
+	arg := query[len("string(") : len(query)-1]
+
+	// the closing parenthesis must be the one of string(, as in
+	// string(a) or string(b) it is not
+	depth := 0
+	var quote rune
+	for _, c := range arg {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth < 0 {
+				return "", false
+			}
+		}
+	}
+
+	return arg, depth == 0 && strings.TrimSpace(arg) != ""
+}
+
+// attributeRegexp matches the attribute selected at the end of a path
+var attributeRegexp = regexp.MustCompile(`/@([\w.:-]+)\s*$`)
+
+// xpathString returns the string value of the first result of the
+// expression, like the xpath string() function. The engine selects the
+// node of an attribute, so the attribute is read from it. The value of the
+// other nodes is the concatenation of their tokens, and an empty result is an
+// empty string
+func xpathString(n nodes.Node, query string) (string, error) {
+	arr, err := applyXpath(n, query)
+	if err != nil {
+		return "", err
+	}
+
+	if len(arr) == 0 {
+		return "", nil
+	}
+
+	obj, ok := arr[0].(nodes.Object)
+	if !ok {
+		return nodeString(arr[0]), nil
+	}
+
+	if m := attributeRegexp.FindStringSubmatch(query); m != nil {
+		return nodeString(obj[m[1]]), nil
+	}
+
+	return strings.Join(uast.Tokens(obj), ""), nil
+}
+
+// nodeString returns the string value of a scalar node, or an empty string
+// for the objects, the arrays and nil
+func nodeString(n nodes.Node) string {
+	if v, ok := n.(nodes.Value); ok {
+		return fmt.Sprint(v)
+	}
+
+	return ""
+}
+
+// recoverXpath turns the panics of the xpath engine into bad request errors.
+// It panics with some unsupported expressions
+func recoverXpath(query string, err *error) {
+	if r := recover(); r != nil {
+		*err = serializer.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("unsupported xpath expression %q: %v", query, r))
+	}
+}
+
+// valueKind returns the kind of a scalar node, or an empty string for the
+// objects and arrays
+func valueKind(n nodes.Node) xpathKind {
+	switch n.(type) {
+	case nodes.Bool:
+		return booleanKind
+	case nodes.Int, nodes.Uint, nodes.Float:
+		return numberKind
+	case nodes.String:
+		return stringKind
+	}
+
+	return ""
+}
+
+// encodeXpaths returns a copy of the results with the nodes encoded in the
+// given output format
+func encodeXpaths(
+	results map[string]service.XPathResult,
+	output uastutil.Format,
+) (map[string]service.XPathResult, error) {
+	if results == nil {
+		return nil, nil
+	}
+
+	encoded := make(map[string]service.XPathResult, len(results))
+	for name, res := range results {
+		if res.Kind == nodesKind {
+			value, err := uastutil.Encode(res.Value.(nodes.Array), output)
+			if err != nil {
+				return nil, err
+			}
+
+			res.Value = value
+		}
+
+		encoded[name] = res
+	}
+
+	return encoded, nil
+}
@@ -0,0 +1,156 @@
+// Package memstore keeps values in memory for a limited time, like the UASTs
+// and the long text cells of the query results, so the clients can refer to
+// them by an opaque handle instead of sending them back to the server.
+package memstore
+
+import (
+	"container/list"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxBytes is the default maximum size of the stored values
+	DefaultMaxBytes = 256 << 20
+	// DefaultTTL is the default time a value is kept since it was last used
+	DefaultTTL = 30 * time.Minute
+)
+
+// Options configures a Store
+type Options struct {
+	// MaxBytes is the maximum size of the stored values, as given to Put.
+	// The least recently used ones are removed to make room for the new ones
+	MaxBytes int64
+	// TTL is the time a value is kept since it was last used
+	TTL time.Duration
+}
+
+type entry struct {
+	handle   string
+	value    interface{}
+	size     int64
+	lastUsed time.Time
+}
+
+// Store keeps values by handle, bounded in size and expiring after some time
+// without use. The expired values are removed when the store is used
+type Store struct {
+	opts Options
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru has the most recently used entries at the front
+	lru  *list.List
+	size int64
+
+	now func() time.Time
+}
+
+// New returns an empty Store
+func New(opts Options) *Store {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultMaxBytes
+	}
+
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+
+	return &Store{
+		opts:    opts,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+// Put stores the value with the given size, and returns its handle. The
+// values larger than the maximum size are not stored, and get an empty
+// handle
+func (s *Store) Put(value interface{}, size int) string {
+	if int64(size) > s.opts.MaxBytes {
+		return ""
+	}
+
+	handle := newHandle()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.removeExpired(now)
+
+	for s.size+int64(size) > s.opts.MaxBytes {
+		s.remove(s.lru.Back())
+	}
+
+	s.entries[handle] = s.lru.PushFront(&entry{
+		handle:   handle,
+		value:    value,
+		size:     int64(size),
+		lastUsed: now,
+	})
+	s.size += int64(size)
+
+	return handle
+}
+
+// Get returns the value of the handle, and whether it was found. Each Get
+// extends the time the value is kept
+func (s *Store) Get(handle string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.removeExpired(now)
+
+	elem, ok := s.entries[handle]
+	if !ok {
+		return nil, false
+	}
+
+	e := elem.Value.(*entry)
+	e.lastUsed = now
+	s.lru.MoveToFront(elem)
+
+	return e.value, true
+}
+
+// Len returns the number of stored values
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lru.Len()
+}
+
+// removeExpired removes the entries not used since before the TTL. They are
+// at the back of the list
+func (s *Store) removeExpired(now time.Time) {
+	for {
+		elem := s.lru.Back()
+		if elem == nil || now.Sub(elem.Value.(*entry).lastUsed) < s.opts.TTL {
+			return
+		}
+
+		s.remove(elem)
+	}
+}
+
+func (s *Store) remove(elem *list.Element) {
+	e := s.lru.Remove(elem).(*entry)
+	delete(s.entries, e.handle)
+	s.size -= e.size
+}
+
+// newHandle returns a random handle that can not be guessed
+func newHandle() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
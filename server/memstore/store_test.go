@@ -0,0 +1,79 @@
+package memstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPutGet(t *testing.T) {
+	require := require.New(t)
+
+	s := New(Options{})
+
+	a := s.Put("a", 10)
+	b := s.Put("b", 10)
+	require.Len(a, 32)
+	require.NotEqual(a, b)
+
+	n, ok := s.Get(a)
+	require.True(ok)
+	require.Equal("a", n)
+
+	_, ok = s.Get("unknown")
+	require.False(ok)
+}
+
+func TestMaxBytes(t *testing.T) {
+	require := require.New(t)
+
+	s := New(Options{MaxBytes: 25})
+
+	a := s.Put("a", 10)
+	b := s.Put("b", 10)
+
+	// a is used, so b is the least recently used one
+	_, ok := s.Get(a)
+	require.True(ok)
+
+	c := s.Put("c", 10)
+	require.Equal(2, s.Len())
+
+	_, ok = s.Get(b)
+	require.False(ok)
+	_, ok = s.Get(a)
+	require.True(ok)
+	_, ok = s.Get(c)
+	require.True(ok)
+
+	require.Empty(s.Put("large", 26))
+	require.Equal(2, s.Len())
+}
+
+func TestTTL(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Now()
+	s := New(Options{TTL: time.Minute})
+	s.now = func() time.Time { return now }
+
+	a := s.Put("a", 10)
+	b := s.Put("b", 10)
+
+	now = now.Add(40 * time.Second)
+	_, ok := s.Get(a)
+	require.True(ok)
+
+	now = now.Add(40 * time.Second)
+	_, ok = s.Get(b)
+	require.False(ok)
+	_, ok = s.Get(a)
+	require.True(ok)
+
+	now = now.Add(2 * time.Minute)
+	require.Equal(1, s.Len())
+	_, ok = s.Get(a)
+	require.False(ok)
+	require.Equal(0, s.Len())
+}
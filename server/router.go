@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/src-d/gitbase-web/server/handler"
+	"github.com/src-d/gitbase-web/server/scheduler"
 	"github.com/src-d/gitbase-web/server/service"
 
 	"github.com/go-chi/chi"
@@ -13,19 +14,43 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// RouterOptions are the dependencies and limits of the handlers served by
+// Router, besides the database
+type RouterOptions struct {
+	// Bblfsh are the bblfsh servers used to parse the files
+	Bblfsh *handler.BblfshEndpoints
+	// Languages caches the languages supported by bblfsh
+	Languages *handler.LanguagesCache
+	// ParseWorkers is the number of files parsed concurrently by each
+	// /parse/batch request
+	ParseWorkers int
+	// Export limits the files returned by /export
+	Export handler.ExportLimits
+	// Search limits the matches found by /search and /search/structural
+	Search handler.SearchLimits
+	// Query keeps the UASTs and the truncated cells returned by /query
+	Query handler.QueryOptions
+	// Mailmap merges the identities of the authors in /analytics/contributors.
+	// It can be nil
+	Mailmap *service.Mailmap
+	// Scheduler runs the scheduled queries. Their endpoints are only served
+	// if it is not nil
+	Scheduler *scheduler.Scheduler
+}
+
 // Router returns a Handler to serve the backend
 func Router(
 	logger *logrus.Logger,
 	static *handler.Static,
 	version string,
 	db service.SQLDB,
-	bbblfshServerURL string,
+	opts RouterOptions,
 ) http.Handler {
 
 	// cors options
 	corsOptions := cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Location", "Authorization", "Content-Type"},
 		AllowCredentials: true,
 	}
@@ -36,16 +61,43 @@ func Router(
 	r.Use(cors.New(corsOptions).Handler)
 	r.Use(lg.RequestLogger(logger))
 
-	r.Post("/query", handler.APIHandlerFunc(handler.Query(db)))
+	r.Post("/query", handler.APIHandlerFunc(handler.Query(db, opts.Query)))
+	r.Get("/cell", handler.APIHandlerFunc(handler.Cell(opts.Query.Cells)))
 	r.Get("/schema", handler.APIHandlerFunc(handler.Schema(db)))
-	r.Get("/export", handler.Export(db))
+	r.Get("/export", handler.Export(db, opts.Export))
+	r.Get("/search", handler.APIHandlerFunc(handler.Search(db, opts.Search)))
+	r.Post("/search/structural", handler.APIHandlerFunc(handler.StructuralSearch(db, opts.Search, opts.Languages)))
+
+	r.Get("/repos", handler.APIHandlerFunc(handler.ListRepositories(db)))
+	r.Get("/repos/{id}/refs", handler.APIHandlerFunc(handler.ListRefs(db)))
+	r.Get("/repos/{id}/tree", handler.APIHandlerFunc(handler.GetTree(db)))
+	r.Get("/repos/{id}/file", handler.APIHandlerFunc(handler.GetFile(db)))
+	r.Get("/repos/{id}/commits", handler.APIHandlerFunc(handler.ListCommits(db)))
+	r.Get("/repos/{id}/commits/{hash}", handler.APIHandlerFunc(handler.GetCommit(db)))
+	r.Get("/repos/{id}/diff", handler.Diff(db))
 
-	r.Post("/parse", handler.APIHandlerFunc(handler.Parse(bbblfshServerURL)))
-	r.Post("/filter", handler.APIHandlerFunc(handler.Filter()))
+	r.Get("/analytics/contributors", handler.APIHandlerFunc(handler.Contributors(db, opts.Mailmap)))
+
+	r.Post("/parse", handler.APIHandlerFunc(handler.Parse(db, opts.Bblfsh, opts.Languages)))
+	r.Post("/parse/batch", handler.ParseBatch(opts.Bblfsh, opts.ParseWorkers))
+	r.Post("/uast/diff", handler.APIHandlerFunc(handler.UASTDiff(db, opts.Bblfsh)))
+	r.Post("/uast/at", handler.APIHandlerFunc(handler.UASTAt(db, opts.Bblfsh)))
+	r.Post("/uast/stats", handler.APIHandlerFunc(handler.UASTStats(opts.Bblfsh)))
+	r.Post("/filter", handler.APIHandlerFunc(handler.Filter(opts.Query.UASTs)))
 	r.Post("/detect-lang", handler.APIHandlerFunc(handler.DetectLanguage()))
-	r.Get("/get-languages", handler.APIHandlerFunc(handler.GetLanguages(bbblfshServerURL)))
+	r.Post("/detect-lang/batch", handler.APIHandlerFunc(handler.DetectLanguageBatch()))
+	r.Get("/get-languages", handler.APIHandlerFunc(handler.GetLanguages(opts.Languages)))
+
+	if opts.Scheduler != nil {
+		r.Get("/schedules", handler.APIHandlerFunc(handler.ListSchedules(opts.Scheduler)))
+		r.Post("/schedules", handler.APIHandlerFunc(handler.CreateSchedule(opts.Scheduler)))
+		r.Delete("/schedules/{id}", handler.APIHandlerFunc(handler.DeleteSchedule(opts.Scheduler)))
+		r.Get("/schedules/{id}/runs", handler.APIHandlerFunc(handler.ListRuns(opts.Scheduler)))
+		r.Get("/schedules/{id}/runs/{run}", handler.APIHandlerFunc(handler.GetSnapshot(opts.Scheduler)))
+		r.Get("/schedules/{id}/compare", handler.APIHandlerFunc(handler.CompareSnapshots(opts.Scheduler)))
+	}
 
-	r.Get("/version", handler.APIHandlerFunc(handler.Version(version, bbblfshServerURL, db)))
+	r.Get("/version", handler.APIHandlerFunc(handler.Version(version, opts.Bblfsh.Default, db)))
 
 	r.Get("/static/*", static.ServeHTTP)
 	r.Get("/*", static.ServeHTTP)
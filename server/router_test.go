@@ -6,9 +6,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/src-d/gitbase-web/server"
+	"github.com/src-d/gitbase-web/server/bblfshpool"
 	"github.com/src-d/gitbase-web/server/handler"
+	"github.com/src-d/gitbase-web/server/memstore"
 	"github.com/src-d/gitbase-web/server/service"
 	testingTools "github.com/src-d/gitbase-web/server/testing"
 
@@ -34,14 +37,19 @@ func (s *RouterTestSuite) SetupSuite() {
 	(&log.LoggerFactory{}).ApplyToLogrus()
 
 	staticHandler := &handler.Static{}
+	bblfshPool, err := bblfshpool.New([]string{"127.0.0.1:0"}, bblfshpool.Options{Timeout: time.Second})
+	s.Require().NoError(err)
+
 	s.db = &testingTools.MockDB{}
-	s.router = server.Router(
-		logrus.StandardLogger(),
-		staticHandler,
-		version,
-		s.db,
-		"",
-	)
+	s.router = server.Router(logrus.StandardLogger(), staticHandler, version, s.db, server.RouterOptions{
+		Bblfsh:       &handler.BblfshEndpoints{Default: bblfshPool},
+		Languages:    handler.NewLanguagesCache(bblfshPool, 0),
+		ParseWorkers: 1,
+		Query: handler.QueryOptions{
+			UASTs: memstore.New(memstore.Options{}),
+			Cells: memstore.New(memstore.Options{}),
+		},
+	})
 }
 
 func (s *RouterTestSuite) SetupTest() {
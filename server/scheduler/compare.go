@@ -0,0 +1,177 @@
+package scheduler
+
+import (
+	"encoding/json"
+
+	errors "gopkg.in/src-d/go-errors.v1"
+)
+
+// ErrInvalidKey is returned when a key column used to compare two snapshots
+// does not exist in any of them
+var ErrInvalidKey = errors.NewKind("key column %q is not part of both snapshots")
+
+// Row is a row of a snapshot
+type Row = map[string]interface{}
+
+// Change is a row that exists in both snapshots with the same key, but with
+// different values
+type Change struct {
+	Key  Row `json:"key"`
+	From Row `json:"from"`
+	To   Row `json:"to"`
+}
+
+// Diff contains the differences between two snapshots
+type Diff struct {
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	Keys      []string `json:"keys,omitempty"`
+	Added     []Row    `json:"added"`
+	Removed   []Row    `json:"removed"`
+	Changed   []Change `json:"changed"`
+	Unchanged int      `json:"unchanged"`
+}
+
+// Compare returns the differences between the snapshots of two runs of a
+// schedule. If keys is empty, rows are compared using all their values, and
+// changes are reported as a removed and an added row. Otherwise rows with the
+// same values in the key columns are paired, and reported as changed if any
+// other value is different
+func (s *Scheduler) Compare(id, fromRun, toRun string, keys []string) (*Diff, error) {
+	from, err := s.Snapshot(id, fromRun)
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := s.Snapshot(id, toRun)
+	if err != nil {
+		return nil, err
+	}
+
+	diff, err := CompareSnapshots(from, to, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	diff.From = fromRun
+	diff.To = toRun
+	return diff, nil
+}
+
+// CompareSnapshots returns the differences between two snapshots, see
+// Scheduler.Compare
+func CompareSnapshots(from, to *Snapshot, keys []string) (*Diff, error) {
+	for _, key := range keys {
+		if !contains(from.Headers, key) || !contains(to.Headers, key) {
+			return nil, ErrInvalidKey.New(key)
+		}
+	}
+
+	diff := &Diff{
+		Keys:    keys,
+		Added:   []Row{},
+		Removed: []Row{},
+		Changed: []Change{},
+	}
+
+	// rows are indexed by the JSON of their key, several rows can share the
+	// same key when keys is empty and there are duplicated rows
+	index := make(map[string][]Row)
+	var order []string
+	for _, row := range from.Rows {
+		k, err := rowKey(row, keys)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := index[k]; !ok {
+			order = append(order, k)
+		}
+		index[k] = append(index[k], row)
+	}
+
+	for _, row := range to.Rows {
+		k, err := rowKey(row, keys)
+		if err != nil {
+			return nil, err
+		}
+
+		prev := index[k]
+		if len(prev) == 0 {
+			diff.Added = append(diff.Added, row)
+			continue
+		}
+
+		old := prev[0]
+		index[k] = prev[1:]
+
+		if len(keys) == 0 {
+			diff.Unchanged++
+			continue
+		}
+
+		equal, err := sameRow(old, row)
+		if err != nil {
+			return nil, err
+		}
+
+		if equal {
+			diff.Unchanged++
+			continue
+		}
+
+		diff.Changed = append(diff.Changed, Change{
+			Key:  keyValues(row, keys),
+			From: old,
+			To:   row,
+		})
+	}
+
+	for _, k := range order {
+		diff.Removed = append(diff.Removed, index[k]...)
+	}
+
+	return diff, nil
+}
+
+// rowKey returns the JSON encoding of the values of the key columns, or of
+// the full row if there are no keys. encoding/json sorts the map keys, so the
+// encoding is stable
+func rowKey(row Row, keys []string) (string, error) {
+	v := row
+	if len(keys) > 0 {
+		v = keyValues(row, keys)
+	}
+
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func keyValues(row Row, keys []string) Row {
+	result := make(Row, len(keys))
+	for _, key := range keys {
+		result[key] = row[key]
+	}
+
+	return result
+}
+
+func sameRow(a, b Row) (bool, error) {
+	ka, err := rowKey(a, nil)
+	if err != nil {
+		return false, err
+	}
+
+	kb, err := rowKey(b, nil)
+	return ka == kb, err
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
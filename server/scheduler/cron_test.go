@@ -0,0 +1,113 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		panic(err)
+	}
+
+	return t
+}
+
+func TestParseCronErrors(t *testing.T) {
+	testCases := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"foo * * * *",
+		"@every",
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc, func(t *testing.T) {
+			_, err := ParseCron(tc)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestCronMatches(t *testing.T) {
+	testCases := []struct {
+		expr    string
+		time    string
+		matches bool
+	}{
+		{"* * * * *", "2018-10-10 10:10", true},
+		{"0 9 * * *", "2018-10-10 09:00", true},
+		{"0 9 * * *", "2018-10-10 09:01", false},
+		{"*/15 * * * *", "2018-10-10 09:45", true},
+		{"*/15 * * * *", "2018-10-10 09:46", false},
+		{"10-20/5 * * * *", "2018-10-10 09:15", true},
+		{"10-20/5 * * * *", "2018-10-10 09:25", false},
+		{"5/20 * * * *", "2018-10-10 09:45", true},
+		{"0,30 8-10 * * *", "2018-10-10 10:30", true},
+		{"0,30 8-10 * * *", "2018-10-10 11:30", false},
+		// 2018-10-10 is a Wednesday
+		{"0 0 * * WED", "2018-10-10 00:00", true},
+		{"0 0 * * mon-fri", "2018-10-13 00:00", false},
+		{"0 0 * * 7", "2018-10-14 00:00", true},
+		{"0 0 * * 0", "2018-10-14 00:00", true},
+		{"0 0 1 JAN *", "2019-01-01 00:00", true},
+		// day of month or day of week when both are restricted
+		{"0 0 1 * MON", "2018-10-01 00:00", true},
+		{"0 0 1 * MON", "2018-10-08 00:00", true},
+		{"0 0 1 * MON", "2018-10-09 00:00", false},
+		{"@daily", "2018-10-09 00:00", true},
+		{"@hourly", "2018-10-09 07:00", true},
+		{"@hourly", "2018-10-09 07:01", false},
+		{"@weekly", "2018-10-14 00:00", true},
+		{"@monthly", "2018-10-01 00:00", true},
+		{"@yearly", "2018-10-01 00:00", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expr+" "+tc.time, func(t *testing.T) {
+			c, err := ParseCron(tc.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tc.matches, c.Matches(date(tc.time)))
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	testCases := []struct {
+		expr string
+		from string
+		next string
+	}{
+		{"* * * * *", "2018-10-10 10:10", "2018-10-10 10:11"},
+		{"0 9 * * *", "2018-10-10 09:00", "2018-10-11 09:00"},
+		{"0 9 * * *", "2018-10-10 08:59", "2018-10-10 09:00"},
+		{"30 * * * *", "2018-12-31 23:45", "2019-01-01 00:30"},
+		{"0 0 29 2 *", "2018-03-01 00:00", "2020-02-29 00:00"},
+		{"0 12 * * FRI", "2018-10-10 13:00", "2018-10-12 12:00"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expr+" "+tc.from, func(t *testing.T) {
+			c, err := ParseCron(tc.expr)
+			require.NoError(t, err)
+			assert.Equal(t, date(tc.next), c.Next(date(tc.from)))
+		})
+	}
+
+	c, err := ParseCron("0 0 30 2 *")
+	require.NoError(t, err)
+	assert.True(t, c.Next(date("2018-10-10 10:10")).IsZero())
+}
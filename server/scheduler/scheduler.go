@@ -0,0 +1,408 @@
+// Package scheduler runs saved SQL queries periodically, following cron
+// expressions, and stores the results of each run as snapshots that can be
+// listed and compared later.
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// Schedule is a saved SQL query attached to a cron expression
+type Schedule struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Query string `json:"query"`
+	Cron  string `json:"cron"`
+	// Timeout for each run, in seconds. If it is 0 the Scheduler default
+	// timeout is used
+	Timeout int `json:"timeout,omitempty"`
+	// MaxRuns is the number of runs kept, with their snapshots. The older
+	// ones are deleted. If it is 0 the Scheduler default is used
+	MaxRuns int       `json:"maxRuns,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// RunStatus is the result of a run
+type RunStatus = string
+
+const (
+	// RunSuccess is the status of the runs that stored a snapshot
+	RunSuccess RunStatus = "success"
+	// RunError is the status of the runs that failed
+	RunError RunStatus = "error"
+)
+
+// Run describes one execution of a schedule
+type Run struct {
+	ID         string    `json:"id"`
+	ScheduleID string    `json:"scheduleId"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Status     RunStatus `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Rows       int       `json:"rows"`
+	// Truncated is the reason why the snapshot was truncated, if it was
+	Truncated string `json:"truncated,omitempty"`
+}
+
+// Snapshot is the result of a query, in the same format as the /query
+// responses. Truncated is the reason why the rows were truncated, if they
+// were
+type Snapshot struct {
+	Headers   []string                 `json:"headers"`
+	Types     []string                 `json:"types"`
+	Rows      []map[string]interface{} `json:"rows"`
+	Truncated string                   `json:"truncated,omitempty"`
+}
+
+// QueryFunc runs an SQL query and returns its results. It must stop when
+// the context is done
+type QueryFunc func(ctx context.Context, query string) (*Snapshot, error)
+
+// runIDFormat is used to create the run IDs from their start time. Runs are
+// started at most once per minute, and the IDs sort chronologically
+const runIDFormat = "20060102T1504Z"
+
+// Options are the defaults of the schedules that do not define their own
+type Options struct {
+	// Timeout for each run. 0 means no timeout
+	Timeout time.Duration
+	// MaxRuns is the number of runs kept for each schedule. 0 means no
+	// limit
+	MaxRuns int
+}
+
+// Scheduler runs the stored schedules when their cron expressions match
+type Scheduler struct {
+	store *Store
+	query QueryFunc
+	opts  Options
+
+	mu        sync.Mutex
+	schedules map[string]*entry
+
+	now  func() time.Time
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+type entry struct {
+	Schedule
+	cron *Cron
+
+	// running is set while a run is in progress, that can be stopped with
+	// cancel, and closes done when it finishes
+	running bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+	// removed is set while the schedule is being removed, so it is not run
+	removed bool
+}
+
+// New returns a Scheduler that keeps its schedules and snapshots in the
+// store, and runs the queries with the given QueryFunc. The options are used
+// for the schedules that do not define their own
+func New(store *Store, query QueryFunc, opts Options) (*Scheduler, error) {
+	s := &Scheduler{
+		store:     store,
+		query:     query,
+		opts:      opts,
+		schedules: make(map[string]*entry),
+		now:       time.Now,
+	}
+
+	schedules, err := store.Schedules()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sched := range schedules {
+		cron, err := ParseCron(sched.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("stored schedule %s: %s", sched.ID, err)
+		}
+
+		s.schedules[sched.ID] = &entry{Schedule: sched, cron: cron}
+	}
+
+	return s, nil
+}
+
+// Start starts running the schedules in the background
+func (s *Scheduler) Start() {
+	s.stop = make(chan struct{})
+
+	s.wg.Add(1)
+	go s.loop()
+}
+
+// Stop stops the scheduler, and waits for the runs in progress
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+// loop wakes up at the start of every minute to run the schedules that match
+// that minute
+func (s *Scheduler) loop() {
+	defer s.wg.Done()
+
+	for {
+		now := s.now()
+		next := now.Truncate(time.Minute).Add(time.Minute)
+
+		select {
+		case <-time.After(next.Sub(now)):
+			s.runDue(next)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// runDue starts the runs of the schedules that match the given time. If the
+// previous run of a schedule is still in progress, it is skipped
+func (s *Scheduler) runDue(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.schedules {
+		if e.removed || !e.cron.Matches(t) {
+			continue
+		}
+
+		if e.running {
+			log.With(log.Fields{"schedule": e.ID}).
+				Warningf("skipping run, the previous one is still in progress")
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		e.running = true
+		e.cancel = cancel
+		e.done = make(chan struct{})
+
+		s.wg.Add(1)
+		go func(e *entry) {
+			defer s.wg.Done()
+
+			s.run(ctx, e.Schedule, t)
+			cancel()
+
+			s.mu.Lock()
+			e.running = false
+			close(e.done)
+			s.mu.Unlock()
+		}(e)
+	}
+}
+
+// run executes the query of a schedule and stores the run and its snapshot,
+// deleting the oldest runs beyond its maximum. The query is stopped when the
+// context is done
+func (s *Scheduler) run(ctx context.Context, sched Schedule, t time.Time) Run {
+	timeout := s.opts.Timeout
+	if sched.Timeout > 0 {
+		timeout = time.Duration(sched.Timeout) * time.Second
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	run := Run{
+		ID:         t.UTC().Format(runIDFormat),
+		ScheduleID: sched.ID,
+		Start:      s.now(),
+	}
+
+	snapshot, err := s.query(ctx, sched.Query)
+	run.End = s.now()
+
+	if err != nil {
+		run.Status = RunError
+		run.Error = err.Error()
+		snapshot = nil
+	} else {
+		run.Status = RunSuccess
+		run.Rows = len(snapshot.Rows)
+		run.Truncated = snapshot.Truncated
+	}
+
+	logger := log.With(log.Fields{"schedule": sched.ID, "run": run.ID})
+	if err := s.store.SaveRun(run, snapshot); err != nil {
+		logger.Errorf(err, "could not store the run")
+	} else if run.Status == RunError {
+		logger.Warningf("run failed: %s", run.Error)
+	} else if run.Truncated != "" {
+		logger.Warningf("run finished with %d rows, truncated: %s", run.Rows, run.Truncated)
+	} else {
+		logger.Infof("run finished with %d rows", run.Rows)
+	}
+
+	maxRuns := s.opts.MaxRuns
+	if sched.MaxRuns > 0 {
+		maxRuns = sched.MaxRuns
+	}
+
+	if err := s.store.PruneRuns(sched.ID, maxRuns); err != nil {
+		logger.Errorf(err, "could not delete the old runs")
+	}
+
+	return run
+}
+
+// Add validates and stores a new schedule, that is run from then on
+func (s *Scheduler) Add(sched Schedule) (Schedule, error) {
+	cron, err := ParseCron(sched.Cron)
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	if sched.Query == "" {
+		return Schedule{}, fmt.Errorf("the query can't be empty")
+	}
+
+	if sched.Timeout < 0 {
+		return Schedule{}, fmt.Errorf("the timeout can't be negative")
+	}
+
+	if sched.MaxRuns < 0 {
+		return Schedule{}, fmt.Errorf("the maximum number of runs can't be negative")
+	}
+
+	sched.ID, err = newID()
+	if err != nil {
+		return Schedule{}, err
+	}
+	sched.Created = s.now()
+
+	if err := s.store.SaveSchedule(sched); err != nil {
+		return Schedule{}, err
+	}
+
+	s.mu.Lock()
+	s.schedules[sched.ID] = &entry{Schedule: sched, cron: cron}
+	s.mu.Unlock()
+
+	return sched, nil
+}
+
+// Remove deletes a schedule and its snapshots, and returns the removed
+// schedule. A run in progress is canceled, and it is waited for, so it does
+// not store its snapshot after the others are deleted
+func (s *Scheduler) Remove(id string) (Schedule, error) {
+	s.mu.Lock()
+	e, ok := s.schedules[id]
+	if !ok || e.removed {
+		s.mu.Unlock()
+		return Schedule{}, ErrNotFound.New("schedule " + id)
+	}
+
+	e.removed = true
+	var done chan struct{}
+	if e.running {
+		e.cancel()
+		done = e.done
+	}
+	s.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+
+	// the schedule is kept if it could not be deleted from the store
+	err := s.store.DeleteSchedule(id)
+
+	s.mu.Lock()
+	if err != nil {
+		e.removed = false
+	} else {
+		delete(s.schedules, id)
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	return e.Schedule, nil
+}
+
+// ScheduleInfo is a Schedule with the time of its next run
+type ScheduleInfo struct {
+	Schedule
+	Next *time.Time `json:"next,omitempty"`
+}
+
+// Schedules returns all the schedules, sorted by creation time
+func (s *Scheduler) Schedules() []ScheduleInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	result := make([]ScheduleInfo, 0, len(s.schedules))
+	for _, e := range s.schedules {
+		info := ScheduleInfo{Schedule: e.Schedule}
+		if next := e.cron.Next(now); !next.IsZero() {
+			info.Next = &next
+		}
+
+		result = append(result, info)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Created.Equal(result[j].Created) {
+			return result[i].ID < result[j].ID
+		}
+
+		return result[i].Created.Before(result[j].Created)
+	})
+
+	return result
+}
+
+// Runs returns the runs of a schedule, sorted from the oldest to the newest
+func (s *Scheduler) Runs(id string) ([]Run, error) {
+	if !s.exists(id) {
+		return nil, ErrNotFound.New("schedule " + id)
+	}
+
+	return s.store.Runs(id)
+}
+
+// Snapshot returns the snapshot of a run
+func (s *Scheduler) Snapshot(id, runID string) (*Snapshot, error) {
+	if !s.exists(id) {
+		return nil, ErrNotFound.New("schedule " + id)
+	}
+
+	return s.store.Snapshot(id, runID)
+}
+
+func (s *Scheduler) exists(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.schedules[id]
+	return ok
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,263 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type SchedulerSuite struct {
+	suite.Suite
+	dir     string
+	store   *Store
+	sched   *Scheduler
+	queries []string
+	result  *Snapshot
+	err     error
+}
+
+func TestSchedulerSuite(t *testing.T) {
+	suite.Run(t, new(SchedulerSuite))
+}
+
+func (s *SchedulerSuite) SetupTest() {
+	var err error
+	s.dir, err = ioutil.TempDir("", "scheduler-test")
+	s.Require().NoError(err)
+
+	s.store, err = NewStore(s.dir)
+	s.Require().NoError(err)
+
+	s.queries = nil
+	s.result = &Snapshot{
+		Headers: []string{"repo", "todos"},
+		Types:   []string{"TEXT", "INT"},
+		Rows:    []map[string]interface{}{{"repo": "a", "todos": 1}},
+	}
+	s.err = nil
+
+	s.sched, err = New(s.store, s.query, Options{Timeout: time.Minute})
+	s.Require().NoError(err)
+	s.sched.now = func() time.Time { return date("2018-10-10 10:10") }
+}
+
+func (s *SchedulerSuite) TearDownTest() {
+	os.RemoveAll(s.dir)
+}
+
+func (s *SchedulerSuite) query(ctx context.Context, query string) (*Snapshot, error) {
+	s.queries = append(s.queries, query)
+
+	if _, ok := ctx.Deadline(); !ok {
+		return nil, fmt.Errorf("the context has no deadline")
+	}
+
+	return s.result, s.err
+}
+
+func (s *SchedulerSuite) TestAdd() {
+	require := s.Require()
+
+	_, err := s.sched.Add(Schedule{Query: "SELECT 1", Cron: "wrong"})
+	require.Error(err)
+
+	_, err = s.sched.Add(Schedule{Cron: "* * * * *"})
+	require.Error(err)
+
+	sched, err := s.sched.Add(Schedule{Name: "todos", Query: "SELECT 1", Cron: "0 * * * *"})
+	require.NoError(err)
+	require.NotEmpty(sched.ID)
+	require.Equal(date("2018-10-10 10:10"), sched.Created)
+
+	list := s.sched.Schedules()
+	require.Len(list, 1)
+	require.Equal(sched, list[0].Schedule)
+	require.Equal(date("2018-10-10 11:00"), *list[0].Next)
+
+	// a new scheduler loads the stored schedules
+	other, err := New(s.store, s.query, Options{Timeout: time.Minute})
+	require.NoError(err)
+	require.Len(other.Schedules(), 1)
+
+	removed, err := s.sched.Remove(sched.ID)
+	require.NoError(err)
+	require.Equal(sched, removed)
+	require.Empty(s.sched.Schedules())
+
+	_, err = s.sched.Remove(sched.ID)
+	require.True(ErrNotFound.Is(err))
+}
+
+func (s *SchedulerSuite) TestRunDue() {
+	require := s.Require()
+
+	hourly, err := s.sched.Add(Schedule{Query: "SELECT 1", Cron: "0 * * * *"})
+	require.NoError(err)
+	_, err = s.sched.Add(Schedule{Query: "SELECT 2", Cron: "30 * * * *"})
+	require.NoError(err)
+
+	s.sched.runDue(date("2018-10-10 11:00"))
+	s.sched.wg.Wait()
+
+	require.Equal([]string{"SELECT 1"}, s.queries)
+
+	runs, err := s.sched.Runs(hourly.ID)
+	require.NoError(err)
+	require.Len(runs, 1)
+	require.Equal("20181010T1100Z", runs[0].ID)
+	require.Equal(RunSuccess, runs[0].Status)
+	require.Equal(1, runs[0].Rows)
+
+	snapshot, err := s.sched.Snapshot(hourly.ID, runs[0].ID)
+	require.NoError(err)
+	require.Equal(s.result.Headers, snapshot.Headers)
+	require.Len(snapshot.Rows, 1)
+
+	_, err = s.sched.Snapshot(hourly.ID, "nope")
+	require.True(ErrNotFound.Is(err))
+
+	_, err = s.sched.Snapshot(hourly.ID, "../../schedules")
+	require.True(ErrNotFound.Is(err))
+
+	_, err = s.sched.Runs("nope")
+	require.True(ErrNotFound.Is(err))
+}
+
+func (s *SchedulerSuite) TestRunError() {
+	require := s.Require()
+
+	sched, err := s.sched.Add(Schedule{Query: "SELECT 1", Cron: "* * * * *", Timeout: 5})
+	require.NoError(err)
+
+	s.err = fmt.Errorf("forced err")
+	run := s.sched.run(context.Background(), sched, date("2018-10-10 11:00"))
+	require.Equal(RunError, run.Status)
+	require.Equal("forced err", run.Error)
+
+	runs, err := s.sched.Runs(sched.ID)
+	require.NoError(err)
+	require.Equal([]Run{run}, runs)
+
+	_, err = s.sched.Snapshot(sched.ID, run.ID)
+	require.True(ErrNotFound.Is(err))
+}
+
+func (s *SchedulerSuite) TestMaxRuns() {
+	require := s.Require()
+
+	s.sched.opts.MaxRuns = 3
+	sched, err := s.sched.Add(Schedule{Query: "SELECT 1", Cron: "* * * * *", MaxRuns: 2})
+	require.NoError(err)
+
+	_, err = s.sched.Add(Schedule{Query: "SELECT 1", Cron: "* * * * *", MaxRuns: -1})
+	require.Error(err)
+
+	s.result.Truncated = "the results reached the maximum of 1 rows"
+	for _, t := range []string{"11:00", "11:01", "11:02"} {
+		s.sched.run(context.Background(), sched, date("2018-10-10 "+t))
+	}
+
+	// the oldest run is deleted with its snapshot
+	runs, err := s.sched.Runs(sched.ID)
+	require.NoError(err)
+	require.Len(runs, 2)
+	require.Equal("20181010T1101Z", runs[0].ID)
+	require.Equal("20181010T1102Z", runs[1].ID)
+	require.Equal(s.result.Truncated, runs[1].Truncated)
+
+	_, err = s.sched.Snapshot(sched.ID, "20181010T1100Z")
+	require.True(ErrNotFound.Is(err))
+
+	snapshot, err := s.sched.Snapshot(sched.ID, runs[1].ID)
+	require.NoError(err)
+	require.Equal(s.result.Truncated, snapshot.Truncated)
+}
+
+func (s *SchedulerSuite) TestRemoveRunning() {
+	require := s.Require()
+
+	started := make(chan struct{})
+	sched, err := New(s.store, func(ctx context.Context, query string) (*Snapshot, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}, Options{Timeout: time.Hour})
+	require.NoError(err)
+	sched.now = s.sched.now
+
+	added, err := sched.Add(Schedule{Query: "SELECT 1", Cron: "* * * * *"})
+	require.NoError(err)
+
+	sched.runDue(date("2018-10-10 11:00"))
+	<-started
+
+	// the run is canceled, and its snapshot is not stored after the removal
+	removed, err := sched.Remove(added.ID)
+	require.NoError(err)
+	require.Equal(added, removed)
+
+	_, err = os.Stat(s.store.runsPath(added.ID))
+	require.True(os.IsNotExist(err))
+	require.Empty(sched.Schedules())
+
+	_, err = sched.Remove(added.ID)
+	require.True(ErrNotFound.Is(err))
+}
+
+func (s *SchedulerSuite) TestCompare() {
+	require := s.Require()
+
+	sched, err := s.sched.Add(Schedule{Query: "SELECT 1", Cron: "* * * * *"})
+	require.NoError(err)
+
+	s.result = &Snapshot{
+		Headers: []string{"repo", "todos"},
+		Rows: []map[string]interface{}{
+			{"repo": "a", "todos": 1},
+			{"repo": "b", "todos": 2},
+			{"repo": "c", "todos": 3},
+		},
+	}
+	from := s.sched.run(context.Background(), sched, date("2018-10-10 11:00"))
+
+	s.result = &Snapshot{
+		Headers: []string{"repo", "todos"},
+		Rows: []map[string]interface{}{
+			{"repo": "a", "todos": 1},
+			{"repo": "b", "todos": 5},
+			{"repo": "d", "todos": 4},
+		},
+	}
+	to := s.sched.run(context.Background(), sched, date("2018-10-10 11:01"))
+
+	diff, err := s.sched.Compare(sched.ID, from.ID, to.ID, []string{"repo"})
+	require.NoError(err)
+
+	require.Equal(from.ID, diff.From)
+	require.Equal(to.ID, diff.To)
+	require.Equal(1, diff.Unchanged)
+	require.Len(diff.Added, 1)
+	require.Equal("d", diff.Added[0]["repo"])
+	require.Len(diff.Removed, 1)
+	require.Equal("c", diff.Removed[0]["repo"])
+	require.Len(diff.Changed, 1)
+	require.Equal(map[string]interface{}{"repo": "b"}, diff.Changed[0].Key)
+	require.EqualValues(2, diff.Changed[0].From["todos"])
+	require.EqualValues(5, diff.Changed[0].To["todos"])
+
+	// without keys, a change is a removed and an added row
+	diff, err = s.sched.Compare(sched.ID, from.ID, to.ID, nil)
+	require.NoError(err)
+	require.Equal(1, diff.Unchanged)
+	require.Len(diff.Added, 2)
+	require.Len(diff.Removed, 2)
+	require.Empty(diff.Changed)
+
+	_, err = s.sched.Compare(sched.ID, from.ID, to.ID, []string{"nope"})
+	require.True(ErrInvalidKey.Is(err))
+}
@@ -0,0 +1,261 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	errors "gopkg.in/src-d/go-errors.v1"
+)
+
+var (
+	// ErrNotFound is returned when a schedule, run or snapshot does not exist
+	ErrNotFound = errors.NewKind("%s not found")
+)
+
+const (
+	schedulesFile  = "schedules.json"
+	runsDir        = "runs"
+	runSuffix      = ".run.json"
+	snapshotSuffix = ".snapshot.json"
+)
+
+// Store persists the schedules, their runs and the result snapshots as JSON
+// files in a local directory, with this layout:
+//
+//	schedules.json
+//	runs/<schedule id>/<run id>.run.json
+//	runs/<schedule id>/<run id>.snapshot.json
+type Store struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewStore returns a Store that uses the given directory, creating it if
+// needed
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Join(dir, runsDir), 0755); err != nil {
+		return nil, err
+	}
+
+	return &Store{dir: dir}, nil
+}
+
+// Schedules returns all the stored schedules
+func (s *Store) Schedules() ([]Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.schedules()
+}
+
+// SaveSchedule creates or replaces a schedule
+func (s *Store) SaveSchedule(sched Schedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedules, err := s.schedules()
+	if err != nil {
+		return err
+	}
+
+	replaced := false
+	for i, old := range schedules {
+		if old.ID == sched.ID {
+			schedules[i] = sched
+			replaced = true
+		}
+	}
+
+	if !replaced {
+		schedules = append(schedules, sched)
+	}
+
+	return s.writeJSON(filepath.Join(s.dir, schedulesFile), schedules)
+}
+
+// DeleteSchedule removes a schedule and all its runs
+func (s *Store) DeleteSchedule(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedules, err := s.schedules()
+	if err != nil {
+		return err
+	}
+
+	for i, sched := range schedules {
+		if sched.ID == id {
+			schedules = append(schedules[:i], schedules[i+1:]...)
+
+			if err := s.writeJSON(filepath.Join(s.dir, schedulesFile), schedules); err != nil {
+				return err
+			}
+
+			return os.RemoveAll(s.runsPath(id))
+		}
+	}
+
+	return ErrNotFound.New("schedule " + id)
+}
+
+// SaveRun stores a run, and its snapshot if it is not nil
+func (s *Store) SaveRun(run Run, snapshot *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := s.runsPath(run.ScheduleID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if snapshot != nil {
+		err := s.writeJSON(filepath.Join(dir, run.ID+snapshotSuffix), snapshot)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.writeJSON(filepath.Join(dir, run.ID+runSuffix), run)
+}
+
+// Runs returns the runs of a schedule, sorted from the oldest to the newest
+func (s *Store) Runs(scheduleID string) ([]Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.runs(scheduleID)
+}
+
+// PruneRuns deletes the oldest runs of a schedule, and their snapshots, so
+// only the newest max ones are kept. A max of 0 keeps all of them
+func (s *Store) PruneRuns(scheduleID string, max int) error {
+	if max <= 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runs, err := s.runs(scheduleID)
+	if err != nil {
+		return err
+	}
+
+	for len(runs) > max {
+		dir := s.runsPath(scheduleID)
+		err := os.Remove(filepath.Join(dir, runs[0].ID+snapshotSuffix))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if err := os.Remove(filepath.Join(dir, runs[0].ID+runSuffix)); err != nil {
+			return err
+		}
+
+		runs = runs[1:]
+	}
+
+	return nil
+}
+
+func (s *Store) runs(scheduleID string) ([]Run, error) {
+	files, err := ioutil.ReadDir(s.runsPath(scheduleID))
+	if os.IsNotExist(err) {
+		return []Run{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	runs := []Run{}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), runSuffix) {
+			continue
+		}
+
+		var run Run
+		err := s.readJSON(filepath.Join(s.runsPath(scheduleID), f.Name()), &run)
+		if err != nil {
+			return nil, err
+		}
+
+		runs = append(runs, run)
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].ID < runs[j].ID
+	})
+
+	return runs, nil
+}
+
+// Snapshot returns the snapshot stored for a run
+func (s *Store) Snapshot(scheduleID, runID string) (*Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the IDs come from the user, make sure they are not paths
+	if !validID(scheduleID) || !validID(runID) {
+		return nil, ErrNotFound.New("snapshot " + runID)
+	}
+
+	var snapshot Snapshot
+	err := s.readJSON(filepath.Join(s.runsPath(scheduleID), runID+snapshotSuffix), &snapshot)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound.New("snapshot " + runID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+func (s *Store) schedules() ([]Schedule, error) {
+	schedules := []Schedule{}
+
+	err := s.readJSON(filepath.Join(s.dir, schedulesFile), &schedules)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+func (s *Store) runsPath(scheduleID string) string {
+	return filepath.Join(s.dir, runsDir, filepath.Base(scheduleID))
+}
+
+func (s *Store) readJSON(path string, v interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+// writeJSON writes the file atomically, using a temporary file that is
+// renamed once it is complete
+func (s *Store) writeJSON(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func validID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, `/\.`)
+}
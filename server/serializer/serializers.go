@@ -4,9 +4,9 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/src-d/gitbase-web/server/scheduler"
 	"github.com/src-d/gitbase-web/server/service"
-	"gopkg.in/bblfsh/sdk.v2/uast/nodes"
-	enry "gopkg.in/src-d/enry.v1"
+	"github.com/src-d/gitbase-web/server/uastutil"
 )
 
 // HTTPError defines an Error message as it will be written in the http.Response
@@ -111,6 +111,12 @@ func NewQueryResponse(
 		queryMetaResponse{Headers: columnNames, Types: columnTypes})
 }
 
+// NewCellResponse returns a Response with the full value of a text cell
+// truncated in the query results
+func NewCellResponse(value string) *Response {
+	return newResponse(value, nil)
+}
+
 // Column describes a table column in DB
 type Column struct {
 	Name string `json:"name"`
@@ -135,17 +141,60 @@ func NewSchemaResponse(tables map[string][]Column) *Response {
 	return newResponse(res, nil)
 }
 
-// NewParseResponse returns a Response with UAST
-func NewParseResponse(resp *service.ParseResponse) *Response {
-	return newResponse(resp, nil)
+// NewParseResponse returns a Response with UAST, as nodes or already encoded
+// in a different output format, and the results of the xpath expressions
+func NewParseResponse(
+	resp *service.ParseResponse,
+	uast interface{},
+	xpaths map[string]service.XPathResult,
+) *Response {
+	return newResponse(struct {
+		UAST    interface{}                    `json:"uast"`
+		Lang    string                         `json:"language"`
+		Content string                         `json:"content,omitempty"`
+		XPaths  map[string]service.XPathResult `json:"xpaths,omitempty"`
+	}{uast, resp.Lang, resp.Content, xpaths}, nil)
 }
 
-// NewDetectLangResponse returns a Response with detected language
-func NewDetectLangResponse(lang string, langType enry.Type) *Response {
-	return newResponse(struct {
+// NewParseBatchResponse returns a Response with the responses for each one
+// of the parsed files
+func NewParseBatchResponse(responses []*Response) *Response {
+	return newResponse(responses, nil)
+}
+
+// NewUASTDiffResponse returns a Response with the differences between two
+// UASTs
+func NewUASTDiffResponse(diff *uastutil.Diff, lang string) *Response {
+	return newResponse(diff, struct {
+		Language string `json:"language"`
+	}{lang})
+}
+
+// NewUASTAtResponse returns a Response with the chain of UAST nodes that
+// enclose a position
+func NewUASTAtResponse(chain []uastutil.EnclosingNode, lang string) *Response {
+	return newResponse(chain, struct {
 		Language string `json:"language"`
-		Type     int    `json:"type"`
-	}{lang, int(langType)}, nil)
+	}{lang})
+}
+
+// NewUASTStatsResponse returns a Response with the stats of a UAST. The
+// language is empty if the UAST was not parsed by the server
+func NewUASTStatsResponse(stats *uastutil.Stats, lang string) *Response {
+	return newResponse(stats, struct {
+		Language string `json:"language,omitempty"`
+	}{lang})
+}
+
+// NewDetectLangResponse returns a Response with detected language
+func NewDetectLangResponse(detection service.LangDetection) *Response {
+	return newResponse(detection, nil)
+}
+
+// NewDetectLangBatchResponse returns a Response with the detected language of
+// several files
+func NewDetectLangBatchResponse(detections []service.LangDetection) *Response {
+	return newResponse(detections, nil)
 }
 
 // NewLanguagesResponse returns Response with a list of languages
@@ -153,7 +202,120 @@ func NewLanguagesResponse(langs []service.Language) *Response {
 	return newResponse(langs, nil)
 }
 
-// UASTFilterResponse return a Response with UAST search results
-func UASTFilterResponse(resp nodes.Array) *Response {
+// UASTFilterResponse return a Response with UAST search results, as nodes or
+// already encoded in a different output format
+func UASTFilterResponse(resp interface{}) *Response {
 	return newResponse(resp, nil)
 }
+
+// NewXPathsResponse returns a Response with the results of several named
+// xpath expressions
+func NewXPathsResponse(xpaths map[string]service.XPathResult) *Response {
+	return newResponse(xpaths, nil)
+}
+
+// NewSearchResponse returns a Response with a page of code search matches.
+// next is the offset of the next page, or 0 if there are no more matches.
+// truncated means the search stopped at the maximum number of matches
+func NewSearchResponse(matches []service.SearchMatch, offset, next int, truncated bool) *Response {
+	return newResponse(matches, searchMetaResponse{offset, next, truncated})
+}
+
+// NewStructuralSearchResponse returns a Response with a page of structural
+// code search matches, with the same meta as NewSearchResponse
+func NewStructuralSearchResponse(
+	matches []service.StructuralMatch,
+	offset, next int,
+	truncated bool,
+) *Response {
+	return newResponse(matches, searchMetaResponse{offset, next, truncated})
+}
+
+type searchMetaResponse struct {
+	Offset    int  `json:"offset"`
+	Next      int  `json:"next,omitempty"`
+	Truncated bool `json:"truncated"`
+}
+
+type pageMetaResponse struct {
+	Offset int `json:"offset"`
+	Next   int `json:"next,omitempty"`
+}
+
+// NewRepositoriesResponse returns a Response with a page of repositories.
+// next is the offset of the next page, or 0 if it is the last one
+func NewRepositoriesResponse(repos []service.Repository, offset, next int) *Response {
+	return newResponse(repos, pageMetaResponse{offset, next})
+}
+
+// NewRefsResponse returns a Response with a page of references, with the
+// same meta as NewRepositoriesResponse
+func NewRefsResponse(refs []service.Ref, offset, next int) *Response {
+	return newResponse(refs, pageMetaResponse{offset, next})
+}
+
+// NewTreeResponse returns a Response with a page of a directory listing, with
+// the same meta as NewRepositoriesResponse
+func NewTreeResponse(entries []service.TreeEntry, offset, next int) *Response {
+	return newResponse(entries, pageMetaResponse{offset, next})
+}
+
+// NewFileResponse returns a Response with a file of a repository
+func NewFileResponse(file service.File) *Response {
+	return newResponse(file, nil)
+}
+
+// NewCommitsResponse returns a Response with a page of commits, with the
+// same meta as NewRepositoriesResponse
+func NewCommitsResponse(commits []service.Commit, offset, next int) *Response {
+	return newResponse(commits, pageMetaResponse{offset, next})
+}
+
+// NewCommitResponse returns a Response with a commit and its changed files
+func NewCommitResponse(commit service.CommitDetail) *Response {
+	return newResponse(commit, nil)
+}
+
+// NewDiffResponse returns a Response with the diff of each changed file
+func NewDiffResponse(diffs []service.FileDiff) *Response {
+	return newResponse(diffs, nil)
+}
+
+// NewContributorsResponse returns a Response with the activity of each
+// contributor. directoriesTruncated means the directories were counted only
+// for the newest commits
+func NewContributorsResponse(contributors []service.Contributor, directoriesTruncated bool) *Response {
+	return newResponse(contributors, contributorsMetaResponse{directoriesTruncated})
+}
+
+type contributorsMetaResponse struct {
+	DirectoriesTruncated bool `json:"directoriesTruncated"`
+}
+
+// NewSchedulesResponse returns a Response with the scheduled queries
+func NewSchedulesResponse(schedules []scheduler.ScheduleInfo) *Response {
+	return newResponse(schedules, nil)
+}
+
+// NewScheduleResponse returns a Response with one scheduled query
+func NewScheduleResponse(schedule scheduler.Schedule) *Response {
+	return newResponse(schedule, nil)
+}
+
+// NewRunsResponse returns a Response with the runs of a scheduled query
+func NewRunsResponse(runs []scheduler.Run) *Response {
+	return newResponse(runs, nil)
+}
+
+// NewSnapshotResponse returns a Response with the rows stored by a run of a
+// scheduled query, in the same format as NewQueryResponse
+func NewSnapshotResponse(snapshot *scheduler.Snapshot) *Response {
+	return newResponse(snapshot.Rows,
+		queryMetaResponse{Headers: snapshot.Headers, Types: snapshot.Types})
+}
+
+// NewSnapshotDiffResponse returns a Response with the differences between
+// two snapshots
+func NewSnapshotDiffResponse(diff *scheduler.Diff) *Response {
+	return newResponse(diff, nil)
+}
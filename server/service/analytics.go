@@ -0,0 +1,49 @@
+package service
+
+import (
+	"time"
+)
+
+// Time buckets of the contributors activity
+const (
+	BucketWeek  = "week"
+	BucketMonth = "month"
+)
+
+// BucketStart returns the start of the bucket that contains the time, in UTC.
+// The weeks start on Monday
+func BucketStart(t time.Time, bucket string) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	if bucket == BucketMonth {
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// Contributor is the activity of a commit author, with the authors merged by
+// the mailmap
+type Contributor struct {
+	Name        string         `json:"name"`
+	Email       string         `json:"email"`
+	Commits     int            `json:"commits"`
+	First       time.Time      `json:"first"`
+	Last        time.Time      `json:"last"`
+	Buckets     []Bucket       `json:"buckets"`
+	Directories []DirectoryUse `json:"directories"`
+}
+
+// Bucket is the number of commits in the time bucket that starts at Start
+type Bucket struct {
+	Start   time.Time `json:"start"`
+	Commits int       `json:"commits"`
+}
+
+// DirectoryUse is the number of commits that changed a top level directory.
+// The files in the root directory are counted in the "/" path
+type DirectoryUse struct {
+	Path    string `json:"path"`
+	Commits int    `json:"commits"`
+}
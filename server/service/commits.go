@@ -0,0 +1,28 @@
+package service
+
+import (
+	"time"
+)
+
+// Signature is the author or the committer of a commit
+type Signature struct {
+	Name  string    `json:"name"`
+	Email string    `json:"email"`
+	When  time.Time `json:"when"`
+}
+
+// Commit is a commit of a repository
+type Commit struct {
+	Hash      string    `json:"hash"`
+	Author    Signature `json:"author"`
+	Committer Signature `json:"committer"`
+	Message   string    `json:"message"`
+	Parents   []string  `json:"parents"`
+}
+
+// CommitDetail is a commit with the files it changed, compared to its first
+// parent
+type CommitDetail struct {
+	Commit
+	Files []FileChange `json:"files"`
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	enry "gopkg.in/src-d/enry.v1"
+)
+
+// LangCandidate is a possible language of a file, with the enry strategy
+// that detected it
+type LangCandidate struct {
+	Language string `json:"language"`
+	Strategy string `json:"strategy"`
+}
+
+// LangDetection is the language detected by enry for a file, the ranked
+// candidates, and the classification of the file
+type LangDetection struct {
+	Language      string          `json:"language"`
+	Type          enry.Type       `json:"type"`
+	Candidates    []LangCandidate `json:"candidates"`
+	Vendor        bool            `json:"vendor"`
+	Documentation bool            `json:"documentation"`
+	Configuration bool            `json:"configuration"`
+	DotFile       bool            `json:"dotfile"`
+	Image         bool            `json:"image"`
+	Binary        bool            `json:"binary"`
+}
+
+// langStrategies are the enry.DefaultStrategies, with their names
+var langStrategies = []struct {
+	name     string
+	strategy enry.Strategy
+}{
+	{"modeline", enry.GetLanguagesByModeline},
+	{"filename", enry.GetLanguagesByFilename},
+	{"shebang", enry.GetLanguagesByShebang},
+	{"extension", enry.GetLanguagesByExtension},
+	{"content", enry.GetLanguagesByContent},
+	{"classifier", enry.GetLanguagesByClassifier},
+}
+
+// DetectLanguage returns the language of the file as enry.GetLanguage does,
+// and the other candidates found by the enry strategies
+func DetectLanguage(filename string, content []byte) LangDetection {
+	candidates := LanguageCandidates(filename, content)
+
+	var lang string
+	if len(candidates) > 0 {
+		lang = candidates[0].Language
+	}
+
+	return LangDetection{
+		Language:      lang,
+		Type:          enry.GetLanguageType(lang),
+		Candidates:    candidates,
+		Vendor:        filename != "" && enry.IsVendor(filename),
+		Documentation: filename != "" && enry.IsDocumentation(filename),
+		Configuration: filename != "" && enry.IsConfiguration(filename),
+		DotFile:       filename != "" && enry.IsDotFile(filename),
+		Image:         filename != "" && enry.IsImage(filename),
+		Binary:        enry.IsBinary(content),
+	}
+}
+
+// LanguageCandidates applies the enry strategies in the same order as
+// enry.GetLanguages. The languages found by the strategy that decided are
+// returned first, in the order it ranked them, followed by the candidates
+// found by the previous strategies
+func LanguageCandidates(filename string, content []byte) []LangCandidate {
+	if enry.IsBinary(content) {
+		return []LangCandidate{}
+	}
+
+	var found []LangCandidate
+	var last []string
+	var lastStrategy string
+
+	candidates := []string{}
+	for _, s := range langStrategies {
+		last = s.strategy(filename, content, candidates)
+		lastStrategy = s.name
+
+		for _, lang := range last {
+			found = append(found, LangCandidate{Language: lang, Strategy: s.name})
+		}
+
+		if len(last) == 1 {
+			break
+		}
+
+		candidates = append(candidates, last...)
+	}
+
+	ranked := make([]LangCandidate, 0, len(found))
+	seen := make(map[string]bool, len(found))
+	add := func(c LangCandidate) {
+		if c.Language != "" && !seen[c.Language] {
+			seen[c.Language] = true
+			ranked = append(ranked, c)
+		}
+	}
+
+	for _, lang := range last {
+		add(LangCandidate{Language: lang, Strategy: lastStrategy})
+	}
+
+	for _, c := range found {
+		add(c)
+	}
+
+	return ranked
+}
@@ -0,0 +1,362 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	enry "gopkg.in/src-d/enry.v1"
+)
+
+// Status of the changed files
+const (
+	FileAdded    = "added"
+	FileModified = "modified"
+	FileDeleted  = "deleted"
+	FileRenamed  = "renamed"
+)
+
+// TreeFile is a file of a tree, with its blob hash and its git file mode as
+// an octal string, like 100644
+type TreeFile struct {
+	Hash string
+	Mode string
+}
+
+// FileChange is a file changed between two trees. The hashes and modes are
+// the ones of the file in each tree, empty if it is not there. OldPath is
+// only set for the renamed files. LinesUnknown is set when the contents were
+// not loaded, so the lines are not counted
+type FileChange struct {
+	Path         string `json:"path"`
+	OldPath      string `json:"oldPath,omitempty"`
+	Status       string `json:"status"`
+	OldHash      string `json:"oldHash,omitempty"`
+	NewHash      string `json:"newHash,omitempty"`
+	OldMode      string `json:"oldMode,omitempty"`
+	NewMode      string `json:"newMode,omitempty"`
+	Added        int    `json:"added"`
+	Removed      int    `json:"removed"`
+	Binary       bool   `json:"binary"`
+	LinesUnknown bool   `json:"linesUnknown,omitempty"`
+}
+
+// ChangedFiles returns the files changed from one tree to the other, sorted
+// by path. The trees are given as the file of each path. A file is modified
+// if its blob or its mode changed
+func ChangedFiles(from, to map[string]TreeFile) []FileChange {
+	changes := []FileChange{}
+	for path, f := range to {
+		old, ok := from[path]
+		switch {
+		case !ok:
+			changes = append(changes, FileChange{
+				Path: path, Status: FileAdded, NewHash: f.Hash, NewMode: f.Mode})
+		case old != f:
+			changes = append(changes, FileChange{
+				Path: path, Status: FileModified,
+				OldHash: old.Hash, NewHash: f.Hash, OldMode: old.Mode, NewMode: f.Mode})
+		}
+	}
+
+	for path, f := range from {
+		if _, ok := to[path]; !ok {
+			changes = append(changes, FileChange{
+				Path: path, Status: FileDeleted, OldHash: f.Hash, OldMode: f.Mode})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes
+}
+
+// DetectRenames returns the changes with each deleted file paired with an
+// added file with the same blob, as a renamed file. The files are only
+// renamed if their content did not change
+func DetectRenames(changes []FileChange) []FileChange {
+	added := make(map[string][]FileChange)
+	for _, c := range changes {
+		if c.Status == FileAdded {
+			added[c.NewHash] = append(added[c.NewHash], c)
+		}
+	}
+
+	// the paths added and deleted by the renames
+	paired := make(map[string]bool)
+	var result []FileChange
+	for _, c := range changes {
+		if c.Status != FileDeleted || len(added[c.OldHash]) == 0 {
+			continue
+		}
+
+		a := added[c.OldHash][0]
+		added[c.OldHash] = added[c.OldHash][1:]
+		paired[a.Path], paired[c.Path] = true, true
+
+		result = append(result, FileChange{
+			Path:    a.Path,
+			OldPath: c.Path,
+			Status:  FileRenamed,
+			OldHash: c.OldHash,
+			NewHash: c.OldHash,
+			OldMode: c.OldMode,
+			NewMode: a.NewMode,
+		})
+	}
+
+	if len(result) == 0 {
+		return changes
+	}
+
+	for _, c := range changes {
+		if (c.Status == FileAdded || c.Status == FileDeleted) && paired[c.Path] {
+			continue
+		}
+
+		result = append(result, c)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+
+	return result
+}
+
+// CountLines sets the number of lines added and removed by the change, given
+// the old and new contents of the file. The binary files are only marked as
+// such
+func CountLines(c *FileChange, from, to string) {
+	if enry.IsBinary([]byte(from)) || enry.IsBinary([]byte(to)) {
+		c.Binary = true
+		return
+	}
+
+	c.Added, c.Removed = 0, 0
+	m := difflib.NewMatcher(splitLines(from), splitLines(to))
+	for _, op := range m.GetOpCodes() {
+		countOpCode(c, op)
+	}
+}
+
+// countOpCode adds the lines added and removed by the operation to the change
+func countOpCode(c *FileChange, op difflib.OpCode) {
+	switch op.Tag {
+	case 'r':
+		c.Removed += op.I2 - op.I1
+		c.Added += op.J2 - op.J1
+	case 'd':
+		c.Removed += op.I2 - op.I1
+	case 'i':
+		c.Added += op.J2 - op.J1
+	}
+}
+
+// splitLines returns the lines of the content, keeping their line breaks.
+// The last line has no line break if the content does not end with one
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// noNewline is the line added after the last line of a file when it does not
+// end with a line break, like git does
+const noNewline = `\ No newline at end of file`
+
+// Hunk is a group of changed lines, with some lines of context. The starts
+// and number of lines are the ones of a unified diff range. The lines are
+// prefixed by ' ' for the context, '-' for the removed ones and '+' for the
+// added ones
+type Hunk struct {
+	OldStart int      `json:"oldStart"`
+	OldLines int      `json:"oldLines"`
+	NewStart int      `json:"newStart"`
+	NewLines int      `json:"newLines"`
+	Lines    []string `json:"lines"`
+}
+
+// FileDiff is a changed file with its hunks. The binary files have no hunks
+type FileDiff struct {
+	FileChange
+	Hunks []Hunk `json:"hunks"`
+}
+
+// DiffFile returns the diff of the change, given the old and new contents of
+// the file, with contextLines lines of context around the changed lines. The
+// added and removed lines are counted from the hunks, like CountLines
+func DiffFile(c FileChange, from, to string, contextLines int) FileDiff {
+	d := FileDiff{FileChange: c, Hunks: []Hunk{}}
+	if enry.IsBinary([]byte(from)) || enry.IsBinary([]byte(to)) {
+		d.Binary = true
+		return d
+	}
+
+	d.Added, d.Removed = 0, 0
+	if from == to {
+		return d
+	}
+
+	a, b := splitLines(from), splitLines(to)
+	m := difflib.NewMatcher(a, b)
+	for _, group := range m.GetGroupedOpCodes(contextLines) {
+		first, last := group[0], group[len(group)-1]
+		h := Hunk{
+			OldStart: hunkStart(first.I1, last.I2),
+			OldLines: last.I2 - first.I1,
+			NewStart: hunkStart(first.J1, last.J2),
+			NewLines: last.J2 - first.J1,
+		}
+
+		for _, op := range group {
+			countOpCode(&d.FileChange, op)
+
+			if op.Tag == 'e' {
+				h.Lines = appendLines(h.Lines, " ", a[op.I1:op.I2])
+				continue
+			}
+
+			if op.Tag == 'r' || op.Tag == 'd' {
+				h.Lines = appendLines(h.Lines, "-", a[op.I1:op.I2])
+			}
+
+			if op.Tag == 'r' || op.Tag == 'i' {
+				h.Lines = appendLines(h.Lines, "+", b[op.J1:op.J2])
+			}
+		}
+
+		d.Hunks = append(d.Hunks, h)
+	}
+
+	return d
+}
+
+// hunkStart returns the 1-based start line of a unified diff range, given
+// the 0-based bounds of the lines. The empty ranges start at the line before
+func hunkStart(start, stop int) int {
+	if start == stop {
+		return start
+	}
+
+	return start + 1
+}
+
+// appendLines appends the lines with the prefix and without their line
+// break, followed by noNewline if the last one has no line break
+func appendLines(dst []string, prefix string, lines []string) []string {
+	for _, l := range lines {
+		dst = append(dst, prefix+strings.TrimSuffix(l, "\n"))
+		if !strings.HasSuffix(l, "\n") {
+			dst = append(dst, noNewline)
+		}
+	}
+
+	return dst
+}
+
+// WritePatch writes the diffs as a patch in the git unified diff format
+func WritePatch(w io.Writer, diffs []FileDiff) error {
+	for _, d := range diffs {
+		oldPath, newPath := d.Path, d.Path
+		if d.OldPath != "" {
+			oldPath = d.OldPath
+		}
+
+		// the added and deleted files are told by their mode line and their
+		// /dev/null path
+		header := fmt.Sprintf("diff --git a/%s b/%s\n", oldPath, newPath)
+		switch {
+		case d.Status == FileAdded:
+			header += fmt.Sprintf("new file mode %s\n", patchMode(d.NewMode))
+		case d.Status == FileDeleted:
+			header += fmt.Sprintf("deleted file mode %s\n", patchMode(d.OldMode))
+		case d.OldMode != "" && d.NewMode != "" && d.OldMode != d.NewMode:
+			header += fmt.Sprintf("old mode %s\nnew mode %s\n",
+				patchMode(d.OldMode), patchMode(d.NewMode))
+		}
+
+		if d.Status == FileRenamed {
+			header += fmt.Sprintf("similarity index 100%%\nrename from %s\nrename to %s\n",
+				oldPath, newPath)
+		}
+
+		if _, err := io.WriteString(w, header); err != nil {
+			return err
+		}
+
+		if d.Binary {
+			_, err := fmt.Fprintf(w, "Binary files %s and %s differ\n",
+				patchPath("a/", oldPath, d.OldHash), patchPath("b/", newPath, d.NewHash))
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if len(d.Hunks) == 0 {
+			continue
+		}
+
+		_, err := fmt.Fprintf(w, "--- %s\n+++ %s\n",
+			patchPath("a/", oldPath, d.OldHash), patchPath("b/", newPath, d.NewHash))
+		if err != nil {
+			return err
+		}
+
+		for _, h := range d.Hunks {
+			_, err := fmt.Fprintf(w, "@@ -%s +%s @@\n%s\n",
+				hunkRange(h.OldStart, h.OldLines), hunkRange(h.NewStart, h.NewLines),
+				strings.Join(h.Lines, "\n"))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// patchMode returns the mode of a file in a patch, with six octal digits. A
+// regular file is assumed if the mode is unknown
+func patchMode(mode string) string {
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return "100644"
+	}
+
+	return fmt.Sprintf("%06o", m)
+}
+
+// patchPath returns the path of a file in a patch, or /dev/null if the file
+// is not in that side of the diff
+func patchPath(prefix, path, hash string) string {
+	if hash == "" {
+		return "/dev/null"
+	}
+
+	return prefix + path
+}
+
+// hunkRange returns a unified diff range, omitting the number of lines when
+// it is 1
+func hunkRange(start, lines int) string {
+	if lines == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+
+	return fmt.Sprintf("%d,%d", start, lines)
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Mailmap maps the names and emails of the commit authors to their canonical
+// ones, like the git .mailmap file. The emails and names are matched without
+// case. A nil Mailmap maps each author to itself
+type Mailmap struct {
+	byEmail     map[string]mailmapEntry
+	byNameEmail map[string]mailmapEntry
+}
+
+// mailmapEntry is the canonical identity of a mailmap line. The empty fields
+// are not replaced
+type mailmapEntry struct {
+	name  string
+	email string
+}
+
+// LoadMailmap reads the mailmap file
+func LoadMailmap(path string) (*Mailmap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseMailmap(f)
+}
+
+// ParseMailmap reads a mailmap in the git format. Each line is one of:
+//
+//	Proper Name <commit@email>
+//	<proper@email> <commit@email>
+//	Proper Name <proper@email> <commit@email>
+//	Proper Name <proper@email> Commit Name <commit@email>
+//
+// The text after a # is a comment
+func ParseMailmap(r io.Reader) (*Mailmap, error) {
+	m := &Mailmap{
+		byEmail:     make(map[string]mailmapEntry),
+		byNameEmail: make(map[string]mailmapEntry),
+	}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		names, emails, err := splitMailmapLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+
+		if len(emails) == 1 {
+			if names[0] == "" {
+				return nil, fmt.Errorf("line %d: a name is required before a single email", n)
+			}
+
+			m.byEmail[strings.ToLower(emails[0])] = mailmapEntry{name: names[0]}
+			continue
+		}
+
+		entry := mailmapEntry{name: names[0], email: emails[0]}
+		if names[1] == "" {
+			m.byEmail[strings.ToLower(emails[1])] = entry
+		} else {
+			m.byNameEmail[nameEmailKey(names[1], emails[1])] = entry
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// splitMailmapLine returns the names and the emails of a mailmap line. Each
+// email is preceded by a name, that can be empty
+func splitMailmapLine(line string) ([]string, []string, error) {
+	var names, emails []string
+	for {
+		start := strings.IndexByte(line, '<')
+		if start < 0 {
+			break
+		}
+
+		end := strings.IndexByte(line[start:], '>')
+		if end < 0 {
+			return nil, nil, fmt.Errorf("unterminated email %q", line[start:])
+		}
+
+		names = append(names, strings.TrimSpace(line[:start]))
+		emails = append(emails, line[start+1:start+end])
+		line = line[start+end+1:]
+	}
+
+	if len(emails) == 0 || len(emails) > 2 {
+		return nil, nil, fmt.Errorf("expected 1 or 2 emails, found %d", len(emails))
+	}
+
+	if strings.TrimSpace(line) != "" {
+		return nil, nil, fmt.Errorf("unexpected %q after the last email", strings.TrimSpace(line))
+	}
+
+	return names, emails, nil
+}
+
+// nameEmailKey returns the key of the entries that match a name and an email
+func nameEmailKey(name, email string) string {
+	return strings.ToLower(name) + "\x00" + strings.ToLower(email)
+}
+
+// Lookup returns the canonical name and email of an author. The entries that
+// match the name and the email take precedence over the ones that only match
+// the email
+func (m *Mailmap) Lookup(name, email string) (string, string) {
+	if m == nil {
+		return name, email
+	}
+
+	entry, ok := m.byNameEmail[nameEmailKey(name, email)]
+	if !ok {
+		entry, ok = m.byEmail[strings.ToLower(email)]
+	}
+
+	if !ok {
+		return name, email
+	}
+
+	if entry.name != "" {
+		name = entry.name
+	}
+
+	if entry.email != "" {
+		email = entry.email
+	}
+
+	return name, email
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"strconv"
+)
+
+// Repository is a repository with some basic stats
+type Repository struct {
+	ID      string `json:"id"`
+	Refs    int    `json:"refs"`
+	Commits int    `json:"commits"`
+}
+
+// Ref is a reference of a repository, and the commit it points to
+type Ref struct {
+	Name   string `json:"name"`
+	Hash   string `json:"hash"`
+	Tag    bool   `json:"tag"`
+	Remote bool   `json:"remote"`
+}
+
+// Types of the tree entries
+const (
+	EntryDir       = "dir"
+	EntryFile      = "file"
+	EntrySymlink   = "symlink"
+	EntrySubmodule = "submodule"
+)
+
+// TreeEntry is an entry of a directory listing
+type TreeEntry struct {
+	Name string `json:"name"`
+	// Path is the full path of the entry from the root of the tree
+	Path string `json:"path"`
+	Hash string `json:"hash"`
+	Mode string `json:"mode"`
+	// Type is one of EntryDir, EntryFile, EntrySymlink or EntrySubmodule
+	Type string `json:"type"`
+}
+
+// File is a file of a tree. The content of the binary files is not included
+type File struct {
+	Path     string `json:"path"`
+	Hash     string `json:"hash"`
+	Size     int64  `json:"size"`
+	Language string `json:"language"`
+	Binary   bool   `json:"binary"`
+	Content  string `json:"content"`
+}
+
+// EntryType returns the type of a tree entry, given its git file mode as an
+// octal string, like 100644 or 40000
+func EntryType(mode string) string {
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return EntryFile
+	}
+
+	switch m & 0170000 {
+	case 0040000:
+		return EntryDir
+	case 0120000:
+		return EntrySymlink
+	case 0160000:
+		return EntrySubmodule
+	default:
+		return EntryFile
+	}
+}
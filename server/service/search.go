@@ -0,0 +1,82 @@
+package service
+
+import (
+	"regexp"
+	"strings"
+
+	"gopkg.in/bblfsh/sdk.v2/uast"
+)
+
+// SearchMatch is a match of a code search in a line of a file
+type SearchMatch struct {
+	Repository string `json:"repository"`
+	Path       string `json:"path"`
+	// Line is the 1-based line number
+	Line int `json:"line"`
+	// Column and EndColumn are the 1-based byte offsets of the match in the
+	// line. The end column is not included in the match
+	Column    int    `json:"column"`
+	EndColumn int    `json:"endColumn"`
+	Text      string `json:"text"`
+	// Before and After are the context lines around the matching line
+	Before []string `json:"before"`
+	After  []string `json:"after"`
+}
+
+// FindLineMatches returns the matches of re in each line of the content,
+// with up to contextLines lines before and after. The empty matches are
+// ignored. It stops after max matches, if max is greater than 0
+func FindLineMatches(content string, re *regexp.Regexp, contextLines, max int) []SearchMatch {
+	lines := strings.Split(content, "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSuffix(l, "\r")
+	}
+
+	var matches []SearchMatch
+	for i, line := range lines {
+		for _, loc := range re.FindAllStringIndex(line, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+
+			if max > 0 && len(matches) >= max {
+				return matches
+			}
+
+			from := i - contextLines
+			if from < 0 {
+				from = 0
+			}
+
+			to := i + 1 + contextLines
+			if to > len(lines) {
+				to = len(lines)
+			}
+
+			matches = append(matches, SearchMatch{
+				Line:      i + 1,
+				Column:    loc[0] + 1,
+				EndColumn: loc[1] + 1,
+				Text:      line,
+				Before:    append([]string{}, lines[from:i]...),
+				After:     append([]string{}, lines[i+1:to]...),
+			})
+		}
+	}
+
+	return matches
+}
+
+// StructuralMatch is a UAST node that matches a structural code search
+type StructuralMatch struct {
+	Repository string `json:"repository"`
+	Path       string `json:"path"`
+	Type       string `json:"type"`
+	Token      string `json:"token,omitempty"`
+	// Start and End are the positions of the node in the file, if bblfsh
+	// sets them
+	Start *uast.Position `json:"start"`
+	End   *uast.Position `json:"end"`
+	// Snippet is the source code of the lines spanned by the node
+	Snippet string `json:"snippet"`
+}
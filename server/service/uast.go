@@ -3,10 +3,13 @@ package service
 import (
 	"bytes"
 	"fmt"
+	"sort"
 
 	bblfsh "github.com/bblfsh/go-client"
 	"gopkg.in/bblfsh/sdk.v2/uast/nodes"
 	"gopkg.in/bblfsh/sdk.v2/uast/nodes/nodesproto"
+	enry "gopkg.in/src-d/enry.v1"
+	"gopkg.in/src-d/enry.v1/data"
 	errors "gopkg.in/src-d/go-errors.v1"
 )
 
@@ -45,25 +48,126 @@ func UnmarshalNodes(data []byte) (nodes.Array, error) {
 	return n.(nodes.Array), nil
 }
 
+// MarshalNodes returns the UAST nodes marshaled in the same format used by
+// gitbase
+func MarshalNodes(arr nodes.Array) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := nodesproto.WriteTo(&buf, arr); err != nil {
+		return nil, ErrMarshalUAST.New(err)
+	}
+
+	return buf.Bytes(), nil
+}
+
 type ParseResponse struct {
 	UAST nodes.Node `json:"uast"`
 	Lang string     `json:"language"`
+	// Content is only set when the file is read from gitbase
+	Content string                 `json:"content,omitempty"`
+	XPaths  map[string]XPathResult `json:"xpaths,omitempty"`
 }
 
+// XPathResult is the result of a named xpath expression. Kind is one of
+// "nodes", "boolean", "number" or "string"
+type XPathResult struct {
+	Kind  string      `json:"kind"`
+	Value interface{} `json:"value"`
+}
+
+// Language is a language supported by a bblfsh driver
 type Language struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Version  string   `json:"version"`
+	Status   string   `json:"status"`
+	Features []string `json:"features"`
+	// Modes are the UAST modes supported by the driver, based on its
+	// features
+	Modes []string `json:"modes"`
+	// Aliases are the names enry gives to the language
+	Aliases []string `json:"aliases"`
+}
+
+// featureModes are the UAST modes that need each driver feature
+var featureModes = []struct {
+	feature string
+	mode    string
+}{
+	{"ast", "native"},
+	{"roles", "annotated"},
+	{"uast", "semantic"},
 }
 
 func DriverManifestsToLangs(drivers []bblfsh.DriverManifest) []Language {
 	result := make([]Language, len(drivers))
 
 	for i, driver := range drivers {
+		features := driver.Features
+		if features == nil {
+			features = []string{}
+		}
+
 		result[i] = Language{
-			ID:   driver.Language,
-			Name: driver.Name,
+			ID:       driver.Language,
+			Name:     driver.Name,
+			Version:  driver.Version,
+			Status:   driver.Status,
+			Features: features,
+			Modes:    driverModes(features),
+			Aliases:  languageAliases(driver.Language),
 		}
 	}
 
 	return result
 }
+
+func driverModes(features []string) []string {
+	modes := []string{}
+	for _, fm := range featureModes {
+		for _, f := range features {
+			if f == fm.feature {
+				modes = append(modes, fm.mode)
+				break
+			}
+		}
+	}
+
+	return modes
+}
+
+// LanguageID returns the bblfsh id of the language with the given enry name
+// or alias, or an empty string if none of the languages is it
+func LanguageID(langs []Language, lang string) string {
+	name, ok := enry.GetLanguageByAlias(lang)
+	if !ok {
+		return ""
+	}
+
+	for _, l := range langs {
+		if n, ok := enry.GetLanguageByAlias(l.ID); ok && n == name {
+			return l.ID
+		}
+	}
+
+	return ""
+}
+
+// languageAliases returns the enry aliases of the language with the given
+// bblfsh id, sorted
+func languageAliases(id string) []string {
+	aliases := []string{}
+
+	lang, ok := enry.GetLanguageByAlias(id)
+	if !ok {
+		return aliases
+	}
+
+	for alias, l := range data.LanguageByAliasMap {
+		if l == lang {
+			aliases = append(aliases, alias)
+		}
+	}
+
+	sort.Strings(aliases)
+	return aliases
+}
@@ -0,0 +1,607 @@
+// Package sqlite implements a minimal writer of SQLite 3 database files.
+//
+// It only supports what is needed to export query results: creating tables
+// and appending rows to them, with INTEGER, REAL, TEXT and BLOB values.
+// The file is written without cgo or external dependencies, so the server
+// can still be cross-compiled for every platform.
+//
+// The format is described in https://www.sqlite.org/fileformat2.html
+package sqlite
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	pageSize = 4096
+	// usable size of each page, there are no reserved bytes at the end
+	usableSize = pageSize
+
+	fileHeaderSize     = 100
+	leafHeaderSize     = 8
+	interiorHeaderSize = 12
+
+	leafTablePage     = 0x0d
+	interiorTablePage = 0x05
+
+	// SQLite version number written in the header, 3.31.1
+	sqliteVersion = 3031001
+)
+
+// ErrSchemaTooLarge is returned when the schema table, with one row with the
+// CREATE TABLE statement of each table, does not fit in the first page of the
+// file. The Writer only writes the schema table in that page
+var ErrSchemaTooLarge = errors.New("the schema of the tables does not fit in the first page of the sqlite file")
+
+// Column describes a column of a table
+type Column struct {
+	Name string
+	// Type is the declared type used in the CREATE TABLE statement
+	Type string
+}
+
+// Writer builds an SQLite database file. The pages are written to an
+// io.WriterAt as they are filled, only the first page, which contains the
+// schema, is kept in memory until Close is called
+type Writer struct {
+	w     io.WriterAt
+	pages uint32
+
+	tables []*table
+	cur    *table
+	err    error
+}
+
+type table struct {
+	name     string
+	sql      string
+	rootPage uint32
+
+	rowid  int64
+	leaf   *page
+	leaves []pageRef
+}
+
+type pageRef struct {
+	number uint32
+	maxKey int64
+}
+
+// NewWriter returns a Writer that writes the database file to w
+func NewWriter(w io.WriterAt) *Writer {
+	// page 1 is reserved for the file header and the schema table
+	return &Writer{w: w, pages: 1}
+}
+
+// CreateTable starts a new table. The rows added with Insert will be added to
+// this table until CreateTable is called again
+func (w *Writer) CreateTable(name string, columns []Column) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	for _, t := range w.tables {
+		if strings.EqualFold(t.name, name) {
+			return fmt.Errorf("table %q already exists", name)
+		}
+	}
+
+	defs := make([]string, len(columns))
+	for i, col := range columns {
+		defs[i] = quoteIdent(col.Name)
+		if col.Type != "" {
+			defs[i] += " " + col.Type
+		}
+	}
+
+	t := &table{
+		name: name,
+		sql: fmt.Sprintf("CREATE TABLE %s (%s)",
+			quoteIdent(name), strings.Join(defs, ", ")),
+		leaf: newPage(leafTablePage, 0),
+	}
+
+	// checked before any row is written, the current table is kept
+	if err := checkSchema(append(w.tables, t)); err != nil {
+		return err
+	}
+
+	if err := w.finishTable(); err != nil {
+		return err
+	}
+
+	w.cur = t
+	w.tables = append(w.tables, t)
+
+	return nil
+}
+
+// checkSchema returns ErrSchemaTooLarge if the schema table of the tables
+// would not fit in the first page, without overflow pages. The root pages
+// are not known until Close, so the largest ones are assumed
+func checkSchema(tables []*table) error {
+	schema := newPage(leafTablePage, fileHeaderSize)
+	for i, t := range tables {
+		record, err := schemaRecord(t, math.MaxUint32)
+		if err != nil {
+			return err
+		}
+
+		if localPayload(len(record)) != len(record) {
+			return ErrSchemaTooLarge
+		}
+
+		cell := appendVarint(nil, uint64(len(record)))
+		cell = appendVarint(cell, uint64(i+1))
+		cell = append(cell, record...)
+		if !schema.fits(cell) {
+			return ErrSchemaTooLarge
+		}
+
+		schema.add(cell, int64(i+1))
+	}
+
+	return nil
+}
+
+// schemaRecord returns the row of the schema table of a table
+func schemaRecord(t *table, rootPage uint32) ([]byte, error) {
+	return encodeRecord([]interface{}{
+		"table", t.name, t.name, int64(rootPage), t.sql,
+	})
+}
+
+// Insert appends a row to the current table. Each value must be nil, a bool,
+// an int64, a float64, a string or a []byte
+func (w *Writer) Insert(values []interface{}) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	if w.cur == nil {
+		return fmt.Errorf("no table was created")
+	}
+
+	record, err := encodeRecord(values)
+	if err != nil {
+		return err
+	}
+
+	t := w.cur
+	t.rowid++
+
+	cell, err := w.leafCell(t.rowid, record)
+	if err != nil {
+		w.err = err
+		return err
+	}
+
+	if !t.leaf.fits(cell) {
+		if err := w.flushLeaf(t); err != nil {
+			return err
+		}
+	}
+
+	t.leaf.add(cell, t.rowid)
+	return nil
+}
+
+// Close writes the pending pages and the file header. It does not close the
+// underlying io.WriterAt
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	if err := w.finishTable(); err != nil {
+		return err
+	}
+
+	schema := newPage(leafTablePage, fileHeaderSize)
+	for i, t := range w.tables {
+		record, err := schemaRecord(t, t.rootPage)
+		if err != nil {
+			return err
+		}
+
+		cell, err := w.leafCell(int64(i+1), record)
+		if err != nil {
+			return err
+		}
+
+		if !schema.fits(cell) {
+			return ErrSchemaTooLarge
+		}
+
+		schema.add(cell, int64(i+1))
+	}
+
+	buf := schema.bytes()
+	copy(buf, fileHeader(w.pages))
+
+	_, err := w.w.WriteAt(buf, 0)
+	return err
+}
+
+// finishTable writes the remaining pages of the current table, and builds
+// its interior pages
+func (w *Writer) finishTable() error {
+	t := w.cur
+	if t == nil {
+		return nil
+	}
+	w.cur = nil
+
+	if err := w.flushLeaf(t); err != nil {
+		return err
+	}
+
+	level := t.leaves
+	for len(level) > 1 {
+		next, err := w.writeInteriorLevel(level)
+		if err != nil {
+			return err
+		}
+		level = next
+	}
+
+	t.rootPage = level[0].number
+	return nil
+}
+
+// writeInteriorLevel writes the interior pages pointing to the given pages,
+// and returns the references to the new pages
+func (w *Writer) writeInteriorLevel(level []pageRef) ([]pageRef, error) {
+	// the children of each page; the last one of each group is the right-most
+	// pointer, the others are stored as cells
+	var groups [][]pageRef
+	var group []pageRef
+	size := 0
+
+	for _, ref := range level {
+		if len(group) > 0 {
+			prev := group[len(group)-1]
+			cellSize := len(interiorCell(prev.number, prev.maxKey)) + 2
+
+			if interiorHeaderSize+size+cellSize > usableSize {
+				groups = append(groups, group)
+				group, size = nil, 0
+			} else {
+				size += cellSize
+			}
+		}
+
+		group = append(group, ref)
+	}
+	groups = append(groups, group)
+
+	// an interior page needs at least one cell besides the right-most pointer
+	if n := len(groups); n > 1 && len(groups[n-1]) == 1 {
+		prev := groups[n-2]
+		groups[n-1] = append([]pageRef{prev[len(prev)-1]}, groups[n-1]...)
+		groups[n-2] = prev[:len(prev)-1]
+	}
+
+	next := make([]pageRef, len(groups))
+	for i, group := range groups {
+		p := newPage(interiorTablePage, 0)
+		for _, ref := range group[:len(group)-1] {
+			p.add(interiorCell(ref.number, ref.maxKey), ref.maxKey)
+		}
+
+		ref, err := w.writeInterior(p, group[len(group)-1])
+		if err != nil {
+			return nil, err
+		}
+		next[i] = ref
+	}
+
+	return next, nil
+}
+
+// flushLeaf writes the current leaf page of the table. Empty pages are only
+// written for empty tables, that still need a root page
+func (w *Writer) flushLeaf(t *table) error {
+	if len(t.leaf.cells) == 0 && len(t.leaves) > 0 {
+		return nil
+	}
+
+	n, err := w.writePage(t.leaf.bytes())
+	if err != nil {
+		return err
+	}
+
+	t.leaves = append(t.leaves, pageRef{number: n, maxKey: t.leaf.maxKey})
+	t.leaf = newPage(leafTablePage, 0)
+	return nil
+}
+
+// writeInterior writes an interior page, using the given child as the
+// right-most pointer
+func (w *Writer) writeInterior(p *page, rightMost pageRef) (pageRef, error) {
+	p.rightMost = rightMost.number
+
+	n, err := w.writePage(p.bytes())
+	if err != nil {
+		return pageRef{}, err
+	}
+
+	return pageRef{number: n, maxKey: rightMost.maxKey}, nil
+}
+
+// writePage allocates a new page number and writes the page contents
+func (w *Writer) writePage(b []byte) (uint32, error) {
+	w.pages++
+	n := w.pages
+
+	if _, err := w.w.WriteAt(b, int64(n-1)*pageSize); err != nil {
+		w.err = err
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// leafCell returns a table b-tree leaf cell for the given record. If the
+// record is too big, the remaining payload is written to overflow pages
+func (w *Writer) leafCell(rowid int64, payload []byte) ([]byte, error) {
+	cell := appendVarint(nil, uint64(len(payload)))
+	cell = appendVarint(cell, uint64(rowid))
+
+	local := localPayload(len(payload))
+	if local == len(payload) {
+		return append(cell, payload...), nil
+	}
+
+	cell = append(cell, payload[:local]...)
+
+	// overflow pages are chained, so they are written from the last one
+	rest := payload[local:]
+	chunk := usableSize - 4
+	numPages := (len(rest) + chunk - 1) / chunk
+
+	first := w.pages + 1
+	for i := 0; i < numPages; i++ {
+		b := make([]byte, pageSize)
+
+		if i < numPages-1 {
+			binary.BigEndian.PutUint32(b, first+uint32(i)+1)
+		}
+
+		end := (i + 1) * chunk
+		if end > len(rest) {
+			end = len(rest)
+		}
+		copy(b[4:], rest[i*chunk:end])
+
+		if _, err := w.writePage(b); err != nil {
+			return nil, err
+		}
+	}
+
+	var next [4]byte
+	binary.BigEndian.PutUint32(next[:], first)
+	return append(cell, next[:]...), nil
+}
+
+// localPayload returns how many bytes of a payload of the given size are
+// stored in the b-tree page, the rest goes to overflow pages
+func localPayload(size int) int {
+	maxLocal := usableSize - 35
+	if size <= maxLocal {
+		return size
+	}
+
+	minLocal := (usableSize-12)*32/255 - 23
+	local := minLocal + (size-minLocal)%(usableSize-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+
+	return local
+}
+
+func interiorCell(child uint32, key int64) []byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], child)
+	return appendVarint(b[:], uint64(key))
+}
+
+// page is a b-tree page being filled
+type page struct {
+	kind      byte
+	offset    int
+	cells     [][]byte
+	size      int
+	maxKey    int64
+	rightMost uint32
+}
+
+func newPage(kind byte, offset int) *page {
+	return &page{kind: kind, offset: offset}
+}
+
+func (p *page) headerSize() int {
+	if p.kind == interiorTablePage {
+		return interiorHeaderSize
+	}
+
+	return leafHeaderSize
+}
+
+func (p *page) fits(cell []byte) bool {
+	used := p.offset + p.headerSize() + 2*len(p.cells) + p.size
+	return used+2+len(cell) <= usableSize
+}
+
+func (p *page) add(cell []byte, key int64) {
+	p.cells = append(p.cells, cell)
+	p.size += len(cell)
+	p.maxKey = key
+}
+
+func (p *page) bytes() []byte {
+	b := make([]byte, pageSize)
+	h := b[p.offset:]
+
+	h[0] = p.kind
+	binary.BigEndian.PutUint16(h[3:], uint16(len(p.cells)))
+
+	// cell contents are stored from the end of the page
+	content := usableSize
+	ptr := p.offset + p.headerSize()
+	for _, cell := range p.cells {
+		content -= len(cell)
+		copy(b[content:], cell)
+		binary.BigEndian.PutUint16(b[ptr:], uint16(content))
+		ptr += 2
+	}
+
+	// 0 is interpreted as 65536, which is never the case for 4096 bytes pages
+	binary.BigEndian.PutUint16(h[5:], uint16(content))
+
+	if p.kind == interiorTablePage {
+		binary.BigEndian.PutUint32(h[8:], p.rightMost)
+	}
+
+	return b
+}
+
+func fileHeader(pages uint32) []byte {
+	h := make([]byte, fileHeaderSize)
+
+	copy(h, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(h[16:], pageSize)
+	h[18] = 1 // file format write version, legacy
+	h[19] = 1 // file format read version, legacy
+	h[20] = 0 // reserved space at the end of each page
+	h[21] = 64
+	h[22] = 32
+	h[23] = 32
+	binary.BigEndian.PutUint32(h[24:], 1) // file change counter
+	binary.BigEndian.PutUint32(h[28:], pages)
+	binary.BigEndian.PutUint32(h[40:], 1) // schema cookie
+	binary.BigEndian.PutUint32(h[44:], 4) // schema format number
+	binary.BigEndian.PutUint32(h[56:], 1) // UTF-8 text encoding
+	binary.BigEndian.PutUint32(h[92:], 1) // version-valid-for, same as the change counter
+	binary.BigEndian.PutUint32(h[96:], sqliteVersion)
+
+	return h
+}
+
+// encodeRecord returns the values encoded using the record format
+func encodeRecord(values []interface{}) ([]byte, error) {
+	var header, body []byte
+
+	for _, val := range values {
+		switch v := val.(type) {
+		case nil:
+			header = appendVarint(header, 0)
+		case bool:
+			if v {
+				header = appendVarint(header, 9)
+			} else {
+				header = appendVarint(header, 8)
+			}
+		case int64:
+			serialType, b := encodeInt(v)
+			header = appendVarint(header, serialType)
+			body = append(body, b...)
+		case float64:
+			var b [8]byte
+			binary.BigEndian.PutUint64(b[:], math.Float64bits(v))
+			header = appendVarint(header, 7)
+			body = append(body, b[:]...)
+		case string:
+			if !utf8.ValidString(v) {
+				header = appendVarint(header, uint64(len(v))*2+12)
+			} else {
+				header = appendVarint(header, uint64(len(v))*2+13)
+			}
+			body = append(body, v...)
+		case []byte:
+			header = appendVarint(header, uint64(len(v))*2+12)
+			body = append(body, v...)
+		default:
+			return nil, fmt.Errorf("unsupported value type %T", val)
+		}
+	}
+
+	// the header size includes the varint with its own size
+	size := len(header) + 1
+	for varintLen(uint64(size)) != size-len(header) {
+		size = len(header) + varintLen(uint64(size))
+	}
+
+	record := appendVarint(make([]byte, 0, size+len(body)), uint64(size))
+	record = append(record, header...)
+	return append(record, body...), nil
+}
+
+// encodeInt returns the serial type and the big-endian bytes of the integer,
+// using the smallest possible size
+func encodeInt(v int64) (uint64, []byte) {
+	switch {
+	case v == 0:
+		return 8, nil
+	case v == 1:
+		return 9, nil
+	}
+
+	sizes := []struct {
+		serialType uint64
+		bytes      uint
+	}{{1, 1}, {2, 2}, {3, 3}, {4, 4}, {5, 6}, {6, 8}}
+
+	for _, s := range sizes {
+		bits := s.bytes * 8
+		if s.bytes == 8 || (v >= -(1<<(bits-1)) && v < 1<<(bits-1)) {
+			var b [8]byte
+			binary.BigEndian.PutUint64(b[:], uint64(v))
+			return s.serialType, b[8-s.bytes:]
+		}
+	}
+
+	return 0, nil
+}
+
+// appendVarint appends v using the SQLite variable-length integer encoding.
+// It is big-endian, and uses up to 9 bytes; the 9th byte uses all its 8 bits
+func appendVarint(b []byte, v uint64) []byte {
+	if v > 1<<56-1 {
+		var buf [9]byte
+		buf[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(b, buf[:]...)
+	}
+
+	var buf [8]byte
+	i := len(buf) - 1
+	buf[i] = byte(v & 0x7f)
+	v >>= 7
+	for v > 0 {
+		i--
+		buf[i] = byte(v&0x7f) | 0x80
+		v >>= 7
+	}
+
+	return append(b, buf[i:]...)
+}
+
+func varintLen(v uint64) int {
+	return len(appendVarint(nil, v))
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
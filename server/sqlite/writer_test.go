@@ -0,0 +1,190 @@
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// buffer is an in-memory io.WriterAt
+type buffer struct {
+	b []byte
+}
+
+func (buf *buffer) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(buf.b) {
+		buf.b = append(buf.b, make([]byte, end-len(buf.b))...)
+	}
+
+	return copy(buf.b[off:], p), nil
+}
+
+func TestVarint(t *testing.T) {
+	require := require.New(t)
+
+	testCases := []struct {
+		v        uint64
+		expected []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x81, 0x00}},
+		{240, []byte{0x81, 0x70}},
+		{16383, []byte{0xff, 0x7f}},
+		{16384, []byte{0x81, 0x80, 0x00}},
+		{1<<56 - 1, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}},
+		{1 << 56, []byte{0x80, 0xc0, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00}},
+		{1<<64 - 1, bytes.Repeat([]byte{0xff}, 9)},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(tc.expected, appendVarint(nil, tc.v), "%d", tc.v)
+	}
+}
+
+func TestEncodeRecord(t *testing.T) {
+	require := require.New(t)
+
+	record, err := encodeRecord([]interface{}{
+		nil, int64(0), int64(1), int64(-2), int64(300), 1.5, "ab", []byte{0xff}, true,
+	})
+	require.NoError(err)
+
+	expected := []byte{
+		// header size, serial types
+		10, 0, 8, 9, 1, 2, 7, 17, 14, 9,
+		// values
+		0xfe,
+		0x01, 0x2c,
+		0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+		'a', 'b',
+		0xff,
+	}
+	require.Equal(expected, record)
+
+	_, err = encodeRecord([]interface{}{int32(1)})
+	require.Error(err)
+}
+
+func TestLocalPayload(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal(100, localPayload(100))
+	require.Equal(usableSize-35, localPayload(usableSize-35))
+	require.True(localPayload(usableSize) < usableSize-35)
+	require.True(localPayload(1000000) < usableSize-35)
+}
+
+func TestWriter(t *testing.T) {
+	require := require.New(t)
+
+	var buf buffer
+	w := NewWriter(&buf)
+
+	require.Error(w.Insert([]interface{}{int64(1)}))
+
+	require.NoError(w.CreateTable("empty", []Column{{"a", "INTEGER"}}))
+	require.Error(w.CreateTable("EMPTY", nil))
+
+	require.NoError(w.CreateTable("rows", []Column{{"id", "INTEGER"}, {"text", "TEXT"}}))
+	for i := 0; i < 5000; i++ {
+		require.NoError(w.Insert([]interface{}{int64(i), strings.Repeat("x", i)}))
+	}
+
+	require.NoError(w.Close())
+
+	b := buf.b
+	require.Equal(0, len(b)%pageSize)
+	require.Equal("SQLite format 3\x00", string(b[:16]))
+	require.EqualValues(pageSize, binary.BigEndian.Uint16(b[16:]))
+	require.EqualValues(len(b)/pageSize, binary.BigEndian.Uint32(b[28:]))
+
+	// schema table, with one row per table
+	require.EqualValues(leafTablePage, b[fileHeaderSize])
+	require.EqualValues(2, binary.BigEndian.Uint16(b[fileHeaderSize+3:]))
+	require.Contains(string(b[:pageSize]), `CREATE TABLE "empty" ("a" INTEGER)`)
+	require.Contains(string(b[:pageSize]), `CREATE TABLE "rows" ("id" INTEGER, "text" TEXT)`)
+
+	// the empty table is stored in page 2, as an empty leaf
+	page2 := b[pageSize : 2*pageSize]
+	require.EqualValues(leafTablePage, page2[0])
+	require.EqualValues(0, binary.BigEndian.Uint16(page2[3:]))
+
+	// the last page written is the root of the second table
+	last := b[len(b)-pageSize:]
+	require.EqualValues(interiorTablePage, last[0])
+}
+
+func TestQuoteIdent(t *testing.T) {
+	require.Equal(t, `"a ""b"""`, quoteIdent(`a "b"`))
+}
+
+func TestSchemaTooLarge(t *testing.T) {
+	require := require.New(t)
+
+	var buf buffer
+	w := NewWriter(&buf)
+
+	columns := []Column{{strings.Repeat("c", 1000), "TEXT"}}
+	require.NoError(w.CreateTable("a", columns))
+	require.NoError(w.CreateTable("b", columns))
+	require.NoError(w.CreateTable("c", columns))
+	require.Equal(ErrSchemaTooLarge, w.CreateTable("d", columns))
+
+	// the rows are still added to the last table that fits
+	require.NoError(w.Insert([]interface{}{"x"}))
+	require.NoError(w.Close())
+
+	w = NewWriter(&buffer{})
+	columns = []Column{{strings.Repeat("c", pageSize), "TEXT"}}
+	require.Equal(ErrSchemaTooLarge, w.CreateTable("a", columns))
+}
+
+// TestSQLite3 reads the file with the sqlite3 command line shell, if it is
+// installed
+func TestSQLite3(t *testing.T) {
+	bin, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 is not installed")
+	}
+
+	require := require.New(t)
+
+	f, err := ioutil.TempFile("", "gitbase-web-sqlite")
+	require.NoError(err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := NewWriter(f)
+	require.NoError(w.CreateTable("empty", []Column{{"a", "INTEGER"}}))
+
+	require.NoError(w.CreateTable("rows", []Column{{"id", "INTEGER"}, {"text", "TEXT"}}))
+	for i := 0; i < 5000; i++ {
+		require.NoError(w.Insert([]interface{}{int64(i), strings.Repeat("x", i)}))
+	}
+
+	require.NoError(w.CreateTable("values", []Column{
+		{"null", ""}, {"int", "INTEGER"}, {"real", "REAL"}, {"text", "TEXT"}, {"blob", "BLOB"},
+	}))
+	require.NoError(w.Insert([]interface{}{nil, int64(-300), 1.5, "héllo", []byte{0xca, 0xfe}}))
+	require.NoError(w.Close())
+
+	out, err := exec.Command(bin, "-batch", f.Name(),
+		`PRAGMA integrity_check;`+
+			`SELECT COUNT(*), SUM(id), SUM(LENGTH(text)) FROM "rows";`+
+			`SELECT COUNT(*) FROM "empty";`+
+			`SELECT quote("null"), "int", "real", "text", hex("blob") FROM "values";`).
+		CombinedOutput()
+	require.NoError(err, string(out))
+
+	require.Equal("ok\n"+
+		"5000|12497500|12497500\n"+
+		"0\n"+
+		"NULL|-300|1.5|héllo|CAFE\n", string(out))
+}
@@ -38,6 +38,11 @@ func (db *MockDB) QueryRow(query string, args ...interface{}) *sql.Row {
 	return nil
 }
 
+// QueryRowContext executes a query that is expected to return at most one row
+func (db *MockDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return nil
+}
+
 // Exec executes a query without returning any rows
 func (db *MockDB) Exec(query string, args ...interface{}) (sql.Result, error) {
 	return nil, nil
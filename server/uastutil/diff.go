@@ -0,0 +1,282 @@
+package uastutil
+
+import (
+	"sort"
+
+	"gopkg.in/bblfsh/sdk.v2/uast"
+	"gopkg.in/bblfsh/sdk.v2/uast/nodes"
+)
+
+// NodeInfo identifies a node in one of the compared trees
+type NodeInfo struct {
+	Type  string         `json:"type"`
+	Path  string         `json:"path"`
+	Token string         `json:"token,omitempty"`
+	Pos   uast.Positions `json:"pos,omitempty"`
+}
+
+// FieldChange is the old and new value of an updated node field
+type FieldChange struct {
+	From nodes.Node `json:"from"`
+	To   nodes.Node `json:"to"`
+}
+
+// Change is a difference between the compared trees. From is nil for the
+// inserted nodes, and To is nil for the deleted ones
+type Change struct {
+	From   *NodeInfo              `json:"from,omitempty"`
+	To     *NodeInfo              `json:"to,omitempty"`
+	Fields map[string]FieldChange `json:"fields,omitempty"`
+}
+
+// Diff contains the differences between two UAST trees. The inserted and
+// deleted changes include only the top node of each inserted or deleted
+// subtree
+type Diff struct {
+	Inserted  []Change `json:"inserted"`
+	Deleted   []Change `json:"deleted"`
+	Moved     []Change `json:"moved"`
+	Updated   []Change `json:"updated"`
+	Unchanged int      `json:"unchanged"`
+}
+
+// Compare returns the structural differences between two UAST trees. Changes
+// that only affect the positions of the nodes are not reported.
+//
+// The nodes are matched in two phases. First, identical subtrees are matched
+// from the biggest to the smallest, so they can be detected as moved. Then,
+// the remaining nodes are matched to the nodes of the same type and field
+// under the matched parents; the matched nodes with different values are the
+// updated ones
+func Compare(from, to nodes.Node) *Diff {
+	m := newMatcher(Flatten(from), Flatten(to))
+	m.matchIdentical()
+	m.matchSimilar()
+
+	return m.diff()
+}
+
+type matcher struct {
+	from, to []*Node
+	fromTo   map[*Node]*Node
+	toFrom   map[*Node]*Node
+	hashes   map[*Node]nodes.Hash
+}
+
+func newMatcher(from, to []*Node) *matcher {
+	m := &matcher{
+		from:   from,
+		to:     to,
+		fromTo: make(map[*Node]*Node),
+		toFrom: make(map[*Node]*Node),
+		hashes: make(map[*Node]nodes.Hash),
+	}
+
+	for _, n := range append(append([]*Node{}, from...), to...) {
+		m.hashes[n] = uast.HashNoPos(n.Object)
+	}
+
+	return m
+}
+
+func (m *matcher) match(a, b *Node) {
+	m.fromTo[a] = b
+	m.toFrom[b] = a
+}
+
+// matchIdentical matches the subtrees with the same hash. The subtrees of
+// "to" are visited in pre-order, so the biggest ones are matched first
+func (m *matcher) matchIdentical() {
+	candidates := make(map[nodes.Hash][]*Node)
+	for _, a := range m.from {
+		candidates[m.hashes[a]] = append(candidates[m.hashes[a]], a)
+	}
+
+	for _, b := range m.to {
+		if _, ok := m.toFrom[b]; ok {
+			continue
+		}
+
+		var best *Node
+		for _, a := range candidates[m.hashes[b]] {
+			if _, ok := m.fromTo[a]; ok {
+				continue
+			}
+
+			if m.sameParent(a, b) || a.Path == b.Path {
+				best = a
+				break
+			}
+
+			// leaves are too common to be considered moved
+			if best == nil && len(b.Children) > 0 {
+				best = a
+			}
+		}
+
+		if best != nil {
+			m.matchSubtree(best, b)
+		}
+	}
+}
+
+// matchSubtree matches two identical subtrees
+func (m *matcher) matchSubtree(a, b *Node) {
+	m.match(a, b)
+	for i := range a.Children {
+		m.matchSubtree(a.Children[i], b.Children[i])
+	}
+}
+
+// matchSimilar matches the nodes not matched yet with a node of the same
+// type and field, under the matched parents. The candidates are chosen in
+// order, so the first unmatched child in "to" is matched with the first one
+// in "from"
+func (m *matcher) matchSimilar() {
+	for _, b := range m.to {
+		if _, ok := m.toFrom[b]; ok {
+			continue
+		}
+
+		var candidates []*Node
+		if b.Parent == nil {
+			for _, a := range m.from {
+				if a.Parent == nil {
+					candidates = append(candidates, a)
+				}
+			}
+		} else if pa, ok := m.toFrom[b.Parent]; ok {
+			candidates = pa.Children
+		}
+
+		for _, a := range candidates {
+			if _, ok := m.fromTo[a]; ok {
+				continue
+			}
+
+			if a.Type() == b.Type() && a.Field == b.Field {
+				m.match(a, b)
+				break
+			}
+		}
+	}
+}
+
+// sameParent returns true if the parents of both nodes are matched, or
+// both nodes are roots
+func (m *matcher) sameParent(a, b *Node) bool {
+	if a.Parent == nil || b.Parent == nil {
+		return a.Parent == nil && b.Parent == nil
+	}
+
+	return m.fromTo[a.Parent] == b.Parent && a.Field == b.Field
+}
+
+func (m *matcher) diff() *Diff {
+	diff := &Diff{
+		Inserted: []Change{},
+		Deleted:  []Change{},
+		Moved:    []Change{},
+		Updated:  []Change{},
+	}
+
+	for _, a := range m.from {
+		if _, ok := m.fromTo[a]; ok {
+			continue
+		}
+
+		if _, ok := m.fromTo[a.Parent]; a.Parent == nil || ok {
+			diff.Deleted = append(diff.Deleted, Change{From: info(a)})
+		}
+	}
+
+	for _, b := range m.to {
+		a, ok := m.toFrom[b]
+		if !ok {
+			if _, ok := m.toFrom[b.Parent]; b.Parent == nil || ok {
+				diff.Inserted = append(diff.Inserted, Change{To: info(b)})
+			}
+
+			continue
+		}
+
+		changed := false
+		if !m.sameParent(a, b) {
+			diff.Moved = append(diff.Moved, Change{From: info(a), To: info(b)})
+			changed = true
+		}
+
+		if fields := changedFields(a.Object, b.Object); len(fields) > 0 {
+			diff.Updated = append(diff.Updated, Change{From: info(a), To: info(b), Fields: fields})
+			changed = true
+		}
+
+		if !changed {
+			diff.Unchanged++
+		}
+	}
+
+	return diff
+}
+
+// changedFields returns the fields with a different value, ignoring the
+// positions and the fields that contain other UAST objects, that are
+// compared as nodes
+func changedFields(a, b nodes.Object) map[string]FieldChange {
+	keys := make(map[string]struct{})
+	for k := range a {
+		keys[k] = struct{}{}
+	}
+	for k := range b {
+		keys[k] = struct{}{}
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var fields map[string]FieldChange
+	for _, k := range sorted {
+		if k == uast.KeyPos || hasObjects(a[k]) || hasObjects(b[k]) {
+			continue
+		}
+
+		if !nodes.Equal(a[k], b[k]) {
+			if fields == nil {
+				fields = make(map[string]FieldChange)
+			}
+
+			fields[k] = FieldChange{From: a[k], To: b[k]}
+		}
+	}
+
+	return fields
+}
+
+// hasObjects returns true if the node is an object, or an array containing
+// objects
+func hasObjects(n nodes.Node) bool {
+	switch n := n.(type) {
+	case nodes.Object:
+		return true
+	case nodes.Array:
+		for _, v := range n {
+			if hasObjects(v) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func info(n *Node) *NodeInfo {
+	return &NodeInfo{
+		Type:  n.Type(),
+		Path:  n.Path,
+		Token: uast.ContentOf(n.Object),
+		Pos:   n.Positions(),
+	}
+}
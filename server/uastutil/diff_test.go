@@ -0,0 +1,77 @@
+package uastutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/bblfsh/sdk.v2/uast/nodes"
+)
+
+func TestCompareEqual(t *testing.T) {
+	from := file(call("a", 1, 0, ident("x", 1, 3, 2)))
+	// same tree, one line below
+	to := file(call("a", 2, 1, ident("x", 2, 3, 3)))
+
+	diff := Compare(from, to)
+	require.Empty(t, diff.Inserted)
+	require.Empty(t, diff.Deleted)
+	require.Empty(t, diff.Moved)
+	require.Empty(t, diff.Updated)
+	require.Equal(t, 4, diff.Unchanged)
+}
+
+func TestCompareInsertedDeleted(t *testing.T) {
+	from := file(
+		call("a", 1, 0),
+		call("b", 2, 11, ident("x", 2, 3, 13)),
+	)
+	to := file(
+		call("a", 1, 0),
+		call("c", 2, 11, ident("y", 2, 3, 13), ident("z", 2, 6, 16)),
+	)
+
+	diff := Compare(from, to)
+
+	// the calls are matched by type, so only their children change
+	require.Empty(t, diff.Moved)
+	require.Len(t, diff.Updated, 2)
+	require.Equal(t, "/File[1]/Call[2]/uast:Identifier[1]", diff.Updated[0].To.Path)
+	require.Equal(t, FieldChange{From: nodes.String("x"), To: nodes.String("y")},
+		diff.Updated[0].Fields["Name"])
+	require.Equal(t, "c", diff.Updated[1].To.Token)
+
+	require.Len(t, diff.Inserted, 1)
+	require.Equal(t, "z", diff.Inserted[0].To.Token)
+	require.Nil(t, diff.Inserted[0].From)
+	require.Empty(t, diff.Deleted)
+
+	diff = Compare(to, from)
+	require.Len(t, diff.Deleted, 1)
+	require.Equal(t, "z", diff.Deleted[0].From.Token)
+	require.Equal(t, uint32(6), diff.Deleted[0].From.Pos.Start().Col)
+	require.Empty(t, diff.Inserted)
+}
+
+func TestCompareMoved(t *testing.T) {
+	inner := call("inner", 1, 0, ident("x", 1, 7, 6))
+
+	from := file(call("outer", 1, 0, inner), call("other", 2, 20))
+	to := file(call("outer", 1, 0), call("other", 2, 20, inner))
+
+	diff := Compare(from, to)
+
+	require.Len(t, diff.Moved, 1)
+	require.Equal(t, "/File[1]/Call[1]/Call[1]", diff.Moved[0].From.Path)
+	require.Equal(t, "/File[1]/Call[2]/Call[1]", diff.Moved[0].To.Path)
+	require.Empty(t, diff.Inserted)
+	require.Empty(t, diff.Deleted)
+	require.Empty(t, diff.Updated)
+}
+
+func TestCompareRoot(t *testing.T) {
+	diff := Compare(ident("a", 1, 1, 0), call("a", 1, 0))
+
+	require.Len(t, diff.Deleted, 1)
+	require.Len(t, diff.Inserted, 1)
+	require.Equal(t, "Call", diff.Inserted[0].To.Type)
+}
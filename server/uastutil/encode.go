@@ -0,0 +1,261 @@
+package uastutil
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/bblfsh/sdk.v2/uast"
+	"gopkg.in/bblfsh/sdk.v2/uast/nodes"
+	errors "gopkg.in/src-d/go-errors.v1"
+)
+
+// ErrInvalidFormat is returned by ParseFormat for unknown formats
+var ErrInvalidFormat = errors.NewKind("invalid UAST output %q; it must be one of %s")
+
+// Format is a UAST output encoding
+type Format string
+
+const (
+	// JSON is the UAST as it is returned by bblfsh
+	JSON Format = "json"
+	// CompactJSON is the UAST without the @pos positions
+	CompactJSON Format = "compact"
+	// YAML is the complete UAST encoded as a YAML document
+	YAML Format = "yaml"
+	// SExpression is a Lisp-style expression with the node types, contents
+	// and children
+	SExpression Format = "sexp"
+	// DOT is a Graphviz graph
+	DOT Format = "dot"
+)
+
+var formats = []Format{JSON, CompactJSON, YAML, SExpression, DOT}
+
+// ParseFormat returns the Format with the given name. An empty name is JSON
+func ParseFormat(name string) (Format, error) {
+	if name == "" {
+		return JSON, nil
+	}
+
+	for _, f := range formats {
+		if string(f) == name {
+			return f, nil
+		}
+	}
+
+	names := make([]string, len(formats))
+	for i, f := range formats {
+		names[i] = strconv.Quote(string(f))
+	}
+
+	return "", ErrInvalidFormat.New(name, strings.Join(names, ", "))
+}
+
+// Encode returns the UAST in the given format. JSON formats return a node to
+// be marshaled, and the rest return a string
+func Encode(n nodes.Node, f Format) (interface{}, error) {
+	switch f {
+	case JSON, "":
+		return n, nil
+	case CompactJSON:
+		return WithoutPositions(n), nil
+	case YAML:
+		return EncodeYAML(n), nil
+	case SExpression:
+		return EncodeSExpression(n), nil
+	case DOT:
+		return EncodeDOT(n), nil
+	default:
+		_, err := ParseFormat(string(f))
+		return nil, err
+	}
+}
+
+// WithoutPositions returns a copy of the tree without the @pos fields
+func WithoutPositions(n nodes.Node) nodes.Node {
+	switch n := n.(type) {
+	case nodes.Object:
+		obj := make(nodes.Object, len(n))
+		for k, v := range n {
+			if k != uast.KeyPos {
+				obj[k] = WithoutPositions(v)
+			}
+		}
+
+		return obj
+	case nodes.Array:
+		arr := make(nodes.Array, len(n))
+		for i, v := range n {
+			arr[i] = WithoutPositions(v)
+		}
+
+		return arr
+	default:
+		return n
+	}
+}
+
+// EncodeYAML returns the tree as a YAML document. Object keys are sorted
+func EncodeYAML(n nodes.Node) string {
+	var buf bytes.Buffer
+	writeYAMLValue(&buf, n, 0)
+
+	// the top level value has no key before it
+	return strings.TrimPrefix(strings.TrimPrefix(buf.String(), "\n"), " ")
+}
+
+// plainYAMLKey matches the keys that do not need quotes
+var plainYAMLKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.:-]*$`)
+
+// writeYAML writes a non empty object or array, with each line indented
+// with the given level
+func writeYAML(buf *bytes.Buffer, n nodes.Node, level int) {
+	indent := strings.Repeat("  ", level)
+
+	switch n := n.(type) {
+	case nodes.Object:
+		for _, k := range n.Keys() {
+			key := k
+			if !plainYAMLKey.MatchString(k) {
+				key = strconv.Quote(k)
+			}
+
+			buf.WriteString(indent + key + ":")
+			writeYAMLValue(buf, n[k], level+1)
+		}
+	case nodes.Array:
+		for _, v := range n {
+			buf.WriteString(indent + "-")
+			if obj, ok := v.(nodes.Object); ok && !isEmpty(obj) {
+				// the first key goes in the same line as the dash
+				var item bytes.Buffer
+				writeYAML(&item, obj, level+1)
+				buf.WriteString(" " + strings.TrimPrefix(item.String(), indent+"  "))
+				continue
+			}
+
+			writeYAMLValue(buf, v, level+1)
+		}
+	}
+}
+
+func writeYAMLValue(buf *bytes.Buffer, v nodes.Node, level int) {
+	switch v.(type) {
+	case nodes.Object, nodes.Array:
+		if !isEmpty(v) {
+			buf.WriteByte('\n')
+			writeYAML(buf, v, level)
+			return
+		}
+	}
+
+	buf.WriteString(" " + yamlScalar(v) + "\n")
+}
+
+func isEmpty(n nodes.Node) bool {
+	switch n := n.(type) {
+	case nodes.Object:
+		return len(n) == 0
+	case nodes.Array:
+		return len(n) == 0
+	}
+
+	return false
+}
+
+func yamlScalar(n nodes.Node) string {
+	switch n := n.(type) {
+	case nil:
+		return "null"
+	case nodes.Object:
+		return "{}"
+	case nodes.Array:
+		return "[]"
+	case nodes.String:
+		return strconv.Quote(string(n))
+	default:
+		return fmt.Sprint(n.Native())
+	}
+}
+
+// EncodeSExpression returns the tree as S-expressions, one per root node.
+// Each expression contains the node type, its content if any, and the
+// expressions of the children
+func EncodeSExpression(n nodes.Node) string {
+	var buf bytes.Buffer
+	for _, node := range Flatten(n) {
+		if node.Parent == nil {
+			writeSExpression(&buf, node)
+			buf.WriteByte('\n')
+		}
+	}
+
+	return buf.String()
+}
+
+func writeSExpression(buf *bytes.Buffer, n *Node) {
+	buf.WriteString("(" + nodeType(n))
+	if content := uast.ContentOf(n.Object); content != "" {
+		buf.WriteString(" " + strconv.Quote(content))
+	}
+
+	indent := strings.Repeat("  ", n.Depth+1)
+	for _, child := range n.Children {
+		buf.WriteString("\n" + indent)
+		writeSExpression(buf, child)
+	}
+
+	buf.WriteString(")")
+}
+
+// EncodeDOT returns the tree as a Graphviz directed graph. Each node is
+// labeled with its type and content, and each edge with the field that holds
+// the child node
+func EncodeDOT(n nodes.Node) string {
+	all := Flatten(n)
+
+	ids := make(map[*Node]int, len(all))
+	for i, node := range all {
+		ids[node] = i
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("digraph uast {\n")
+	buf.WriteString("  node [shape=box];\n")
+
+	for i, node := range all {
+		label := nodeType(node)
+		if content := uast.ContentOf(node.Object); content != "" {
+			label += "\n" + content
+		}
+
+		fmt.Fprintf(&buf, "  n%d [label=%s];\n", i, dotString(label))
+	}
+
+	for i, node := range all {
+		if node.Parent != nil {
+			fmt.Fprintf(&buf, "  n%d -> n%d [label=%s];\n",
+				ids[node.Parent], i, dotString(node.Field))
+		}
+	}
+
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+var dotReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+
+func dotString(s string) string {
+	return `"` + dotReplacer.Replace(s) + `"`
+}
+
+func nodeType(n *Node) string {
+	if typ := n.Type(); typ != "" {
+		return typ
+	}
+
+	return untypedNode
+}
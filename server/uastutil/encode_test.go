@@ -0,0 +1,106 @@
+package uastutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/bblfsh/sdk.v2/uast"
+	"gopkg.in/bblfsh/sdk.v2/uast/nodes"
+)
+
+func TestParseFormat(t *testing.T) {
+	f, err := ParseFormat("")
+	require.NoError(t, err)
+	require.Equal(t, JSON, f)
+
+	f, err = ParseFormat("dot")
+	require.NoError(t, err)
+	require.Equal(t, DOT, f)
+
+	_, err = ParseFormat("xml")
+	require.True(t, ErrInvalidFormat.Is(err))
+}
+
+func TestWithoutPositions(t *testing.T) {
+	tree := file(call("a", 1, 0, ident("x", 1, 3, 2)))
+
+	n := WithoutPositions(tree)
+	for _, node := range Flatten(n) {
+		_, ok := node.Object[uast.KeyPos]
+		require.False(t, ok, node.Path)
+	}
+
+	// the original tree is not modified
+	require.NotNil(t, Flatten(tree)[1].Positions())
+}
+
+func TestEncodeYAML(t *testing.T) {
+	tree := nodes.Object{
+		uast.KeyType: nodes.String("Call"),
+		"Arguments": nodes.Array{
+			nodes.Object{"Name": nodes.String("x"), "Flags": nodes.Array{nodes.Bool(true), nil}},
+			nodes.String("y: z"),
+		},
+		"Empty":   nodes.Object{},
+		"Nothing": nodes.Array{},
+		"Count":   nodes.Int(2),
+	}
+
+	expected := `"@type": "Call"
+Arguments:
+  - Flags:
+      - true
+      - null
+    Name: "x"
+  - "y: z"
+Count: 2
+Empty: {}
+Nothing: []
+`
+
+	require.Equal(t, expected, EncodeYAML(tree))
+	require.Equal(t, "\"a\"\n", EncodeYAML(nodes.String("a")))
+	require.Equal(t, "[]\n", EncodeYAML(nodes.Array{}))
+}
+
+func TestEncodeSExpression(t *testing.T) {
+	tree := file(call("print", 1, 0, ident("x", 1, 7, 6)))
+
+	expected := `(File
+  (Call
+    (uast:Identifier "x")
+    (uast:Identifier "print")))
+`
+	require.Equal(t, expected, EncodeSExpression(tree))
+
+	require.Equal(t, "(uast:Identifier \"a\")\n(uast:Identifier \"b\")\n",
+		EncodeSExpression(nodes.Array{ident("a", 1, 1, 0), ident("b", 1, 3, 2)}))
+}
+
+func TestEncodeDOT(t *testing.T) {
+	tree := call("print", 1, 0, ident(`"x"`, 1, 7, 6))
+
+	expected := `digraph uast {
+  node [shape=box];
+  n0 [label="Call"];
+  n1 [label="uast:Identifier\n\"x\""];
+  n2 [label="uast:Identifier\nprint"];
+  n0 -> n1 [label="Arguments"];
+  n0 -> n2 [label="Function"];
+}
+`
+	require.Equal(t, expected, EncodeDOT(tree))
+}
+
+func TestEncode(t *testing.T) {
+	tree := ident("x", 1, 1, 0)
+
+	for _, f := range formats {
+		v, err := Encode(tree, f)
+		require.NoError(t, err)
+		require.NotNil(t, v)
+	}
+
+	_, err := Encode(tree, "xml")
+	require.Error(t, err)
+}
@@ -0,0 +1,144 @@
+package uastutil
+
+import (
+	"strings"
+
+	"gopkg.in/bblfsh/sdk.v2/uast"
+	"gopkg.in/bblfsh/sdk.v2/uast/nodes"
+)
+
+// Location is a position in a file, given by a 0-based byte offset, or by a
+// 1-based line and column
+type Location struct {
+	Offset   uint32
+	Line     uint32
+	Col      uint32
+	ByOffset bool
+}
+
+// EnclosingNode is one of the nodes that contain a Location
+type EnclosingNode struct {
+	Type  string         `json:"@type"`
+	Roles nodes.Node     `json:"@role,omitempty"`
+	Pos   uast.Positions `json:"@pos,omitempty"`
+	Path  string         `json:"path"`
+}
+
+// Enclosing returns the chain of nodes that contain the location, from the
+// root to the innermost one. The nodes without positions, like the root of
+// some trees, are part of the chain if any of their children contain the
+// location. When several siblings contain it, the first one is followed
+func Enclosing(root nodes.Node, loc Location) []EnclosingNode {
+	var roots []*Node
+	for _, n := range Flatten(root) {
+		if n.Parent == nil {
+			roots = append(roots, n)
+		}
+	}
+
+	chain := enclosingChain(roots, loc)
+
+	result := make([]EnclosingNode, len(chain))
+	for i, n := range chain {
+		result[i] = EnclosingNode{
+			Type:  n.Type(),
+			Roles: n.Object[uast.KeyRoles],
+			Pos:   n.Positions(),
+			Path:  n.Path,
+		}
+	}
+
+	return result
+}
+
+// enclosingChain returns the chain of the first node between siblings that
+// contains the location
+func enclosingChain(siblings []*Node, loc Location) []*Node {
+	for _, n := range siblings {
+		contains, known := containsLocation(n.Positions(), loc)
+		if known && !contains {
+			continue
+		}
+
+		children := enclosingChain(n.Children, loc)
+		if !known && len(children) == 0 {
+			continue
+		}
+
+		return append([]*Node{n}, children...)
+	}
+
+	return nil
+}
+
+// containsLocation returns whether the positions contain the location. The
+// second value is false if the positions do not have a start
+func containsLocation(p uast.Positions, loc Location) (bool, bool) {
+	start := p.Start()
+	if start == nil {
+		return false, false
+	}
+
+	if before(loc, *start) {
+		return false, true
+	}
+
+	// the end is exclusive, so the nodes without length only contain their
+	// start
+	end := p.End()
+	if end == nil || *end == *start {
+		return isAt(loc, *start), true
+	}
+
+	return before(loc, *end), true
+}
+
+// before returns whether the location comes before the position
+func before(loc Location, p uast.Position) bool {
+	if loc.ByOffset {
+		return loc.Offset < p.Offset
+	}
+
+	return loc.Line < p.Line || (loc.Line == p.Line && loc.Col < p.Col)
+}
+
+func isAt(loc Location, p uast.Position) bool {
+	if loc.ByOffset {
+		return loc.Offset == p.Offset
+	}
+
+	return loc.Line == p.Line && loc.Col == p.Col
+}
+
+// Snippet returns the lines of the content spanned by the positions, up to
+// maxLines. It returns an empty string if the positions do not have a start
+func Snippet(content string, p uast.Positions, maxLines int) string {
+	start := p.Start()
+	if start == nil || start.Line == 0 {
+		return ""
+	}
+
+	first, last := int(start.Line), int(start.Line)
+	if end := p.End(); end != nil && int(end.Line) > first {
+		last = int(end.Line)
+		// the end is exclusive, so it does not span the line where it is
+		// if it is at its beginning
+		if end.Col <= 1 {
+			last--
+		}
+	}
+
+	if maxLines > 0 && last-first+1 > maxLines {
+		last = first + maxLines - 1
+	}
+
+	lines := strings.SplitAfter(content, "\n")
+	if first > len(lines) {
+		return ""
+	}
+	if last > len(lines) {
+		last = len(lines)
+	}
+
+	return strings.TrimRight(strings.Join(lines[first-1:last], ""), "\r\n")
+}
@@ -0,0 +1,62 @@
+package uastutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/bblfsh/sdk.v2/uast"
+)
+
+func TestEnclosing(t *testing.T) {
+	tree := file(call("print", 1, 0, ident("x", 1, 7, 6)))
+
+	paths := func(chain []EnclosingNode) []string {
+		var paths []string
+		for _, n := range chain {
+			paths = append(paths, n.Path)
+		}
+		return paths
+	}
+
+	chain := Enclosing(tree, Location{Offset: 6, ByOffset: true})
+	require.Equal(t, []string{
+		"/File[1]",
+		"/File[1]/Call[1]",
+		"/File[1]/Call[1]/uast:Identifier[1]",
+	}, paths(chain))
+	require.Equal(t, "uast:Identifier", chain[2].Type)
+	require.Equal(t, uint32(7), chain[2].Pos.Start().Col)
+	require.Nil(t, chain[0].Pos)
+
+	chain = Enclosing(tree, Location{Line: 1, Col: 3})
+	require.Equal(t, []string{
+		"/File[1]",
+		"/File[1]/Call[1]",
+		"/File[1]/Call[1]/uast:Identifier[2]",
+	}, paths(chain))
+
+	// the end is exclusive
+	chain = Enclosing(tree, Location{Offset: 7, ByOffset: true})
+	require.Equal(t, []string{"/File[1]", "/File[1]/Call[1]"}, paths(chain))
+
+	require.Empty(t, Enclosing(tree, Location{Offset: 20, ByOffset: true}))
+	require.Empty(t, Enclosing(tree, Location{Line: 2, Col: 1}))
+}
+
+func TestSnippet(t *testing.T) {
+	content := "package main\n\nfunc main() {\n\tlog.Fatal(err)\n}\n"
+	pos := func(startLine, startCol, endLine, endCol uint32) uast.Positions {
+		return uast.Positions{
+			uast.KeyStart: {Line: startLine, Col: startCol},
+			uast.KeyEnd:   {Line: endLine, Col: endCol},
+		}
+	}
+
+	require.Equal(t, "\tlog.Fatal(err)", Snippet(content, pos(4, 2, 4, 16), 0))
+	require.Equal(t, "func main() {\n\tlog.Fatal(err)\n}", Snippet(content, pos(3, 1, 5, 2), 0))
+	require.Equal(t, "func main() {", Snippet(content, pos(3, 1, 5, 2), 1))
+	require.Equal(t, "func main() {", Snippet(content, pos(3, 1, 4, 1), 0))
+	require.Equal(t, "package main", Snippet(content, uast.Positions{uast.KeyStart: {Line: 1, Col: 1}}, 0))
+	require.Equal(t, "", Snippet(content, nil, 0))
+	require.Equal(t, "", Snippet(content, pos(20, 1, 20, 2), 0))
+}
@@ -0,0 +1,100 @@
+package uastutil
+
+import (
+	"encoding/json"
+
+	"github.com/bblfsh/go-client/tools"
+	"gopkg.in/bblfsh/sdk.v2/uast"
+	"gopkg.in/bblfsh/sdk.v2/uast/nodes"
+	"gopkg.in/bblfsh/sdk.v2/uast/nodes/nodesproto"
+)
+
+// Stats is a summary of the size and shape of a UAST
+type Stats struct {
+	// Nodes is the number of UAST objects, without the positions
+	Nodes int `json:"nodes"`
+	// Leaves is the number of UAST objects without children objects
+	Leaves   int     `json:"leaves"`
+	MaxDepth int     `json:"maxDepth"`
+	AvgDepth float64 `json:"avgDepth"`
+	// Types is the number of nodes by @type
+	Types map[string]int `json:"types"`
+	// Roles is the number of nodes with each role
+	Roles map[string]int `json:"roles"`
+	// JSONSize and ProtobufSize are the size in bytes of the serialized
+	// tree
+	JSONSize     int `json:"jsonSize"`
+	ProtobufSize int `json:"protobufSize"`
+}
+
+// NewStats returns the stats of the tree
+func NewStats(root nodes.Node) (*Stats, error) {
+	stats := &Stats{
+		Types: make(map[string]int),
+		Roles: make(map[string]int),
+	}
+
+	iter := tools.NewIterator(root, tools.PreOrder)
+	for iter.Next() {
+		obj, ok := iter.Node().(nodes.Object)
+		if !ok {
+			continue
+		}
+
+		typ := uast.TypeOf(obj)
+		switch typ {
+		case uast.TypePositions, uast.TypePosition:
+			continue
+		case "":
+			typ = untypedNode
+		}
+
+		stats.Types[typ]++
+		for _, r := range uast.RolesOf(obj) {
+			stats.Roles[r.String()]++
+		}
+	}
+
+	var depths int
+	for _, n := range Flatten(root) {
+		stats.Nodes++
+		depths += n.Depth
+
+		if n.Depth > stats.MaxDepth {
+			stats.MaxDepth = n.Depth
+		}
+
+		if len(n.Children) == 0 {
+			stats.Leaves++
+		}
+	}
+
+	if stats.Nodes > 0 {
+		stats.AvgDepth = float64(depths) / float64(stats.Nodes)
+	}
+
+	size := &countWriter{}
+	if err := json.NewEncoder(size).Encode(root); err != nil {
+		return nil, err
+	}
+	// the encoder ends the value with a newline
+	stats.JSONSize = size.n - 1
+
+	size = &countWriter{}
+	if err := nodesproto.WriteTo(size, root); err != nil {
+		return nil, err
+	}
+	stats.ProtobufSize = size.n
+
+	return stats, nil
+}
+
+// countWriter counts the bytes written to it
+type countWriter struct {
+	n int
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += len(p)
+	return len(p), nil
+}
@@ -0,0 +1,49 @@
+package uastutil
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/bblfsh/sdk.v2/uast"
+	"gopkg.in/bblfsh/sdk.v2/uast/nodes"
+	"gopkg.in/bblfsh/sdk.v2/uast/role"
+)
+
+func TestNewStats(t *testing.T) {
+	x := ident("x", 1, 7, 6)
+	x[uast.KeyRoles] = uast.RoleList(role.Identifier, role.Expression)
+	tree := file(call("print", 1, 0, x), call("exit", 2, 11))
+
+	stats, err := NewStats(tree)
+	require.NoError(t, err)
+
+	require.Equal(t, 6, stats.Nodes)
+	require.Equal(t, 3, stats.Leaves)
+	require.Equal(t, 2, stats.MaxDepth)
+	require.InDelta(t, 8.0/6.0, stats.AvgDepth, 0.0001)
+	require.Equal(t, map[string]int{
+		"File":            1,
+		"Call":            2,
+		"uast:Identifier": 3,
+	}, stats.Types)
+	require.Equal(t, map[string]int{
+		"Identifier": 1,
+		"Expression": 1,
+		// the native nodes without roles
+		"Unannotated": 3,
+	}, stats.Roles)
+	data, err := json.Marshal(tree)
+	require.NoError(t, err)
+	require.Equal(t, len(data), stats.JSONSize)
+	require.True(t, stats.ProtobufSize > 0)
+}
+
+func TestNewStatsEmpty(t *testing.T) {
+	stats, err := NewStats(nodes.Array{})
+	require.NoError(t, err)
+
+	require.Equal(t, 0, stats.Nodes)
+	require.Equal(t, 0.0, stats.AvgDepth)
+	require.Empty(t, stats.Types)
+}
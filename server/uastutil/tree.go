@@ -0,0 +1,95 @@
+// Package uastutil contains helpers to inspect and compare the UAST trees
+// returned by bblfsh.
+package uastutil
+
+import (
+	"fmt"
+
+	"gopkg.in/bblfsh/sdk.v2/uast"
+	"gopkg.in/bblfsh/sdk.v2/uast/nodes"
+)
+
+// untypedNode is used in the paths for the objects without @type
+const untypedNode = "*"
+
+// Node is a UAST object found while walking a tree, with the information
+// about where it is in the tree
+type Node struct {
+	Object nodes.Object
+	// Parent is nil for the root nodes
+	Parent *Node
+	// Children are the objects directly under this one, ordered by field
+	// name and array index
+	Children []*Node
+	// Field is the key of the parent object that holds this node
+	Field string
+	// Path is similar to an XPath expression that selects this node, made of
+	// the node types and their 1-based index between the siblings of the
+	// same type. For example /uast:File[1]/uast:Function[2]
+	Path string
+	// Depth is 0 for the root nodes
+	Depth int
+}
+
+// Type returns the @type of the node
+func (n *Node) Type() string {
+	return uast.TypeOf(n.Object)
+}
+
+// Positions returns the @pos of the node
+func (n *Node) Positions() uast.Positions {
+	return uast.PositionsOf(n.Object)
+}
+
+// Flatten returns all the UAST objects of the tree in pre-order. The tree can
+// be a single object, or an array of them, like the results of a filter.
+// Positions are not considered UAST objects
+func Flatten(root nodes.Node) []*Node {
+	var all []*Node
+	roots := walk(root, nil, "", 0, &all)
+	setPaths("", roots)
+	return all
+}
+
+// walk adds to all the objects found in n, and returns them
+func walk(n nodes.Node, parent *Node, field string, depth int, all *[]*Node) []*Node {
+	var found []*Node
+
+	switch n := n.(type) {
+	case nodes.Object:
+		node := &Node{Object: n, Parent: parent, Field: field, Depth: depth}
+		*all = append(*all, node)
+
+		for _, k := range n.Keys() {
+			if k == uast.KeyPos {
+				continue
+			}
+
+			node.Children = append(node.Children, walk(n[k], node, k, depth+1, all)...)
+		}
+
+		found = append(found, node)
+	case nodes.Array:
+		for _, v := range n {
+			found = append(found, walk(v, parent, field, depth, all)...)
+		}
+	}
+
+	return found
+}
+
+// setPaths sets the path of the siblings and their descendants
+func setPaths(prefix string, siblings []*Node) {
+	count := make(map[string]int)
+	for _, n := range siblings {
+		typ := n.Type()
+		if typ == "" {
+			typ = untypedNode
+		}
+
+		count[typ]++
+		n.Path = fmt.Sprintf("%s/%s[%d]", prefix, typ, count[typ])
+
+		setPaths(n.Path, n.Children)
+	}
+}
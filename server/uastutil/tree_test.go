@@ -0,0 +1,46 @@
+package uastutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/bblfsh/sdk.v2/uast/nodes"
+)
+
+func TestFlatten(t *testing.T) {
+	tree := file(
+		call("a", 1, 0, ident("x", 1, 3, 2)),
+		call("b", 2, 11),
+	)
+
+	all := Flatten(tree)
+
+	var paths []string
+	for _, n := range all {
+		paths = append(paths, n.Path)
+	}
+
+	require.Equal(t, []string{
+		"/File[1]",
+		"/File[1]/Call[1]",
+		"/File[1]/Call[1]/uast:Identifier[1]",
+		"/File[1]/Call[1]/uast:Identifier[2]",
+		"/File[1]/Call[2]",
+		"/File[1]/Call[2]/uast:Identifier[1]",
+	}, paths)
+
+	require.Nil(t, all[0].Parent)
+	require.Equal(t, all[0], all[1].Parent)
+	require.Equal(t, "Arguments", all[2].Field)
+	require.Equal(t, "Function", all[3].Field)
+	require.Equal(t, 2, all[3].Depth)
+	require.Equal(t, uint32(2), all[4].Positions().Start().Line)
+}
+
+func TestFlattenArray(t *testing.T) {
+	all := Flatten(nodes.Array{ident("a", 1, 1, 0), ident("b", 1, 3, 2)})
+
+	require.Len(t, all, 2)
+	require.Equal(t, "/uast:Identifier[2]", all[1].Path)
+	require.Nil(t, all[1].Parent)
+}
@@ -0,0 +1,38 @@
+package uastutil
+
+import (
+	"gopkg.in/bblfsh/sdk.v2/uast"
+	"gopkg.in/bblfsh/sdk.v2/uast/nodes"
+)
+
+// pos returns a @pos object for a node in the given line
+func pos(line, col, offset, length int) nodes.Object {
+	return uast.Positions{
+		uast.KeyStart: {Offset: uint32(offset), Line: uint32(line), Col: uint32(col)},
+		uast.KeyEnd:   {Offset: uint32(offset + length), Line: uint32(line), Col: uint32(col + length)},
+	}.ToObject()
+}
+
+func ident(name string, line, col, offset int) nodes.Object {
+	return nodes.Object{
+		uast.KeyType: nodes.String("uast:Identifier"),
+		uast.KeyPos:  pos(line, col, offset, len(name)),
+		"Name":       nodes.String(name),
+	}
+}
+
+func call(name string, line, offset int, args ...nodes.Node) nodes.Object {
+	return nodes.Object{
+		uast.KeyType: nodes.String("Call"),
+		uast.KeyPos:  pos(line, 1, offset, 10),
+		"Function":   ident(name, line, 1, offset),
+		"Arguments":  nodes.Array(args),
+	}
+}
+
+func file(body ...nodes.Node) nodes.Object {
+	return nodes.Object{
+		uast.KeyType: nodes.String("File"),
+		"Body":       nodes.Array(body),
+	}
+}